@@ -4,8 +4,6 @@ import (
 	"fmt"
 	"math"
 
-	"github.com/cwbudde/go-sq-tool/internal/decoder"
-	"github.com/cwbudde/go-sq-tool/internal/encoder"
 	"github.com/cwbudde/go-sq-tool/internal/metrics"
 	"github.com/cwbudde/go-sq-tool/internal/wav"
 	"github.com/spf13/cobra"
@@ -39,6 +37,12 @@ func runAnalyze(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return fmt.Errorf("failed to read input WAV: %w", err)
 	}
+	warnInputOvers(inputFile, audioData)
+
+	logicConfig, err := logicSteeringConfig()
+	if err != nil {
+		return err
+	}
 
 	channelNames := []string{"LF", "RF", "LB", "RB"}
 	fmt.Printf("Separation analysis (encode -> decode, isolated channels)\n")
@@ -46,6 +50,12 @@ func runAnalyze(cmd *cobra.Command, args []string) error {
 	if logic {
 		fmt.Printf("Logic steering: enabled\n")
 	}
+	if waveMatching {
+		fmt.Printf("Wave matching: enabled\n")
+	}
+	if spectral {
+		fmt.Printf("Spectral steering: enabled\n")
+	}
 	fmt.Printf("\nChannel  TargetRMS   LeakRMS  Sep(dB)\n")
 
 	switch analyzeLeakMode {
@@ -69,11 +79,13 @@ func runAnalyze(cmd *cobra.Command, args []string) error {
 
 	var decodedFull [][]float64
 	if analyzePairMode == "full" {
-		fullEncoder := encoder.NewSQEncoderWithParams(blockSize, overlap)
-		fullDecoder := decoder.NewSQDecoderWithParams(blockSize, overlap)
-		fullDecoder.SetSampleRate(int(audioData.SampleRate))
-		if logic {
-			fullDecoder.EnableLogicSteering(true)
+		fullEncoder, err := newEncoder(audioData.SampleRate)
+		if err != nil {
+			return err
+		}
+		fullDecoder, err := newDecoder(audioData.SampleRate, logicConfig)
+		if err != nil {
+			return err
 		}
 
 		encodedFull, err := fullEncoder.Process(audioData.Samples)
@@ -93,11 +105,13 @@ func runAnalyze(cmd *cobra.Command, args []string) error {
 		}
 		copy(isolated[ch], audioData.Samples[ch])
 
-		sqEncoder := encoder.NewSQEncoderWithParams(blockSize, overlap)
-		sqDecoder := decoder.NewSQDecoderWithParams(blockSize, overlap)
-		sqDecoder.SetSampleRate(int(audioData.SampleRate))
-		if logic {
-			sqDecoder.EnableLogicSteering(true)
+		sqEncoder, err := newEncoder(audioData.SampleRate)
+		if err != nil {
+			return err
+		}
+		sqDecoder, err := newDecoder(audioData.SampleRate, logicConfig)
+		if err != nil {
+			return err
 		}
 
 		encoded, err := sqEncoder.Process(isolated)
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/cwbudde/go-sq-tool/internal/decoder"
+	"github.com/cwbudde/go-sq-tool/internal/wav"
+)
+
+// hrirFileName matches the files of an HRIR set: az<degrees>.wav.
+var hrirFileName = regexp.MustCompile(`(?i)^az(-?\d+(?:\.\d+)?)\.wav$`)
+
+// parseSpeakerAzimuths parses the --speaker-azimuths list of LF, RF, LB, RB.
+func parseSpeakerAzimuths(s string) ([4]float64, error) {
+	var azimuths [4]float64
+	fields := strings.Split(s, ",")
+	if len(fields) != 4 {
+		return azimuths, fmt.Errorf("--speaker-azimuths needs 4 values (LF,RF,LB,RB), got %d", len(fields))
+	}
+	for i, field := range fields {
+		v, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return azimuths, fmt.Errorf("invalid speaker azimuth %q: %w", field, err)
+		}
+		azimuths[i] = v
+	}
+	return azimuths, nil
+}
+
+// binauralHRIRs returns the HRIRs of the four speakers, either from the
+// --hrir-dir set or from the spherical head model.
+func binauralHRIRs(sampleRate uint32) ([4]decoder.HRIR, error) {
+	azimuths, err := parseSpeakerAzimuths(decodeAzimuths)
+	if err != nil {
+		return [4]decoder.HRIR{}, err
+	}
+	if decodeHRIRDir == "" {
+		return decoder.SphericalHeadHRIRs(azimuths, int(sampleRate)), nil
+	}
+	return loadHRIRSet(decodeHRIRDir, azimuths, sampleRate)
+}
+
+// loadHRIRSet loads, for every speaker, the stereo HRIR WAV of dir whose
+// azimuth is nearest to the speaker's.
+func loadHRIRSet(dir string, azimuths [4]float64, sampleRate uint32) ([4]decoder.HRIR, error) {
+	var hrirs [4]decoder.HRIR
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return hrirs, fmt.Errorf("failed to read HRIR directory: %w", err)
+	}
+	available := make(map[float64]string)
+	for _, entry := range entries {
+		match := hrirFileName.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		azimuth, err := strconv.ParseFloat(match[1], 64)
+		if err != nil {
+			continue
+		}
+		available[azimuth] = filepath.Join(dir, entry.Name())
+	}
+	if len(available) == 0 {
+		return hrirs, fmt.Errorf("no az<degrees>.wav files in HRIR directory %s", dir)
+	}
+
+	for ch, azimuth := range azimuths {
+		path, best := "", math.Inf(1)
+		for candidate, file := range available {
+			d := math.Abs(math.Remainder(candidate-azimuth, 360))
+			if d < best || (d == best && file < path) {
+				path, best = file, d
+			}
+		}
+
+		data, err := wav.ReadWAVChannels(path, 2)
+		if err != nil {
+			return hrirs, fmt.Errorf("failed to load HRIR %s: %w", path, err)
+		}
+		warnInputOvers(path, data)
+		if data.SampleRate != sampleRate {
+			return hrirs, fmt.Errorf("HRIR %s has sample rate %d Hz, input has %d Hz", path, data.SampleRate, sampleRate)
+		}
+		hrirs[ch] = decoder.HRIR{Left: data.Samples[0], Right: data.Samples[1]}
+	}
+	return hrirs, nil
+}
@@ -1,7 +1,13 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
 
 	"github.com/cwbudde/go-sq-tool/internal/decoder"
 	"github.com/cwbudde/go-sq-tool/internal/wav"
@@ -15,6 +21,247 @@ var decodeCmd = &cobra.Command{
 	RunE:  runDecode,
 }
 
+var (
+	decodeLayout         string
+	decodeLFECrossover   float64
+	decodeBassManagement bool
+	decodeAzimuths       string
+	decodeHRIRDir        string
+
+	decodeUpmix     bool
+	decodeWidth     float64
+	decodeRearLevel float64
+	decodeRearDelay float64
+
+	decodeDecorrelate           string
+	decodeDecorrelateCrossovers string
+	decodeDecorrelateStrengths  string
+)
+
+// monoFoldDownLimit is the mono fold-down colouration in dB above which
+// decode warns about the rear decorrelation.
+const monoFoldDownLimit = 3.0
+
+// --layout values handled outside the decoder's speaker layouts.
+const (
+	binauralLayout = "binaural"
+	ambiXLayout    = "ambix"
+)
+
+// extraLayouts are the --layout values besides the speaker layouts.
+var extraLayouts = []string{binauralLayout, ambiXLayout}
+
+func init() {
+	decodeCmd.Flags().StringVar(&decodeLayout, "layout", decoder.LayoutQuad.Name,
+		"output layout: "+strings.Join(append(decoder.LayoutNames(), extraLayouts...), ", "))
+	decodeCmd.Flags().Float64Var(&decodeLFECrossover, "lfe-crossover", decoder.DefaultLayoutConfig().LFECrossover,
+		"LFE lowpass frequency in Hz (5.1, 6.1, 7.1)")
+	decodeCmd.Flags().BoolVar(&decodeBassManagement, "bass-management", false,
+		"move the content below --lfe-crossover from the main channels to the LFE")
+	decodeCmd.Flags().StringVar(&decodeAzimuths, "speaker-azimuths", "45,-45,135,-135",
+		"binaural speaker azimuths of LF,RF,LB,RB in degrees (0 = front, positive to the left)")
+	decodeCmd.Flags().StringVar(&decodeHRIRDir, "hrir-dir", "",
+		"directory of stereo HRIR WAVs named az<degrees>.wav at the input sample rate (default: spherical head model)")
+
+	upmix := decoder.DefaultUpmixConfig()
+	decodeCmd.Flags().BoolVar(&decodeUpmix, "upmix", false,
+		"input is plain (non-matrixed) stereo: fronts get the direct sound, rears the extracted ambience")
+	decodeCmd.Flags().Float64Var(&decodeWidth, "width", upmix.Width,
+		"upmix: front width (0 = mono, 1 = original, 2 = twice as wide)")
+	decodeCmd.Flags().Float64Var(&decodeRearLevel, "rear-level", upmix.RearLevel,
+		"upmix: level of the ambience in the rears in dB")
+	decodeCmd.Flags().Float64Var(&decodeRearDelay, "rear-delay", upmix.RearDelay*1000.0,
+		"upmix: delay of the rears in ms")
+
+	decorrelation := decoder.DefaultDecorrelationConfig()
+	decodeCmd.Flags().StringVar(&decodeDecorrelate, "decorrelate", "",
+		"decorrelate the rear outputs: "+decoder.DecorrelationAllpass+" or "+decoder.DecorrelationVelvet+" (default off)")
+	decodeCmd.Flags().StringVar(&decodeDecorrelateCrossovers, "decorrelate-crossovers", formatList(decorrelation.Crossovers),
+		"comma-separated band-split crossover frequencies for the rear decorrelation in Hz")
+	decodeCmd.Flags().StringVar(&decodeDecorrelateStrengths, "decorrelate-strengths", formatList(decorrelation.Strengths),
+		"comma-separated decorrelation strength per band from low to high (0 = off, 1 = full)")
+}
+
+// configureDecorrelation applies the --decorrelate flags to sqDecoder and
+// returns the mono fold-down deviation in dB and its frequency.
+func configureDecorrelation(sqDecoder quadDecoder) (float64, float64, error) {
+	passive, ok := sqDecoder.(*decoder.SQDecoder)
+	if !ok {
+		return 0, 0, fmt.Errorf("--decorrelate cannot be combined with --spectral or --upmix")
+	}
+	crossovers, err := parseFrequencyList(decodeDecorrelateCrossovers)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid decorrelate-crossovers: %w", err)
+	}
+	var strengths []float64
+	for _, part := range strings.Split(decodeDecorrelateStrengths, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		s, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid decorrelate-strengths: parse %q: %w", part, err)
+		}
+		strengths = append(strengths, s)
+	}
+
+	config := decoder.DecorrelationConfig{
+		Enabled:    true,
+		Method:     strings.ToLower(strings.TrimSpace(decodeDecorrelate)),
+		Crossovers: crossovers,
+		Strengths:  strengths,
+		Seed:       decoder.DefaultDecorrelationConfig().Seed,
+	}
+	if err := passive.SetDecorrelationConfig(config); err != nil {
+		return 0, 0, err
+	}
+	deviation, freq := passive.MonoFoldDownDeviation()
+	return deviation, freq, nil
+}
+
+// formatList formats values as a comma-separated flag default.
+func formatList(values []float64) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return strings.Join(parts, ",")
+}
+
+// newUpmixer creates the stereo upmixer selected by the --upmix flags.
+func newUpmixer(sampleRate uint32) (*decoder.Upmixer, error) {
+	if logic || waveMatching || spectral || iir {
+		return nil, fmt.Errorf("--upmix cannot be combined with --logic, --wave-matching, --spectral or --iir")
+	}
+	if decodeWidth < 0 || decodeWidth > 2 {
+		return nil, fmt.Errorf("--width must be in [0, 2], got %g", decodeWidth)
+	}
+	if decodeRearDelay < 0 {
+		return nil, fmt.Errorf("--rear-delay must be >= 0, got %g", decodeRearDelay)
+	}
+
+	config := decoder.DefaultUpmixConfig()
+	config.Width = decodeWidth
+	config.RearLevel = decodeRearLevel
+	config.RearDelay = decodeRearDelay / 1000.0
+
+	upmixer, err := decoder.NewUpmixerWithParams(blockSize, overlap)
+	if err != nil {
+		return nil, err
+	}
+	upmixer.SetSampleRate(int(sampleRate))
+	upmixer.SetConfig(config)
+	return upmixer, nil
+}
+
+// speakerMasks maps layout channel labels to WAV speaker positions.
+var speakerMasks = map[string]wav.ChannelMask{
+	decoder.ChannelFrontLeft:   wav.SpeakerFrontLeft,
+	decoder.ChannelFrontRight:  wav.SpeakerFrontRight,
+	decoder.ChannelFrontCenter: wav.SpeakerFrontCenter,
+	decoder.ChannelLFE:         wav.SpeakerLowFrequency,
+	decoder.ChannelBackLeft:    wav.SpeakerBackLeft,
+	decoder.ChannelBackRight:   wav.SpeakerBackRight,
+	decoder.ChannelBackCenter:  wav.SpeakerBackCenter,
+	decoder.ChannelSideLeft:    wav.SpeakerSideLeft,
+	decoder.ChannelSideRight:   wav.SpeakerSideRight,
+}
+
+// outputRenderer renders decoded LF, RF, LB, RB chunks to the output channels.
+type outputRenderer interface {
+	ProcessChunk(input [][]float64) ([][]float64, error)
+	Flush() [][]float64
+}
+
+// outputStage describes the output of the --layout flag.
+type outputStage struct {
+	name     string
+	channels []string
+	// mask selects a WAVE_FORMAT_EXTENSIBLE header; 0 writes a plain one.
+	mask wav.ChannelMask
+	// renderer is nil when the decoder output is written as is.
+	renderer outputRenderer
+}
+
+// newOutputStage returns the output stage of the --layout flag for the
+// output of sqDecoder.
+func newOutputStage(sampleRate uint32, sqDecoder quadDecoder) (*outputStage, error) {
+	if strings.EqualFold(strings.TrimSpace(decodeLayout), ambiXLayout) {
+		stage := &outputStage{name: ambiXLayout, channels: decoder.AmbiXChannels}
+		// The spectral decoder encodes its per-bin direction estimate itself.
+		if spectralDecoder, ok := sqDecoder.(*decoder.SpectralDecoder); ok {
+			spectralDecoder.SetAmbiXOutput(true)
+			return stage, nil
+		}
+		stage.renderer = decoder.NewAmbiXRenderer()
+		return stage, nil
+	}
+	if strings.EqualFold(strings.TrimSpace(decodeLayout), binauralLayout) {
+		hrirs, err := binauralHRIRs(sampleRate)
+		if err != nil {
+			return nil, err
+		}
+		renderer, err := decoder.NewBinauralRenderer(hrirs)
+		if err != nil {
+			return nil, err
+		}
+		return &outputStage{name: binauralLayout, channels: []string{"L", "R"}, renderer: renderer}, nil
+	}
+
+	layout, err := decoder.LookupLayout(decodeLayout)
+	if err != nil {
+		return nil, fmt.Errorf("unknown layout %q (use %s)", decodeLayout,
+			strings.Join(append(decoder.LayoutNames(), extraLayouts...), ", "))
+	}
+	if layout.Name == decoder.LayoutQuad.Name {
+		return &outputStage{name: layout.Name, channels: layout.Channels, mask: wav.MaskQuad}, nil
+	}
+	if decodeLFECrossover <= 0 || decodeLFECrossover >= float64(sampleRate)/2 {
+		return nil, fmt.Errorf("--lfe-crossover must be in (0, %d) Hz", sampleRate/2)
+	}
+
+	config := decoder.DefaultLayoutConfig()
+	config.LFECrossover = decodeLFECrossover
+	config.BassManagement = decodeBassManagement
+	stage := &outputStage{
+		name:     layout.Name,
+		channels: layout.Channels,
+		renderer: decoder.NewLayoutRenderer(layout, int(sampleRate), config),
+	}
+	for _, label := range layout.Channels {
+		stage.mask |= speakerMasks[label]
+	}
+	return stage, nil
+}
+
+// newWriter creates the output WAV writer of the stage.
+func (s *outputStage) newWriter(out io.WriteSeeker, sampleRate uint32, format wav.SampleFormat) (*wav.Writer, error) {
+	if s.mask != 0 {
+		return wav.NewWriterWithChannelMask(out, sampleRate, s.mask, format)
+	}
+	return wav.NewWriter(out, sampleRate, len(s.channels), format)
+}
+
+// write renders decoded quad audio and writes it.
+func (s *outputStage) write(writer *wav.Writer, decoded [][]float64) error {
+	if s.renderer != nil {
+		rendered, err := s.renderer.ProcessChunk(decoded)
+		if err != nil {
+			return err
+		}
+		decoded = rendered
+	}
+	return writer.WriteFrames(decoded)
+}
+
+// flush writes the samples still buffered by the renderer.
+func (s *outputStage) flush(writer *wav.Writer) error {
+	if s.renderer == nil {
+		return nil
+	}
+	return writer.WriteFrames(s.renderer.Flush())
+}
+
 func runDecode(cmd *cobra.Command, args []string) error {
 	inputFile := args[0]
 	outputFile := args[1]
@@ -24,76 +271,180 @@ func runDecode(cmd *cobra.Command, args []string) error {
 		fmt.Printf("=======================\n\n")
 	}
 
-	// Read input WAV
+	// Open input WAV
 	if verbose {
 		fmt.Printf("Reading input file: %s\n", inputFile)
 	}
 
-	audioData, err := wav.ReadWAV(inputFile)
+	in, err := os.Open(inputFile)
+	if err != nil {
+		return fmt.Errorf("failed to open input WAV: %w", err)
+	}
+	defer in.Close()
+
+	reader, err := wav.NewReader(in, 2)
 	if err != nil {
 		return fmt.Errorf("failed to read input WAV: %w", err)
 	}
 
 	if verbose {
-		fmt.Printf("  Sample rate: %d Hz\n", audioData.SampleRate)
-		fmt.Printf("  Samples: %d\n", audioData.NumSamples)
-		fmt.Printf("  Duration: %.2f seconds\n\n", float64(audioData.NumSamples)/float64(audioData.SampleRate))
+		fmt.Printf("  Sample rate: %d Hz\n", reader.SampleRate())
+		fmt.Printf("  Samples: %d\n", reader.NumSamples())
+		fmt.Printf("  Duration: %.2f seconds\n\n", float64(reader.NumSamples())/float64(reader.SampleRate()))
+	}
+
+	logicConfig, err := logicSteeringConfig()
+	if err != nil {
+		return err
+	}
+	format, err := outputFormat()
+	if err != nil {
+		return err
+	}
+	dither, err := outputDither()
+	if err != nil {
+		return err
+	}
+	clip, err := outputClip()
+	if err != nil {
+		return err
 	}
 
 	// Create decoder
-	sqDecoder := decoder.NewSQDecoderWithParams(blockSize, overlap)
-	sqDecoder.SetSampleRate(int(audioData.SampleRate))
-	if logic {
-		sqDecoder.EnableLogicSteering(true)
+	var sqDecoder quadDecoder
+	if decodeUpmix {
+		sqDecoder, err = newUpmixer(reader.SampleRate())
+	} else {
+		sqDecoder, err = newDecoder(reader.SampleRate(), logicConfig)
+	}
+	if err != nil {
+		return err
+	}
+	var monoDeviation, monoFreq float64
+	if decodeDecorrelate != "" {
+		monoDeviation, monoFreq, err = configureDecorrelation(sqDecoder)
+		if err != nil {
+			return err
+		}
+		if math.Abs(monoDeviation) > monoFoldDownLimit {
+			fmt.Fprintf(os.Stderr, "Warning: rear decorrelation changes the mono fold-down by %+.1f dB around %.0f Hz; lower the strength of that band\n",
+				monoDeviation, monoFreq)
+		}
+	}
+	stage, err := newOutputStage(reader.SampleRate(), sqDecoder)
+	if err != nil {
+		return err
 	}
 
 	if verbose {
 		fmt.Printf("Decoder configuration:\n")
-		fmt.Printf("  Block size: %d samples\n", blockSize)
-		fmt.Printf("  Overlap: %d samples\n", overlap)
+		printPhaseShifter()
 		if logic {
 			fmt.Printf("  Logic steering: enabled\n")
+			if len(logicConfig.Crossovers) > 0 {
+				fmt.Printf("  Logic crossovers: %v Hz\n", logicConfig.Crossovers)
+			}
+		}
+		if waveMatching {
+			fmt.Printf("  Wave matching: enabled\n")
+		}
+		if spectral {
+			fmt.Printf("  Spectral steering: enabled\n")
+		}
+		if decodeDecorrelate != "" {
+			fmt.Printf("  Rear decorrelation: %s, strengths %s at %s Hz\n",
+				decodeDecorrelate, decodeDecorrelateStrengths, decodeDecorrelateCrossovers)
+			fmt.Printf("  Mono fold-down: within %.1f dB (worst around %.0f Hz)\n", math.Abs(monoDeviation), monoFreq)
+		}
+		if decodeUpmix {
+			fmt.Printf("  Upmix: width %.2f, rear level %+.1f dB, rear delay %.1f ms\n",
+				decodeWidth, decodeRearLevel, decodeRearDelay)
+		}
+		if stage.name != decoder.LayoutQuad.Name {
+			fmt.Printf("  Layout: %s (%s)\n", stage.name, strings.Join(stage.channels, ", "))
+		}
+		if stage.name == binauralLayout {
+			if decodeHRIRDir != "" {
+				fmt.Printf("  HRIRs: %s\n", decodeHRIRDir)
+			} else {
+				fmt.Printf("  HRIRs: spherical head model\n")
+			}
+			fmt.Printf("  Speaker azimuths: %s degrees\n", decodeAzimuths)
+		}
+		if stage.mask&wav.SpeakerLowFrequency != 0 {
+			if decodeBassManagement {
+				fmt.Printf("  Bass management: below %.0f Hz\n", decodeLFECrossover)
+			} else {
+				fmt.Printf("  LFE: below %.0f Hz\n", decodeLFECrossover)
+			}
 		}
 		fmt.Printf("  Latency: %d samples (%.2f ms)\n\n",
 			sqDecoder.GetLatency(),
-			float64(sqDecoder.GetLatency())/float64(audioData.SampleRate)*1000.0)
+			float64(sqDecoder.GetLatency())/float64(reader.SampleRate())*1000.0)
+		fmt.Printf("Writing output file: %s\n", outputFile)
+		fmt.Printf("  Format: %s\n", format.Description())
+		if !format.IsFloat() && dither.Mode != wav.DitherNone {
+			fmt.Printf("  Dither: %s (seed %d)\n", dither.Mode, dither.Seed)
+		}
 		fmt.Printf("Processing...\n")
 	}
 
-	// Decode
-	output, err := sqDecoder.Process(audioData.Samples)
+	out, err := os.Create(outputFile)
 	if err != nil {
-		return fmt.Errorf("decoding failed: %w", err)
+		return fmt.Errorf("failed to create output WAV: %w", err)
 	}
+	defer out.Close()
 
-	// Prepare output data
-	outputData := &wav.AudioData{
-		SampleRate: audioData.SampleRate,
-		Samples:    output,
-		NumSamples: audioData.NumSamples,
+	writer, err := stage.newWriter(out, reader.SampleRate(), format)
+	if err != nil {
+		return fmt.Errorf("failed to write output WAV: %w", err)
 	}
+	writer.SetDither(dither)
+	writer.SetClip(clip)
 
-	// Write output WAV
-	if verbose {
-		fmt.Printf("Writing output file: %s\n", outputFile)
-		if float32 {
-			fmt.Printf("  Format: 32-bit IEEE float\n")
-		} else {
-			fmt.Printf("  Format: 16-bit PCM\n")
-		}
+	// Decode chunk by chunk
+	chunk := make([][]float64, 2)
+	for ch := range chunk {
+		chunk[ch] = make([]float64, streamChunkSize)
 	}
+	for {
+		n, readErr := reader.ReadFrames(chunk)
+		if readErr != nil && !errors.Is(readErr, io.EOF) {
+			return fmt.Errorf("failed to read input WAV: %w", readErr)
+		}
 
-	if float32 {
-		if err := wav.WriteFloat32WAV(outputFile, outputData); err != nil {
-			return fmt.Errorf("failed to write output WAV: %w", err)
+		decoded, err := sqDecoder.ProcessChunk([][]float64{chunk[0][:n], chunk[1][:n]})
+		if err != nil {
+			return fmt.Errorf("decoding failed: %w", err)
 		}
-	} else {
-		if err := wav.WriteWAV(outputFile, outputData); err != nil {
+		if err := stage.write(writer, decoded); err != nil {
 			return fmt.Errorf("failed to write output WAV: %w", err)
 		}
+
+		if errors.Is(readErr, io.EOF) {
+			break
+		}
 	}
 
-	if verbose {
+	if err := stage.write(writer, sqDecoder.Flush()); err != nil {
+		return fmt.Errorf("failed to write output WAV: %w", err)
+	}
+	if err := stage.flush(writer); err != nil {
+		return fmt.Errorf("failed to write output WAV: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to write output WAV: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to close output WAV: %w", err)
+	}
+	warnInputOvers(inputFile, reader)
+	warnOutputOvers(outputFile, writer, format, clip)
+
+	if verbose && stage.name != decoder.LayoutQuad.Name {
+		fmt.Printf("\nDone! Decoded to %s.\n", stage.name)
+		fmt.Printf("Channels: %s\n", strings.Join(stage.channels, ", "))
+	} else if verbose {
 		fmt.Printf("\nDone! Decoded to 4-channel quadrophonic audio.\n")
 		fmt.Printf("Channels: LF (Left Front), RF (Right Front), LB (Left Back), RB (Right Back)\n")
 	} else {
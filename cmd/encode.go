@@ -1,20 +1,125 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"strings"
 
 	"github.com/cwbudde/go-sq-tool/internal/encoder"
+	"github.com/cwbudde/go-sq-tool/internal/matrix"
 	"github.com/cwbudde/go-sq-tool/internal/wav"
 	"github.com/spf13/cobra"
 )
 
 var encodeCmd = &cobra.Command{
 	Use:   "encode [input.wav] [output.wav]",
-	Short: "Encode quadrophonic WAV to SQ-encoded stereo",
+	Short: "Encode quadrophonic or 5.1 WAV to SQ-encoded stereo",
 	Args:  cobra.ExactArgs(2),
 	RunE:  runEncode,
 }
 
+var (
+	encodeAmbiX           bool
+	encodeLayout          string
+	encodeFrontAzimuth    float64
+	encodeSurroundAzimuth float64
+	encodeLFEGain         float64
+	encodeLFECutoff       float64
+)
+
+// surroundInputLayout is the --layout value of 5.1 input.
+const surroundInputLayout = "5.1"
+
+func init() {
+	surround := encoder.DefaultSurroundConfig()
+	encodeCmd.Flags().BoolVar(&encodeAmbiX, "ambix", false,
+		"input is first-order AmbiX (W, Y, Z, X; ACN/SN3D) instead of LF, RF, LB, RB")
+	encodeCmd.Flags().StringVar(&encodeLayout, "layout", "quad",
+		"input layout: quad (LF, RF, LB, RB) or 5.1 (L, R, C, LFE, Ls, Rs)")
+	encodeCmd.Flags().Float64Var(&encodeFrontAzimuth, "front-azimuth", surround.FrontAzimuth,
+		"5.1: azimuth of L/R in degrees (45 = LF/RF corners, 30 = ITU)")
+	encodeCmd.Flags().Float64Var(&encodeSurroundAzimuth, "surround-azimuth", surround.SurroundAzimuth,
+		"5.1: azimuth of Ls/Rs in degrees")
+	encodeCmd.Flags().Float64Var(&encodeLFEGain, "lfe-gain", surround.LFEGain,
+		"5.1: gain of the LFE folded into the front centre in dB")
+	encodeCmd.Flags().Float64Var(&encodeLFECutoff, "lfe-cutoff", surround.LFECutoff,
+		"5.1: LFE lowpass frequency in Hz (0 disables it)")
+}
+
+// encodeInputChannels returns the channel count of the input selected by
+// --layout and --ambix.
+func encodeInputChannels() (int, error) {
+	switch strings.ToLower(strings.TrimSpace(encodeLayout)) {
+	case "quad":
+		return 4, nil
+	case surroundInputLayout:
+		if encodeAmbiX {
+			return 0, fmt.Errorf("--ambix cannot be combined with --layout %s", surroundInputLayout)
+		}
+		return 6, nil
+	default:
+		return 0, fmt.Errorf("unknown input layout %q (use quad, %s)", encodeLayout, surroundInputLayout)
+	}
+}
+
+// encodeInputMask returns the WAV channel mask expected for an input of
+// channels channels, or 0 if the input has no speaker layout (AmbiX).
+func encodeInputMask(channels int) wav.ChannelMask {
+	switch {
+	case encodeAmbiX:
+		return 0
+	case channels == 6:
+		return wav.Mask5_1
+	default:
+		return wav.MaskQuad
+	}
+}
+
+// stereoEncoder is the interface shared by the matrix encoders.
+type stereoEncoder interface {
+	ProcessChunk(input [][]float64) ([][]float64, error)
+	Flush() [][]float64
+	GetLatency() int
+}
+
+// newInputEncoder creates the encoder for the input selected by --layout
+// and --ambix.
+func newInputEncoder(sampleRate uint32, channels int) (stereoEncoder, error) {
+	if channels == 6 {
+		sqEncoder, err := newEncoder(sampleRate)
+		if err != nil {
+			return nil, err
+		}
+		config := encoder.SurroundConfig{
+			FrontAzimuth:    encodeFrontAzimuth,
+			SurroundAzimuth: encodeSurroundAzimuth,
+			LFEGain:         encodeLFEGain,
+			LFECutoff:       encodeLFECutoff,
+		}
+		return encoder.NewSurroundEncoder(sqEncoder, int(sampleRate), config)
+	}
+	if !encodeAmbiX {
+		return newEncoder(sampleRate)
+	}
+	if iir {
+		return nil, fmt.Errorf("--ambix cannot be combined with --iir")
+	}
+
+	m, err := matrix.Lookup(matrixName)
+	if err != nil {
+		return nil, err
+	}
+	ambiXEncoder, err := encoder.NewAmbiXEncoderWithParams(blockSize, overlap)
+	if err != nil {
+		return nil, err
+	}
+	ambiXEncoder.SetSampleRate(int(sampleRate))
+	ambiXEncoder.SetMatrix(m)
+	return ambiXEncoder, nil
+}
+
 func runEncode(cmd *cobra.Command, args []string) error {
 	inputFile := args[0]
 	outputFile := args[1]
@@ -28,58 +133,121 @@ func runEncode(cmd *cobra.Command, args []string) error {
 		fmt.Printf("Reading input file: %s\n", inputFile)
 	}
 
-	audioData, err := wav.ReadWAVChannels(inputFile, 4)
+	in, err := os.Open(inputFile)
+	if err != nil {
+		return fmt.Errorf("failed to open input WAV: %w", err)
+	}
+	defer in.Close()
+
+	channels, err := encodeInputChannels()
+	if err != nil {
+		return err
+	}
+	format, err := outputFormat()
+	if err != nil {
+		return err
+	}
+	dither, err := outputDither()
+	if err != nil {
+		return err
+	}
+	clip, err := outputClip()
+	if err != nil {
+		return err
+	}
+	reader, err := wav.NewReader(in, channels)
 	if err != nil {
 		return fmt.Errorf("failed to read input WAV: %w", err)
 	}
 
+	if want := encodeInputMask(channels); reader.ChannelMask() != 0 && want != 0 && reader.ChannelMask() != want {
+		fmt.Fprintf(os.Stderr, "Warning: input channel mask %#x is not the %s layout %#x; channels are read in file order\n",
+			uint32(reader.ChannelMask()), encodeLayout, uint32(want))
+	}
+
 	if verbose {
-		fmt.Printf("  Sample rate: %d Hz\n", audioData.SampleRate)
-		fmt.Printf("  Samples: %d\n", audioData.NumSamples)
-		fmt.Printf("  Duration: %.2f seconds\n\n", float64(audioData.NumSamples)/float64(audioData.SampleRate))
+		fmt.Printf("  Sample rate: %d Hz\n", reader.SampleRate())
+		fmt.Printf("  Samples: %d\n", reader.NumSamples())
+		fmt.Printf("  Duration: %.2f seconds\n\n", float64(reader.NumSamples())/float64(reader.SampleRate()))
 	}
 
-	sqEncoder := encoder.NewSQEncoderWithParams(blockSize, overlap)
+	sqEncoder, err := newInputEncoder(reader.SampleRate(), channels)
+	if err != nil {
+		return err
+	}
 
 	if verbose {
 		fmt.Printf("Encoder configuration:\n")
-		fmt.Printf("  Block size: %d samples\n", blockSize)
-		fmt.Printf("  Overlap: %d samples\n", overlap)
+		printPhaseShifter()
+		if encodeAmbiX {
+			fmt.Printf("  Input: first-order AmbiX (W, Y, Z, X)\n")
+		}
+		if channels == 6 {
+			fmt.Printf("  Input: 5.1 (L/R at ±%.0f°, Ls/Rs at ±%.0f°, LFE %+.1f dB into centre)\n",
+				encodeFrontAzimuth, encodeSurroundAzimuth, encodeLFEGain)
+		}
 		fmt.Printf("  Latency: %d samples (%.2f ms)\n\n",
 			sqEncoder.GetLatency(),
-			float64(sqEncoder.GetLatency())/float64(audioData.SampleRate)*1000.0)
+			float64(sqEncoder.GetLatency())/float64(reader.SampleRate())*1000.0)
+		fmt.Printf("Writing output file: %s\n", outputFile)
+		fmt.Printf("  Format: %s\n", format.Description())
+		if !format.IsFloat() && dither.Mode != wav.DitherNone {
+			fmt.Printf("  Dither: %s (seed %d)\n", dither.Mode, dither.Seed)
+		}
 		fmt.Printf("Processing...\n")
 	}
 
-	output, err := sqEncoder.Process(audioData.Samples)
+	out, err := os.Create(outputFile)
 	if err != nil {
-		return fmt.Errorf("encoding failed: %w", err)
+		return fmt.Errorf("failed to create output WAV: %w", err)
 	}
+	defer out.Close()
 
-	outputData := &wav.AudioData{
-		SampleRate: audioData.SampleRate,
-		Samples:    output,
-		NumSamples: audioData.NumSamples,
+	writer, err := wav.NewWriter(out, reader.SampleRate(), 2, format)
+	if err != nil {
+		return fmt.Errorf("failed to write output WAV: %w", err)
 	}
+	writer.SetDither(dither)
+	writer.SetClip(clip)
 
-	if verbose {
-		fmt.Printf("Writing output file: %s\n", outputFile)
-		if float32 {
-			fmt.Printf("  Format: 32-bit IEEE float\n")
-		} else {
-			fmt.Printf("  Format: 16-bit PCM\n")
-		}
+	chunk := make([][]float64, channels)
+	for ch := range chunk {
+		chunk[ch] = make([]float64, streamChunkSize)
 	}
+	frames := make([][]float64, channels)
+	for {
+		n, readErr := reader.ReadFrames(chunk)
+		if readErr != nil && !errors.Is(readErr, io.EOF) {
+			return fmt.Errorf("failed to read input WAV: %w", readErr)
+		}
 
-	if float32 {
-		if err := wav.WriteStereoFloat32WAV(outputFile, outputData); err != nil {
-			return fmt.Errorf("failed to write output WAV: %w", err)
+		for ch := range frames {
+			frames[ch] = chunk[ch][:n]
 		}
-	} else {
-		if err := wav.WriteStereoWAV(outputFile, outputData); err != nil {
+		encoded, err := sqEncoder.ProcessChunk(frames)
+		if err != nil {
+			return fmt.Errorf("encoding failed: %w", err)
+		}
+		if err := writer.WriteFrames(encoded); err != nil {
 			return fmt.Errorf("failed to write output WAV: %w", err)
 		}
+
+		if errors.Is(readErr, io.EOF) {
+			break
+		}
+	}
+
+	if err := writer.WriteFrames(sqEncoder.Flush()); err != nil {
+		return fmt.Errorf("failed to write output WAV: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to write output WAV: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to close output WAV: %w", err)
 	}
+	warnInputOvers(inputFile, reader)
+	warnOutputOvers(outputFile, writer, format, clip)
 
 	if verbose {
 		fmt.Printf("\nDone! Encoded to 2-channel SQ stereo audio.\n")
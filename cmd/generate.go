@@ -72,8 +72,13 @@ func runGenerate(cmd *cobra.Command, args []string) error {
 		NumSamples: numSamples,
 	}
 
-	if float32 {
-		return wav.WriteFloat32WAV(outputFile, audioData)
+	format, err := outputFormat()
+	if err != nil {
+		return err
 	}
-	return wav.WriteWAV(outputFile, audioData)
+	dither, err := outputDither()
+	if err != nil {
+		return err
+	}
+	return wav.WriteWAVWithFormat(outputFile, audioData, format, dither)
 }
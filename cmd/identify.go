@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/cwbudde/go-sq-tool/internal/decoder"
+	"github.com/cwbudde/go-sq-tool/internal/matrix"
+	"github.com/cwbudde/go-sq-tool/internal/wav"
+	"github.com/spf13/cobra"
+)
+
+var identifyCmd = &cobra.Command{
+	Use:   "identify [input.wav]",
+	Short: "Estimate the matrix format of a stereo WAV",
+	Long: `Analyze a stereo WAV and report a confidence score for plain stereo
+and every registered matrix system, based on the inter-channel phase
+statistics and the distribution of energy across decoded directions.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runIdentify,
+}
+
+func runIdentify(cmd *cobra.Command, args []string) error {
+	inputFile := args[0]
+
+	in, err := os.Open(inputFile)
+	if err != nil {
+		return fmt.Errorf("failed to open input WAV: %w", err)
+	}
+	defer in.Close()
+
+	reader, err := wav.NewReader(in, 2)
+	if err != nil {
+		return fmt.Errorf("failed to read input WAV: %w", err)
+	}
+
+	identifier, err := decoder.NewFormatIdentifier(int(reader.SampleRate()), matrix.All())
+	if err != nil {
+		return fmt.Errorf("identification failed: %w", err)
+	}
+
+	// Analyse chunk by chunk
+	chunk := make([][]float64, 2)
+	for ch := range chunk {
+		chunk[ch] = make([]float64, streamChunkSize)
+	}
+	for {
+		n, readErr := reader.ReadFrames(chunk)
+		if readErr != nil && !errors.Is(readErr, io.EOF) {
+			return fmt.Errorf("failed to read input WAV: %w", readErr)
+		}
+		if err := identifier.ProcessChunk([][]float64{chunk[0][:n], chunk[1][:n]}); err != nil {
+			return fmt.Errorf("identification failed: %w", err)
+		}
+		if errors.Is(readErr, io.EOF) {
+			break
+		}
+	}
+
+	warnInputOvers(inputFile, reader)
+
+	result, err := identifier.Result()
+	if err != nil {
+		return fmt.Errorf("identification failed: %w", err)
+	}
+
+	fmt.Printf("Format identification\n")
+	fmt.Printf("Input: %s\n", inputFile)
+	fmt.Printf("Coherent signal: %.1f%% of energy\n", 100*result.Coherence)
+	fmt.Printf("Phase (Rt-Lt): in-phase %.1f%%  quadrature %.1f%%  anti-phase %.1f%%\n",
+		100*result.Phase.InPhase, 100*result.Phase.Quadrature, 100*result.Phase.AntiPhase)
+
+	fmt.Printf("\nFormat  Confidence    Fit     LF     RF     LB     RB\n")
+	for _, f := range result.Formats {
+		fmt.Printf("%-7s %9.1f%% %6.3f %5.1f%% %5.1f%% %5.1f%% %5.1f%%\n",
+			f.Name, 100*f.Confidence, f.Fit,
+			100*f.Directions[0], 100*f.Directions[1], 100*f.Directions[2], 100*f.Directions[3])
+	}
+
+	best := result.Formats[0]
+	fmt.Printf("\nMost likely: %s (%s)\n", best.Name, best.Description)
+	if result.Coherence == 0 {
+		fmt.Printf("No coherent signal found; the result is not meaningful.\n")
+	}
+
+	return nil
+}
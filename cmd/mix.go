@@ -0,0 +1,339 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/cwbudde/go-sq-tool/internal/encoder"
+	"github.com/cwbudde/go-sq-tool/internal/wav"
+	"github.com/spf13/cobra"
+)
+
+var mixCmd = &cobra.Command{
+	Use:   "mix [scene.json|scene.toml] [output.wav]",
+	Short: "Mix mono and stereo stems from a scene file directly to SQ stereo",
+	Long: `Mix the mono and stereo WAV stems listed in a JSON or TOML scene file and
+encode them directly to SQ stereo.
+
+Every stem has a file, a gain in dB, an azimuth in degrees (0 = front,
+positive to the left), a width in degrees (stereo stems only: left and right
+are placed at azimuth ± width/2, default 60) and a start offset in seconds.
+Relative file names are resolved against the scene file's directory.`,
+	Args: cobra.ExactArgs(2),
+	RunE: runMix,
+}
+
+// defaultStereoWidth is the width of a stereo stem without a width setting:
+// the ±30° of a standard stereo pair.
+const defaultStereoWidth = 60.0
+
+// mixScene is the scene file of the mix command.
+type mixScene struct {
+	Stems []mixStemConfig `json:"stems" toml:"stems"`
+}
+
+// mixStemConfig is one stem of a scene.
+type mixStemConfig struct {
+	File    string   `json:"file" toml:"file"`
+	Gain    float64  `json:"gain" toml:"gain"`
+	Azimuth float64  `json:"azimuth" toml:"azimuth"`
+	Width   *float64 `json:"width" toml:"width"`
+	Start   float64  `json:"start" toml:"start"`
+}
+
+// mixStem is an opened stem of the mix command.
+type mixStem struct {
+	config mixStemConfig
+	file   *os.File
+	reader *wav.Reader
+	gain   float64
+	// delay is the number of silent samples left before the stem starts.
+	delay int
+	done  bool
+}
+
+func runMix(cmd *cobra.Command, args []string) error {
+	sceneFile := args[0]
+	outputFile := args[1]
+
+	scene, err := loadMixScene(sceneFile)
+	if err != nil {
+		return err
+	}
+
+	stems := make([]*mixStem, 0, len(scene.Stems))
+	defer func() {
+		for _, stem := range stems {
+			stem.file.Close()
+		}
+	}()
+	for _, config := range scene.Stems {
+		stem, err := openMixStem(config, filepath.Dir(sceneFile))
+		if err != nil {
+			return err
+		}
+		stems = append(stems, stem)
+	}
+
+	sampleRate := stems[0].reader.SampleRate()
+	var azimuths []encoder.AzimuthAutomation
+	for _, stem := range stems {
+		if stem.reader.SampleRate() != sampleRate {
+			return fmt.Errorf("stem %s has sample rate %d Hz, %s has %d Hz",
+				stem.config.File, stem.reader.SampleRate(), stems[0].config.File, sampleRate)
+		}
+		stem.delay = int(math.Round(stem.config.Start * float64(sampleRate)))
+		for _, azimuth := range stem.azimuths() {
+			azimuths = append(azimuths, encoder.ConstantAzimuth(azimuth))
+		}
+	}
+
+	sqEncoder, err := newEncoder(sampleRate)
+	if err != nil {
+		return err
+	}
+	positionEncoder, err := encoder.NewPositionEncoder(sqEncoder, int(sampleRate), azimuths)
+	if err != nil {
+		return err
+	}
+
+	if verbose {
+		fmt.Printf("SQ Scene Mixer\n")
+		fmt.Printf("==============\n\n")
+		fmt.Printf("Scene: %s (%d stems, %d Hz)\n", sceneFile, len(stems), sampleRate)
+		for _, stem := range stems {
+			fmt.Printf("  %s: %s, gain %.1f dB, start %.2f s\n",
+				stem.config.File, stem.describePosition(), stem.config.Gain, stem.config.Start)
+		}
+		fmt.Printf("\nEncoder configuration:\n")
+		printPhaseShifter()
+		fmt.Printf("  Latency: %d samples (%.2f ms)\n\n",
+			positionEncoder.GetLatency(),
+			float64(positionEncoder.GetLatency())/float64(sampleRate)*1000.0)
+		fmt.Printf("Writing output file: %s\n", outputFile)
+		fmt.Printf("Processing...\n")
+	}
+
+	format, err := outputFormat()
+	if err != nil {
+		return err
+	}
+	dither, err := outputDither()
+	if err != nil {
+		return err
+	}
+	clip, err := outputClip()
+	if err != nil {
+		return err
+	}
+	out, err := os.Create(outputFile)
+	if err != nil {
+		return fmt.Errorf("failed to create output WAV: %w", err)
+	}
+	defer out.Close()
+
+	writer, err := wav.NewWriter(out, sampleRate, 2, format)
+	if err != nil {
+		return fmt.Errorf("failed to write output WAV: %w", err)
+	}
+	writer.SetDither(dither)
+	writer.SetClip(clip)
+
+	// One encoder source per stem channel; the mix ends with the last stem.
+	sources := make([][]float64, len(azimuths))
+	for i := range sources {
+		sources[i] = make([]float64, streamChunkSize)
+	}
+	for {
+		n, src := 0, 0
+		for _, stem := range stems {
+			channels := stem.reader.NumChannels()
+			read, err := stem.read(sources[src : src+channels])
+			if err != nil {
+				return err
+			}
+			n = max(n, read)
+			src += channels
+		}
+		if n == 0 {
+			break
+		}
+
+		chunk := make([][]float64, len(sources))
+		for i := range chunk {
+			chunk[i] = sources[i][:n]
+		}
+		encoded, err := positionEncoder.ProcessChunk(chunk)
+		if err != nil {
+			return fmt.Errorf("encoding failed: %w", err)
+		}
+		if err := writer.WriteFrames(encoded); err != nil {
+			return fmt.Errorf("failed to write output WAV: %w", err)
+		}
+	}
+
+	if err := writer.WriteFrames(positionEncoder.Flush()); err != nil {
+		return fmt.Errorf("failed to write output WAV: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to write output WAV: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to close output WAV: %w", err)
+	}
+	for _, stem := range stems {
+		warnInputOvers(stem.config.File, stem.reader)
+	}
+	warnOutputOvers(outputFile, writer, format, clip)
+
+	if verbose {
+		fmt.Printf("\nDone! Mixed %d stems to 2-channel SQ stereo audio.\n", len(stems))
+	} else {
+		fmt.Printf("Successfully mixed %s -> %s\n", sceneFile, outputFile)
+	}
+	return nil
+}
+
+// loadMixScene reads and validates a JSON or TOML scene file.
+func loadMixScene(path string) (*mixScene, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scene: %w", err)
+	}
+
+	var scene mixScene
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&scene); err != nil {
+			return nil, fmt.Errorf("failed to parse scene %s: %w", path, err)
+		}
+	case ".toml":
+		md, err := toml.Decode(string(data), &scene)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse scene %s: %w", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return nil, fmt.Errorf("failed to parse scene %s: unknown key %q", path, undecoded[0].String())
+		}
+	default:
+		return nil, fmt.Errorf("scene %s must be a .json or .toml file", path)
+	}
+
+	if len(scene.Stems) == 0 {
+		return nil, fmt.Errorf("scene %s has no stems", path)
+	}
+	for i, stem := range scene.Stems {
+		if stem.File == "" {
+			return nil, fmt.Errorf("scene %s: stem %d has no file", path, i+1)
+		}
+		if stem.Start < 0 {
+			return nil, fmt.Errorf("scene %s: stem %s has a negative start", path, stem.File)
+		}
+	}
+	return &scene, nil
+}
+
+// openMixStem opens the WAV of a stem; relative paths are resolved
+// against dir.
+func openMixStem(config mixStemConfig, dir string) (*mixStem, error) {
+	path := config.File
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open stem WAV: %w", err)
+	}
+	reader, err := wav.NewReader(file, 0)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read stem %s: %w", config.File, err)
+	}
+
+	switch reader.NumChannels() {
+	case 1:
+		if config.Width != nil && *config.Width != 0 {
+			file.Close()
+			return nil, fmt.Errorf("stem %s: width needs a stereo stem", config.File)
+		}
+	case 2:
+	default:
+		file.Close()
+		return nil, fmt.Errorf("stem %s must be mono or stereo, got %d channels", config.File, reader.NumChannels())
+	}
+
+	return &mixStem{
+		config: config,
+		file:   file,
+		reader: reader,
+		gain:   math.Pow(10, config.Gain/20),
+	}, nil
+}
+
+// azimuths returns the azimuth of every channel of the stem.
+func (s *mixStem) azimuths() []float64 {
+	if s.reader.NumChannels() == 1 {
+		return []float64{s.config.Azimuth}
+	}
+	width := defaultStereoWidth
+	if s.config.Width != nil {
+		width = *s.config.Width
+	}
+	return []float64{s.config.Azimuth + width/2, s.config.Azimuth - width/2}
+}
+
+// describePosition formats the stem position for verbose output.
+func (s *mixStem) describePosition() string {
+	azimuths := s.azimuths()
+	if len(azimuths) == 1 {
+		return fmt.Sprintf("mono at %.1f°", azimuths[0])
+	}
+	return fmt.Sprintf("stereo at %.1f°/%.1f°", azimuths[0], azimuths[1])
+}
+
+// read fills dst (one slice per stem channel) with the next samples of the
+// stem: silence until its start, then the gained samples, then silence
+// after its end. It returns the number of samples before the stem's end.
+func (s *mixStem) read(dst [][]float64) (int, error) {
+	for _, ch := range dst {
+		for i := range ch {
+			ch[i] = 0
+		}
+	}
+	if s.done {
+		return 0, nil
+	}
+
+	skip := min(s.delay, len(dst[0]))
+	s.delay -= skip
+	if skip == len(dst[0]) {
+		return skip, nil
+	}
+
+	window := make([][]float64, len(dst))
+	for ch := range dst {
+		window[ch] = dst[ch][skip:]
+	}
+	n, err := s.reader.ReadFrames(window)
+	if errors.Is(err, io.EOF) {
+		s.done = true
+	} else if err != nil {
+		return skip + n, fmt.Errorf("failed to read stem %s: %w", s.config.File, err)
+	}
+	for _, ch := range window {
+		for i := 0; i < n; i++ {
+			ch[i] *= s.gain
+		}
+	}
+	return skip + n, nil
+}
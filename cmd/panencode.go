@@ -0,0 +1,263 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/cwbudde/go-sq-tool/internal/encoder"
+	"github.com/cwbudde/go-sq-tool/internal/wav"
+	"github.com/spf13/cobra"
+)
+
+var panEncodeCmd = &cobra.Command{
+	Use:   "pan-encode [output.wav] [stem.wav=azimuth|automation.csv]...",
+	Short: "Pan mono stems to arbitrary azimuths and encode them to SQ stereo",
+	Long: `Pan mono stems to arbitrary azimuths and encode them directly to SQ stereo.
+
+Every stem is given as file.wav=AZIMUTH (degrees, 0 = front, positive to the
+left, e.g. vocals.wav=0 or fx.wav=-110) or as file.wav=automation.csv with
+time,azimuth rows (seconds, degrees) that are interpolated linearly.`,
+	Args: cobra.MinimumNArgs(2),
+	RunE: runPanEncode,
+}
+
+// panStem is an opened stem of the pan-encode command.
+type panStem struct {
+	name       string
+	file       *os.File
+	reader     *wav.Reader
+	automation encoder.AzimuthAutomation
+	done       bool
+}
+
+func runPanEncode(cmd *cobra.Command, args []string) error {
+	outputFile := args[0]
+
+	stems := make([]*panStem, 0, len(args)-1)
+	defer func() {
+		for _, stem := range stems {
+			stem.file.Close()
+		}
+	}()
+	for _, spec := range args[1:] {
+		stem, err := openPanStem(spec)
+		if err != nil {
+			return err
+		}
+		stems = append(stems, stem)
+	}
+
+	sampleRate := stems[0].reader.SampleRate()
+	azimuths := make([]encoder.AzimuthAutomation, len(stems))
+	for i, stem := range stems {
+		if stem.reader.SampleRate() != sampleRate {
+			return fmt.Errorf("stem %s has sample rate %d Hz, %s has %d Hz",
+				stem.name, stem.reader.SampleRate(), stems[0].name, sampleRate)
+		}
+		azimuths[i] = stem.automation
+	}
+
+	sqEncoder, err := newEncoder(sampleRate)
+	if err != nil {
+		return err
+	}
+	positionEncoder, err := encoder.NewPositionEncoder(sqEncoder, int(sampleRate), azimuths)
+	if err != nil {
+		return err
+	}
+
+	if verbose {
+		fmt.Printf("SQ Position Encoder\n")
+		fmt.Printf("===================\n\n")
+		fmt.Printf("Stems (%d Hz):\n", sampleRate)
+		for _, stem := range stems {
+			fmt.Printf("  %s: %s\n", stem.name, describeAutomation(stem.automation))
+		}
+		fmt.Printf("\nEncoder configuration:\n")
+		printPhaseShifter()
+		fmt.Printf("  Latency: %d samples (%.2f ms)\n\n",
+			positionEncoder.GetLatency(),
+			float64(positionEncoder.GetLatency())/float64(sampleRate)*1000.0)
+		fmt.Printf("Writing output file: %s\n", outputFile)
+		fmt.Printf("Processing...\n")
+	}
+
+	format, err := outputFormat()
+	if err != nil {
+		return err
+	}
+	dither, err := outputDither()
+	if err != nil {
+		return err
+	}
+	clip, err := outputClip()
+	if err != nil {
+		return err
+	}
+	out, err := os.Create(outputFile)
+	if err != nil {
+		return fmt.Errorf("failed to create output WAV: %w", err)
+	}
+	defer out.Close()
+
+	writer, err := wav.NewWriter(out, sampleRate, 2, format)
+	if err != nil {
+		return fmt.Errorf("failed to write output WAV: %w", err)
+	}
+	writer.SetDither(dither)
+	writer.SetClip(clip)
+
+	// Shorter stems are padded with silence up to the longest one.
+	chunk := make([][]float64, len(stems))
+	for i := range chunk {
+		chunk[i] = make([]float64, streamChunkSize)
+	}
+	for {
+		n := 0
+		for i, stem := range stems {
+			read, err := stem.read(chunk[i])
+			if err != nil {
+				return err
+			}
+			n = max(n, read)
+		}
+		if n == 0 {
+			break
+		}
+
+		sources := make([][]float64, len(stems))
+		for i := range sources {
+			sources[i] = chunk[i][:n]
+		}
+		encoded, err := positionEncoder.ProcessChunk(sources)
+		if err != nil {
+			return fmt.Errorf("encoding failed: %w", err)
+		}
+		if err := writer.WriteFrames(encoded); err != nil {
+			return fmt.Errorf("failed to write output WAV: %w", err)
+		}
+	}
+
+	if err := writer.WriteFrames(positionEncoder.Flush()); err != nil {
+		return fmt.Errorf("failed to write output WAV: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to write output WAV: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to close output WAV: %w", err)
+	}
+	for _, stem := range stems {
+		warnInputOvers(stem.name, stem.reader)
+	}
+	warnOutputOvers(outputFile, writer, format, clip)
+
+	if verbose {
+		fmt.Printf("\nDone! Encoded %d stems to 2-channel SQ stereo audio.\n", len(stems))
+	} else {
+		fmt.Printf("Successfully encoded %d stems -> %s\n", len(stems), outputFile)
+	}
+	return nil
+}
+
+// openPanStem opens a stem given as file.wav=azimuth or file.wav=automation.csv.
+func openPanStem(spec string) (*panStem, error) {
+	sep := strings.LastIndex(spec, "=")
+	if sep <= 0 || sep == len(spec)-1 {
+		return nil, fmt.Errorf("invalid stem %q (use file.wav=azimuth or file.wav=automation.csv)", spec)
+	}
+	name, position := spec[:sep], spec[sep+1:]
+
+	var automation encoder.AzimuthAutomation
+	if azimuth, err := strconv.ParseFloat(strings.TrimSpace(position), 64); err == nil {
+		automation = encoder.ConstantAzimuth(azimuth)
+	} else {
+		automation, err = readAzimuthCSV(position)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open stem WAV: %w", err)
+	}
+	reader, err := wav.NewReader(file, 1)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read stem %s: %w", name, err)
+	}
+	return &panStem{name: name, file: file, reader: reader, automation: automation}, nil
+}
+
+// read fills dst with the next samples of the stem, padding with silence
+// after its end, and returns the number of samples read.
+func (s *panStem) read(dst []float64) (int, error) {
+	for i := range dst {
+		dst[i] = 0
+	}
+	if s.done {
+		return 0, nil
+	}
+	n, err := s.reader.ReadFrames([][]float64{dst})
+	if errors.Is(err, io.EOF) {
+		s.done = true
+	} else if err != nil {
+		return n, fmt.Errorf("failed to read stem %s: %w", s.name, err)
+	}
+	return n, nil
+}
+
+// readAzimuthCSV reads an azimuth automation of time,azimuth rows (seconds,
+// degrees). A header row and lines starting with # are skipped.
+func readAzimuthCSV(path string) (encoder.AzimuthAutomation, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open automation CSV: %w", err)
+	}
+	defer file.Close()
+
+	r := csv.NewReader(file)
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read automation CSV %s: %w", path, err)
+	}
+
+	points := make([]encoder.AzimuthPoint, 0, len(records))
+	for i, record := range records {
+		if len(record) < 2 {
+			return nil, fmt.Errorf("%s line %d: want time,azimuth", path, i+1)
+		}
+		t, errTime := strconv.ParseFloat(strings.TrimSpace(record[0]), 64)
+		azimuth, errAzimuth := strconv.ParseFloat(strings.TrimSpace(record[1]), 64)
+		if errTime != nil || errAzimuth != nil {
+			if i == 0 {
+				continue // header
+			}
+			return nil, fmt.Errorf("%s line %d: invalid time,azimuth %q", path, i+1, strings.Join(record, ","))
+		}
+		points = append(points, encoder.AzimuthPoint{Time: t, Azimuth: azimuth})
+	}
+
+	automation, err := encoder.NewAzimuthAutomation(points)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return automation, nil
+}
+
+// describeAutomation formats an automation for verbose output.
+func describeAutomation(a encoder.AzimuthAutomation) string {
+	if len(a) == 1 {
+		return fmt.Sprintf("%.1f°", a[0].Azimuth)
+	}
+	return fmt.Sprintf("%d points, %.1f° to %.1f° over %.2f s", len(a), a[0].Azimuth, a[len(a)-1].Azimuth, a[len(a)-1].Time-a[0].Time)
+}
@@ -2,18 +2,44 @@ package cmd
 
 import (
 	"fmt"
+	"math"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/cwbudde/go-sq-tool/internal/decoder"
+	"github.com/cwbudde/go-sq-tool/internal/encoder"
+	"github.com/cwbudde/go-sq-tool/internal/matrix"
+	"github.com/cwbudde/go-sq-tool/internal/wav"
+	"github.com/cwbudde/go-sq-tool/pkg/sqmath"
 	"github.com/spf13/cobra"
 )
 
+// streamChunkSize is the number of frames read per chunk by streaming commands.
+const streamChunkSize = 65536
+
 var (
 	verbose   bool
 	blockSize int
 	overlap   int
-	float32   bool
 	logic     bool
+
+	formatName    string
+	outputFloat32 bool
+	ditherName    string
+	ditherSeed    uint64
+	clipName      string
+
+	logicCrossovers string
+	waveMatching    bool
+
+	iir        bool
+	iirLowFreq float64
+	iirRipple  float64
+
+	spectral bool
+
+	matrixName string
 )
 
 var rootCmd = &cobra.Command{
@@ -46,12 +72,41 @@ func init() {
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "verbose output")
 	rootCmd.PersistentFlags().IntVarP(&blockSize, "block-size", "b", decoder.DefaultBlockSize, "FFT block size (power of 2)")
 	rootCmd.PersistentFlags().IntVarP(&overlap, "overlap", "o", decoder.DefaultOverlap, "overlap in samples")
-	rootCmd.PersistentFlags().BoolVar(&float32, "float32", false, "output 32-bit IEEE float WAV instead of 16-bit PCM")
+	rootCmd.PersistentFlags().StringVar(&formatName, "format", wav.FormatPCM16.String(),
+		"output sample format: "+strings.Join(wav.FormatNames(), ", "))
+	rootCmd.PersistentFlags().BoolVar(&outputFloat32, "float32", false, "output 32-bit IEEE float WAV instead of 16-bit PCM")
+	if err := rootCmd.PersistentFlags().MarkDeprecated("float32", "use --format float32"); err != nil {
+		panic(err)
+	}
+	rootCmd.PersistentFlags().StringVar(&ditherName, "dither", wav.DitherNone.String(),
+		"dither of integer output formats: "+strings.Join(wav.DitherNames(), ", "))
+	rootCmd.PersistentFlags().Uint64Var(&ditherSeed, "dither-seed", 1,
+		"seed of the dither noise; equal seeds give identical output")
+	rootCmd.PersistentFlags().StringVar(&clipName, "clip", wav.ClipNone.String(),
+		"clip stage for output samples beyond full scale: "+strings.Join(wav.ClipNames(), ", "))
+	rootCmd.PersistentFlags().StringVar(&matrixName, "matrix", matrix.SQ.Name,
+		"matrix system: "+strings.Join(matrix.Names(), ", "))
 	rootCmd.PersistentFlags().BoolVar(&logic, "logic", false, "enable CBS-style logic steering for decoding")
+	rootCmd.PersistentFlags().StringVar(&logicCrossovers, "logic-crossovers", "250,2500",
+		"comma-separated band-split crossover frequencies for logic steering in Hz (empty for broadband)")
+	rootCmd.PersistentFlags().BoolVar(&waveMatching, "wave-matching", false,
+		"enable the wave-matching variable-matrix decoder (cancels crosstalk for a dominant source)")
+	rootCmd.PersistentFlags().BoolVar(&spectral, "spectral", false,
+		"enable the per-bin STFT steering decoder (steers every frequency bin to its own direction)")
+	rootCmd.PersistentFlags().BoolVar(&iir, "iir", false,
+		"use a zero-latency IIR all-pass phase network instead of the FFT Hilbert transform")
+	rootCmd.PersistentFlags().Float64Var(&iirLowFreq, "iir-low-freq", 0,
+		"design the IIR phase network for 90° from this frequency up to Nyquist minus it in Hz (0 uses the published Niemitalo set)")
+	rootCmd.PersistentFlags().Float64Var(&iirRipple, "iir-ripple", 0.5,
+		"maximum phase error of the designed IIR phase network in degrees")
 	rootCmd.AddCommand(decodeCmd)
 	rootCmd.AddCommand(encodeCmd)
 	rootCmd.AddCommand(analyzeCmd)
 	rootCmd.AddCommand(generateCmd)
+	rootCmd.AddCommand(identifyCmd)
+	rootCmd.AddCommand(panEncodeCmd)
+	rootCmd.AddCommand(mixCmd)
+	rootCmd.AddCommand(transcodeCmd)
 }
 
 func runRoot(cmd *cobra.Command, args []string) error {
@@ -63,3 +118,221 @@ func runRoot(cmd *cobra.Command, args []string) error {
 	}
 	return runDecode(cmd, args)
 }
+
+// outputFormat returns the WAV sample format selected by the global flags.
+func outputFormat() (wav.SampleFormat, error) {
+	if outputFloat32 {
+		return wav.FormatFloat32, nil
+	}
+	return wav.LookupFormat(formatName)
+}
+
+// outputDither returns the dither of integer output formats selected by the
+// global flags.
+func outputDither() (wav.DitherConfig, error) {
+	mode, err := wav.LookupDither(ditherName)
+	if err != nil {
+		return wav.DitherConfig{}, err
+	}
+	return wav.DitherConfig{Mode: mode, Seed: ditherSeed}, nil
+}
+
+// outputClip returns the output clip stage selected by the global flags.
+func outputClip() (wav.ClipMode, error) {
+	return wav.LookupClip(clipName)
+}
+
+// overCounter reports the samples beyond full scale seen by a *wav.Reader or
+// in *wav.AudioData.
+type overCounter interface {
+	Overs() int
+	Peak() float64
+}
+
+// warnInputOvers warns about samples beyond full scale read from the input
+// name. They are processed unclipped.
+func warnInputOvers(name string, input overCounter) {
+	if input.Overs() == 0 {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Warning: %s: %d samples exceed 0 dBFS (peak %+.1f dBFS); processing them unclipped\n",
+		name, input.Overs(), 20.0*math.Log10(input.Peak()))
+}
+
+// warnOutputOvers warns about samples beyond full scale written to the output
+// name and what happened to them.
+func warnOutputOvers(name string, writer *wav.Writer, format wav.SampleFormat, clip wav.ClipMode) {
+	if writer.Overs() == 0 {
+		return
+	}
+	var fate string
+	switch {
+	case clip != wav.ClipNone:
+		fate = clip.String() + "-clipped"
+	case format.IsFloat():
+		fate = "kept in the float output; they clip on fixed-point playback (see --clip)"
+	default:
+		fate = "clipped by the integer format (see --clip soft or a float --format)"
+	}
+	fmt.Fprintf(os.Stderr, "Warning: %s: %d samples exceed 0 dBFS (peak %+.1f dBFS), %s\n",
+		name, writer.Overs(), 20.0*math.Log10(writer.Peak()), fate)
+}
+
+// phaseNetworkCoefficients returns the IIR phase network selected by the global flags.
+func phaseNetworkCoefficients(sampleRate uint32) (sqmath.PhaseNetworkCoefficients, error) {
+	if iirLowFreq <= 0 {
+		return sqmath.NiemitaloCoefficients, nil
+	}
+
+	coefs, err := sqmath.DesignPhaseNetwork(iirLowFreq, float64(sampleRate), iirRipple)
+	if err != nil {
+		return sqmath.PhaseNetworkCoefficients{}, fmt.Errorf("invalid IIR phase network: %w", err)
+	}
+	return coefs, nil
+}
+
+// quadDecoder is the interface shared by the SQ decoder implementations.
+type quadDecoder interface {
+	Process(input [][]float64) ([][]float64, error)
+	ProcessChunk(input [][]float64) ([][]float64, error)
+	Flush() [][]float64
+	GetLatency() int
+}
+
+// newDecoder creates the decoder selected by the global flags.
+func newDecoder(sampleRate uint32, logicConfig decoder.LogicSteeringConfig) (quadDecoder, error) {
+	m, err := matrix.Lookup(matrixName)
+	if err != nil {
+		return nil, err
+	}
+
+	if spectral {
+		spectralDecoder, err := decoder.NewSpectralDecoderWithParams(blockSize, overlap)
+		if err != nil {
+			return nil, err
+		}
+		spectralDecoder.SetSampleRate(int(sampleRate))
+		spectralDecoder.SetMatrix(m)
+		return spectralDecoder, nil
+	}
+
+	var sqDecoder *decoder.SQDecoder
+	if iir {
+		coefs, err := phaseNetworkCoefficients(sampleRate)
+		if err != nil {
+			return nil, err
+		}
+		sqDecoder = decoder.NewSQDecoderIIR(coefs)
+	} else {
+		if err := checkBlockParams(); err != nil {
+			return nil, err
+		}
+		sqDecoder = decoder.NewSQDecoderWithParams(blockSize, overlap)
+	}
+	sqDecoder.SetSampleRate(int(sampleRate))
+	sqDecoder.SetMatrix(m)
+	sqDecoder.SetLogicSteeringConfig(logicConfig)
+	sqDecoder.EnableWaveMatching(waveMatching)
+	return sqDecoder, nil
+}
+
+// newEncoder creates an encoder with the phase shifter selected by the global flags.
+func newEncoder(sampleRate uint32) (*encoder.SQEncoder, error) {
+	m, err := matrix.Lookup(matrixName)
+	if err != nil {
+		return nil, err
+	}
+	return newMatrixEncoder(sampleRate, m)
+}
+
+// newMatrixEncoder creates an encoder for m with the phase shifter selected
+// by the global flags.
+func newMatrixEncoder(sampleRate uint32, m matrix.Matrix) (*encoder.SQEncoder, error) {
+	var sqEncoder *encoder.SQEncoder
+	if iir {
+		coefs, err := phaseNetworkCoefficients(sampleRate)
+		if err != nil {
+			return nil, err
+		}
+		sqEncoder = encoder.NewSQEncoderIIR(coefs)
+	} else {
+		if err := checkBlockParams(); err != nil {
+			return nil, err
+		}
+		sqEncoder = encoder.NewSQEncoderWithParams(blockSize, overlap)
+	}
+	sqEncoder.SetMatrix(m)
+	return sqEncoder, nil
+}
+
+// checkBlockParams validates --block-size and --overlap for the FFT Hilbert
+// transform: each block must hold the overlap-long filter plus the overlap
+// samples it produces.
+func checkBlockParams() error {
+	if blockSize <= 0 || blockSize&(blockSize-1) != 0 {
+		return fmt.Errorf("--block-size must be a power of 2, got %d", blockSize)
+	}
+	if overlap <= 0 || overlap > blockSize/2 {
+		return fmt.Errorf("--overlap must be in (0, %d] for --block-size %d, got %d", blockSize/2, blockSize, overlap)
+	}
+	return nil
+}
+
+// printPhaseShifter prints the phase shifter configuration in verbose output.
+func printPhaseShifter() {
+	if iir {
+		fmt.Printf("  Phase shifter: IIR all-pass network\n")
+		return
+	}
+	fmt.Printf("  Block size: %d samples\n", blockSize)
+	fmt.Printf("  Overlap: %d samples\n", overlap)
+}
+
+// logicSteeringConfig returns the logic steering config selected by the global flags.
+func logicSteeringConfig() (decoder.LogicSteeringConfig, error) {
+	if logic && waveMatching {
+		return decoder.LogicSteeringConfig{}, fmt.Errorf("use either --logic or --wave-matching, not both")
+	}
+	if logic && !strings.EqualFold(strings.TrimSpace(matrixName), matrix.SQ.Name) {
+		return decoder.LogicSteeringConfig{}, fmt.Errorf("--logic is only available for the SQ matrix")
+	}
+	if spectral && (logic || waveMatching || iir) {
+		return decoder.LogicSteeringConfig{}, fmt.Errorf("--spectral cannot be combined with --logic, --wave-matching or --iir")
+	}
+
+	crossovers, err := parseFrequencyList(logicCrossovers)
+	if err != nil {
+		return decoder.LogicSteeringConfig{}, fmt.Errorf("invalid logic-crossovers: %w", err)
+	}
+
+	config := decoder.DefaultLogicSteeringConfig()
+	config.Enabled = logic
+	config.Crossovers = crossovers
+	config.Bands = decoder.DefaultLogicBands(len(crossovers) + 1)
+	return config, nil
+}
+
+// parseFrequencyList parses a comma-separated list of ascending positive frequencies.
+func parseFrequencyList(s string) ([]float64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+
+	parts := strings.Split(s, ",")
+	freqs := make([]float64, 0, len(parts))
+	for _, part := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse %q: %w", part, err)
+		}
+		if f <= 0 {
+			return nil, fmt.Errorf("frequency must be > 0, got %g", f)
+		}
+		if len(freqs) > 0 && f <= freqs[len(freqs)-1] {
+			return nil, fmt.Errorf("frequencies must be ascending")
+		}
+		freqs = append(freqs, f)
+	}
+	return freqs, nil
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"io"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cwbudde/go-sq-tool/internal/wav"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// runCLI runs the command line args with all flags at their defaults. The
+// flags are package globals, so tests using it must not run in parallel.
+func runCLI(t *testing.T, args ...string) error {
+	t.Helper()
+
+	var reset func(cmd *cobra.Command)
+	reset = func(cmd *cobra.Command) {
+		for _, flags := range []*pflag.FlagSet{cmd.PersistentFlags(), cmd.LocalNonPersistentFlags()} {
+			flags.VisitAll(func(f *pflag.Flag) {
+				if err := f.Value.Set(f.DefValue); err != nil {
+					t.Fatalf("reset --%s: %v", f.Name, err)
+				}
+				f.Changed = false
+			})
+		}
+		for _, sub := range cmd.Commands() {
+			reset(sub)
+		}
+	}
+	reset(rootCmd)
+
+	rootCmd.SetArgs(args)
+	rootCmd.SetOut(io.Discard)
+	rootCmd.SetErr(io.Discard)
+	return rootCmd.Execute()
+}
+
+// writeTestWAV writes a short tone with channels channels and returns its
+// file name.
+func writeTestWAV(t *testing.T, channels int) string {
+	t.Helper()
+
+	const n = 4096
+	data := &wav.AudioData{SampleRate: 44100, Samples: make([][]float64, channels), NumSamples: n}
+	for ch := range data.Samples {
+		data.Samples[ch] = make([]float64, n)
+		for i := range data.Samples[ch] {
+			data.Samples[ch][i] = 0.25 * math.Sin(2.0*math.Pi*float64((ch+1)*i)/97.0)
+		}
+	}
+
+	filename := filepath.Join(t.TempDir(), "input.wav")
+	write := wav.WriteStereoWAV
+	if channels == 4 {
+		write = wav.WriteWAV
+	}
+	if err := write(filename, data); err != nil {
+		t.Fatalf("write test WAV: %v", err)
+	}
+	return filename
+}
+
+func TestCLI_BadBlockParamsReturnError(t *testing.T) {
+	stereo := writeTestWAV(t, 2)
+	quad := writeTestWAV(t, 4)
+	output := filepath.Join(t.TempDir(), "output.wav")
+
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"decode", "--spectral", "-o", "768", stereo, output}, "hop"},
+		{[]string{"decode", "--spectral", "-b", "-10", stereo, output}, "FFT size"},
+		{[]string{"decode", "--spectral", "-b", "0", stereo, output}, "FFT size"},
+		{[]string{"decode", "-o", "768", stereo, output}, "--overlap"},
+		{[]string{"decode", "-b", "1000", stereo, output}, "--block-size"},
+		{[]string{"decode", "--upmix", "-o", "768", stereo, output}, "hop"},
+		{[]string{"decode", "--upmix", "-b", "-10", stereo, output}, "FFT size"},
+		{[]string{"encode", "-o", "0", quad, output}, "--overlap"},
+		{[]string{"encode", "--ambix", "-o", "768", quad, output}, "hop"},
+		{[]string{"encode", "--ambix", "-b", "-10", quad, output}, "FFT size"},
+		{[]string{"transcode", "--to", "qs", "-o", "768", stereo, output}, "hop"},
+		{[]string{"transcode", "--to", "qs", "-b", "-10", stereo, output}, "FFT size"},
+		{[]string{"transcode", "--to", "qs", "-b", "0", stereo, output}, "FFT size"},
+	} {
+		err := runCLI(t, tc.args...)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: error = %v, want an error mentioning %q", strings.Join(tc.args[:len(tc.args)-2], " "), err, tc.want)
+		}
+	}
+
+	if err := runCLI(t, "decode", "--spectral", stereo, output); err != nil {
+		t.Errorf("decode --spectral with default block parameters: %v", err)
+	}
+	if err := runCLI(t, "decode", "--spectral", "-b", "4096", "-o", "300", stereo, output); err != nil {
+		t.Errorf("decode --spectral with a hop that does not divide the block size: %v", err)
+	}
+}
@@ -0,0 +1,238 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/cwbudde/go-sq-tool/internal/decoder"
+	"github.com/cwbudde/go-sq-tool/internal/encoder"
+	"github.com/cwbudde/go-sq-tool/internal/matrix"
+	"github.com/cwbudde/go-sq-tool/internal/wav"
+	"github.com/spf13/cobra"
+)
+
+var transcodeCmd = &cobra.Command{
+	Use:   "transcode [input.wav] [output.wav]",
+	Short: "Transcode matrix stereo from one system to another (e.g. SQ to QS)",
+	Long: `Transcode 2-channel matrix stereo from the --matrix system (SQ by default)
+to the --to system, e.g. an SQ LP transfer to QS stereo for a QS hardware
+decoder.
+
+By default every frequency bin is re-encoded from its estimated direction,
+so sources keep their position without a bounce through four speakers.
+--bounce decodes to LF, RF, LB, RB with the selected decoder (--logic,
+--wave-matching, --iir or --spectral) and re-encodes the four channels.`,
+	Args: cobra.ExactArgs(2),
+	RunE: runTranscode,
+}
+
+var (
+	transcodeTo     string
+	transcodeBounce bool
+)
+
+func init() {
+	transcodeCmd.Flags().StringVar(&transcodeTo, "to", "",
+		"output matrix system (required): "+strings.Join(matrix.Names(), ", "))
+	transcodeCmd.Flags().BoolVar(&transcodeBounce, "bounce", false,
+		"decode to four speakers and re-encode them instead of re-encoding the per-bin directions")
+	if err := transcodeCmd.MarkFlagRequired("to"); err != nil {
+		panic(err)
+	}
+}
+
+// stereoTranscoder is the interface shared by the transcoders.
+type stereoTranscoder interface {
+	ProcessChunk(input [][]float64) ([][]float64, error)
+	Flush() [][]float64
+	GetLatency() int
+}
+
+// newTranscoder creates the transcoder to target selected by the flags.
+func newTranscoder(sampleRate uint32, target matrix.Matrix) (stereoTranscoder, error) {
+	logicConfig, err := logicSteeringConfig()
+	if err != nil {
+		return nil, err
+	}
+	if transcodeBounce {
+		quad, err := newDecoder(sampleRate, logicConfig)
+		if err != nil {
+			return nil, err
+		}
+		sqEncoder, err := newMatrixEncoder(sampleRate, target)
+		if err != nil {
+			return nil, err
+		}
+		return &bounceTranscoder{decoder: quad, encoder: sqEncoder}, nil
+	}
+
+	if logic || waveMatching || iir {
+		return nil, fmt.Errorf("--logic, --wave-matching and --iir need --bounce")
+	}
+	source, err := matrix.Lookup(matrixName)
+	if err != nil {
+		return nil, err
+	}
+	spectralDecoder, err := decoder.NewSpectralDecoderWithParams(blockSize, overlap)
+	if err != nil {
+		return nil, err
+	}
+	spectralDecoder.SetSampleRate(int(sampleRate))
+	spectralDecoder.SetMatrix(source)
+	spectralDecoder.SetTranscodeTarget(target)
+	return spectralDecoder, nil
+}
+
+// bounceTranscoder decodes to four speakers and encodes them again, like
+// decode followed by encode.
+type bounceTranscoder struct {
+	decoder quadDecoder
+	encoder *encoder.SQEncoder
+}
+
+// ProcessChunk transcodes a chunk of a continuous stream.
+func (t *bounceTranscoder) ProcessChunk(input [][]float64) ([][]float64, error) {
+	decoded, err := t.decoder.ProcessChunk(input)
+	if err != nil {
+		return nil, err
+	}
+	return t.encoder.ProcessChunk(decoded)
+}
+
+// Flush transcodes the samples still buffered by the decoder and encoder.
+func (t *bounceTranscoder) Flush() [][]float64 {
+	output, err := t.encoder.ProcessChunk(t.decoder.Flush())
+	if err != nil {
+		// The decoders always flush four channels of equal length.
+		panic(err)
+	}
+	tail := t.encoder.Flush()
+	for ch := range output {
+		output[ch] = append(output[ch], tail[ch]...)
+	}
+	return output
+}
+
+// GetLatency returns the combined decoder and encoder latency in samples.
+func (t *bounceTranscoder) GetLatency() int {
+	return t.decoder.GetLatency() + t.encoder.GetLatency()
+}
+
+func runTranscode(cmd *cobra.Command, args []string) error {
+	inputFile := args[0]
+	outputFile := args[1]
+
+	target, err := matrix.Lookup(transcodeTo)
+	if err != nil {
+		return err
+	}
+
+	in, err := os.Open(inputFile)
+	if err != nil {
+		return fmt.Errorf("failed to open input WAV: %w", err)
+	}
+	defer in.Close()
+
+	reader, err := wav.NewReader(in, 2)
+	if err != nil {
+		return fmt.Errorf("failed to read input WAV: %w", err)
+	}
+
+	transcoder, err := newTranscoder(reader.SampleRate(), target)
+	if err != nil {
+		return err
+	}
+
+	if verbose {
+		source, _ := matrix.Lookup(matrixName)
+		fmt.Printf("Matrix Transcoder\n")
+		fmt.Printf("=================\n\n")
+		fmt.Printf("Input: %s (%d Hz, %.2f seconds)\n", inputFile, reader.SampleRate(),
+			float64(reader.NumSamples())/float64(reader.SampleRate()))
+		fmt.Printf("  %s -> %s\n\n", source.Description, target.Description)
+		fmt.Printf("Transcoder configuration:\n")
+		if transcodeBounce {
+			fmt.Printf("  Method: four-speaker bounce\n")
+			printPhaseShifter()
+		} else {
+			fmt.Printf("  Method: per-bin direction re-encoding\n")
+			fmt.Printf("  Block size: %d samples\n", blockSize)
+			fmt.Printf("  Overlap: %d samples\n", overlap)
+		}
+		fmt.Printf("  Latency: %d samples (%.2f ms)\n\n",
+			transcoder.GetLatency(),
+			float64(transcoder.GetLatency())/float64(reader.SampleRate())*1000.0)
+		fmt.Printf("Writing output file: %s\n", outputFile)
+		fmt.Printf("Processing...\n")
+	}
+
+	format, err := outputFormat()
+	if err != nil {
+		return err
+	}
+	dither, err := outputDither()
+	if err != nil {
+		return err
+	}
+	clip, err := outputClip()
+	if err != nil {
+		return err
+	}
+	out, err := os.Create(outputFile)
+	if err != nil {
+		return fmt.Errorf("failed to create output WAV: %w", err)
+	}
+	defer out.Close()
+
+	writer, err := wav.NewWriter(out, reader.SampleRate(), 2, format)
+	if err != nil {
+		return fmt.Errorf("failed to write output WAV: %w", err)
+	}
+	writer.SetDither(dither)
+	writer.SetClip(clip)
+
+	chunk := make([][]float64, 2)
+	for ch := range chunk {
+		chunk[ch] = make([]float64, streamChunkSize)
+	}
+	for {
+		n, readErr := reader.ReadFrames(chunk)
+		if readErr != nil && !errors.Is(readErr, io.EOF) {
+			return fmt.Errorf("failed to read input WAV: %w", readErr)
+		}
+
+		transcoded, err := transcoder.ProcessChunk([][]float64{chunk[0][:n], chunk[1][:n]})
+		if err != nil {
+			return fmt.Errorf("transcoding failed: %w", err)
+		}
+		if err := writer.WriteFrames(transcoded); err != nil {
+			return fmt.Errorf("failed to write output WAV: %w", err)
+		}
+
+		if errors.Is(readErr, io.EOF) {
+			break
+		}
+	}
+
+	if err := writer.WriteFrames(transcoder.Flush()); err != nil {
+		return fmt.Errorf("failed to write output WAV: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to write output WAV: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to close output WAV: %w", err)
+	}
+	warnInputOvers(inputFile, reader)
+	warnOutputOvers(outputFile, writer, format, clip)
+
+	if verbose {
+		fmt.Printf("\nDone! Transcoded to %s stereo.\n", target.Description)
+	} else {
+		fmt.Printf("Successfully transcoded %s -> %s\n", inputFile, outputFile)
+	}
+	return nil
+}
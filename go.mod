@@ -3,12 +3,13 @@ module github.com/cwbudde/go-sq-tool
 go 1.25.0
 
 require (
+	github.com/BurntSushi/toml v1.6.0
 	github.com/MeKo-Christian/algo-fft v0.4.2
 	github.com/spf13/cobra v1.8.0
+	github.com/spf13/pflag v1.0.5
 )
 
 require (
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
-	github.com/spf13/pflag v1.0.5 // indirect
 	golang.org/x/sys v0.39.0 // indirect
 )
@@ -0,0 +1,86 @@
+package decoder
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/cwbudde/go-sq-tool/internal/matrix"
+)
+
+// AmbiX channel labels in ACN order.
+const (
+	ChannelAmbiXW = "W"
+	ChannelAmbiXY = "Y"
+	ChannelAmbiXZ = "Z"
+	ChannelAmbiXX = "X"
+)
+
+// AmbiXChannels are the channels of first-order AmbiX (ACN order, SN3D
+// normalization).
+var AmbiXChannels = []string{ChannelAmbiXW, ChannelAmbiXY, ChannelAmbiXZ, ChannelAmbiXX}
+
+// ambiXGains returns the first-order ACN/SN3D encoding gains (W, Y, Z, X) of
+// a horizontal plane wave from azimuth degrees (0 = front, positive towards
+// the left).
+func ambiXGains(azimuth float64) [4]float64 {
+	sin, cos := math.Sincos(azimuth * math.Pi / 180.0)
+	return [4]float64{1, sin, 0, cos}
+}
+
+// AmbiXRenderer encodes the four decoded speakers into first-order AmbiX as
+// plane waves from the speaker directions (LF 45°, RF -45°, LB 135°,
+// RB -135°). It is stateless and adds no latency.
+type AmbiXRenderer struct {
+	gains [4][4]float64
+}
+
+// NewAmbiXRenderer creates an AmbiX encoder for the quad speaker directions.
+func NewAmbiXRenderer() *AmbiXRenderer {
+	r := &AmbiXRenderer{}
+	for ch, azimuth := range matrix.SpeakerAzimuths {
+		r.gains[ch] = ambiXGains(azimuth)
+	}
+	return r
+}
+
+// Process encodes a whole signal.
+// Input: [4][numSamples] - LF, RF, LB, RB
+// Output: [4][numSamples] - W, Y, Z, X
+func (r *AmbiXRenderer) Process(input [][]float64) ([][]float64, error) {
+	return r.ProcessChunk(input)
+}
+
+// ProcessChunk encodes a chunk of a continuous stream; every call returns as
+// many samples as it consumes.
+func (r *AmbiXRenderer) ProcessChunk(input [][]float64) ([][]float64, error) {
+	if len(input) != 4 {
+		return nil, fmt.Errorf("input must have 4 channels, got %d", len(input))
+	}
+	numSamples := len(input[0])
+	for i := 1; i < 4; i++ {
+		if len(input[i]) != numSamples {
+			return nil, fmt.Errorf("input channels must have same length")
+		}
+	}
+
+	output := make([][]float64, len(AmbiXChannels))
+	for j := range output {
+		output[j] = make([]float64, numSamples)
+	}
+	for ch := 0; ch < 4; ch++ {
+		for j, g := range r.gains[ch] {
+			if g == 0 {
+				continue
+			}
+			for i, v := range input[ch] {
+				output[j][i] += g * v
+			}
+		}
+	}
+	return output, nil
+}
+
+// Flush returns no samples: the encoder buffers nothing.
+func (r *AmbiXRenderer) Flush() [][]float64 {
+	return make([][]float64, len(AmbiXChannels))
+}
@@ -0,0 +1,105 @@
+package decoder_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/cwbudde/go-sq-tool/internal/decoder"
+	"github.com/cwbudde/go-sq-tool/internal/encoder"
+)
+
+func TestAmbiXRenderer_EncodesSpeakerDirections(t *testing.T) {
+	t.Parallel()
+
+	r := decoder.NewAmbiXRenderer()
+	k := math.Sqrt2 / 2
+	for ch, want := range [4][4]float64{
+		{1, k, 0, k},   // LF at 45°
+		{1, -k, 0, k},  // RF at -45°
+		{1, k, 0, -k},  // LB at 135°
+		{1, -k, 0, -k}, // RB at -135°
+	} {
+		input := [][]float64{{0}, {0}, {0}, {0}}
+		input[ch][0] = 1
+		out, err := r.Process(input)
+		if err != nil {
+			t.Fatalf("Process failed: %v", err)
+		}
+		for j, label := range decoder.AmbiXChannels {
+			if math.Abs(out[j][0]-want[j]) > 1e-12 {
+				t.Errorf("channel %d: %s = %.4f, want %.4f", ch, label, out[j][0], want[j])
+			}
+		}
+	}
+}
+
+func TestSpectralDecoder_AmbiXOutputPointsAtSource(t *testing.T) {
+	t.Parallel()
+
+	const (
+		sampleRate = 44100.0
+		n          = 1 << 15
+		skip       = 4096
+	)
+
+	// Sources at the speakers and half-way between them, panned with
+	// constant-power pairwise gains.
+	k := math.Sqrt2 / 2
+	cs, sn := math.Cos(math.Pi/8), math.Sin(math.Pi/8)
+	for _, tc := range []struct {
+		azimuth float64
+		gains   [4]float64
+	}{
+		{45, [4]float64{1, 0, 0, 0}},
+		{-135, [4]float64{0, 0, 0, 1}},
+		{0, [4]float64{k, k, 0, 0}},
+		{90, [4]float64{k, 0, k, 0}},
+		{-90, [4]float64{0, k, 0, k}},
+		{180, [4]float64{0, 0, k, k}},
+		{22.5, [4]float64{cs, sn, 0, 0}},
+	} {
+		quad := make([][]float64, 4)
+		for ch := range quad {
+			quad[ch] = make([]float64, n)
+			for i := range quad[ch] {
+				quad[ch][i] = 0.4 * tc.gains[ch] * math.Sin(2.0*math.Pi*1000.0*float64(i)/sampleRate)
+			}
+		}
+		encoded, err := encoder.NewSQEncoder().Process(quad)
+		if err != nil {
+			t.Fatalf("encoder.Process() error = %v", err)
+		}
+
+		d := decoder.NewSpectralDecoder()
+		d.SetSampleRate(sampleRate)
+		d.SetAmbiXOutput(true)
+		foa, err := d.Process(encoded)
+		if err != nil {
+			t.Fatalf("Process failed: %v", err)
+		}
+
+		// Intensity vector: the W-correlation of Y and X.
+		var ww, wy, wz, wx float64
+		for i := skip; i < n-skip; i++ {
+			w := foa[0][i]
+			ww += w * w
+			wy += w * foa[1][i]
+			wz += w * foa[2][i]
+			wx += w * foa[3][i]
+		}
+		azimuth := math.Atan2(wy, wx) * 180 / math.Pi
+		if diff := math.Abs(math.Remainder(azimuth-tc.azimuth, 360)); diff > 2 {
+			t.Errorf("source at %.1f°: AmbiX direction %.1f°", tc.azimuth, azimuth)
+		}
+		if wz != 0 {
+			t.Errorf("source at %.1f°: Z is not silent", tc.azimuth)
+		}
+		// A single plane wave has |(X, Y)| = W in SN3D.
+		if ratio := math.Hypot(wy, wx) / ww; math.Abs(ratio-1) > 0.05 {
+			t.Errorf("source at %.1f°: directional ratio %.3f, want 1", tc.azimuth, ratio)
+		}
+		if rms := math.Sqrt(ww / float64(n-2*skip)); math.Abs(rms-0.4/math.Sqrt2) > 0.02 {
+			t.Errorf("source at %.1f°: W RMS %.3f, want %.3f", tc.azimuth, rms, 0.4/math.Sqrt2)
+		}
+	}
+}
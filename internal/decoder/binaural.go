@@ -0,0 +1,192 @@
+package decoder
+
+import (
+	"fmt"
+
+	"github.com/cwbudde/go-sq-tool/pkg/sqmath"
+)
+
+// DefaultBinauralBlockSize is the convolution block size of the binaural renderer.
+const DefaultBinauralBlockSize = 256
+
+// HRIR is the pair of ear impulse responses of one speaker direction.
+type HRIR struct {
+	Left  []float64
+	Right []float64
+}
+
+// SphericalHeadHRIRs returns spherical-head HRIRs for speakers at azimuths
+// degrees (0 = front, positive towards the left).
+func SphericalHeadHRIRs(azimuths [4]float64, sampleRate int) [4]HRIR {
+	var hrirs [4]HRIR
+	for ch, azimuth := range azimuths {
+		hrirs[ch].Left, hrirs[ch].Right = sqmath.SphericalHeadHRIR(azimuth, float64(sampleRate))
+	}
+	return hrirs
+}
+
+// BinauralRenderer renders the four decoded speakers to a 2-channel
+// headphone signal by convolving each with the HRIRs of its direction.
+type BinauralRenderer struct {
+	blockSize    int
+	tailLength   int
+	convolvers   [4][2]*sqmath.Convolver
+	inputBuffers [4][]float64
+	bufferPos    int
+	streaming    bool
+	block        []float64
+	mix          [2][]float64
+}
+
+// NewBinauralRenderer creates a renderer for the HRIRs of LF, RF, LB, RB.
+func NewBinauralRenderer(hrirs [4]HRIR) (*BinauralRenderer, error) {
+	return NewBinauralRendererWithBlockSize(hrirs, DefaultBinauralBlockSize)
+}
+
+// NewBinauralRendererWithBlockSize creates a renderer with a custom
+// convolution block size (power of 2).
+func NewBinauralRendererWithBlockSize(hrirs [4]HRIR, blockSize int) (*BinauralRenderer, error) {
+	r := &BinauralRenderer{
+		blockSize: blockSize,
+		block:     make([]float64, blockSize),
+	}
+	for ch, hrir := range hrirs {
+		if len(hrir.Left) == 0 || len(hrir.Right) == 0 {
+			return nil, fmt.Errorf("HRIR of channel %d is empty", ch)
+		}
+		r.tailLength = max(r.tailLength, len(hrir.Left)-1, len(hrir.Right)-1)
+		for ear, ir := range [2][]float64{hrir.Left, hrir.Right} {
+			c, err := sqmath.NewConvolver(ir, blockSize)
+			if err != nil {
+				return nil, err
+			}
+			r.convolvers[ch][ear] = c
+		}
+		r.inputBuffers[ch] = make([]float64, blockSize)
+	}
+	for ear := range r.mix {
+		r.mix[ear] = make([]float64, blockSize)
+	}
+	return r, nil
+}
+
+// TailLength returns the number of samples Flush appends after the end of
+// the input: the reverberation tail of the longest HRIR.
+func (r *BinauralRenderer) TailLength() int {
+	return r.tailLength
+}
+
+// Process renders a whole signal.
+// Input: [4][numSamples] - LF, RF, LB, RB
+// Output: [2][numSamples+TailLength()] - left and right ear
+func (r *BinauralRenderer) Process(input [][]float64) ([][]float64, error) {
+	r.Reset()
+	output, err := r.ProcessChunk(input)
+	if err != nil {
+		return nil, err
+	}
+	tail := r.Flush()
+	for ear := range output {
+		output[ear] = append(output[ear], tail[ear]...)
+	}
+	return output, nil
+}
+
+// ProcessChunk renders an arbitrary-sized chunk of a continuous stream and
+// returns the output of every block that became complete. The
+// concatenation of all ProcessChunk outputs followed by Flush equals a
+// single Process call on the whole stream.
+func (r *BinauralRenderer) ProcessChunk(input [][]float64) ([][]float64, error) {
+	if len(input) != 4 {
+		return nil, fmt.Errorf("input must have 4 channels, got %d", len(input))
+	}
+	numSamples := len(input[0])
+	for i := 1; i < 4; i++ {
+		if len(input[i]) != numSamples {
+			return nil, fmt.Errorf("input channels must have same length")
+		}
+	}
+
+	if numSamples > 0 {
+		r.streaming = true
+	}
+
+	numBlocks := (r.bufferPos + numSamples) / r.blockSize
+	output := [][]float64{make([]float64, 0, numBlocks*r.blockSize), make([]float64, 0, numBlocks*r.blockSize)}
+
+	srcIdx := 0
+	for srcIdx < numSamples {
+		n := copy(r.inputBuffers[0][r.bufferPos:], input[0][srcIdx:])
+		for ch := 1; ch < 4; ch++ {
+			copy(r.inputBuffers[ch][r.bufferPos:], input[ch][srcIdx:srcIdx+n])
+		}
+		r.bufferPos += n
+		srcIdx += n
+
+		if r.bufferPos < r.blockSize {
+			break
+		}
+		r.renderBlock()
+		for ear := range output {
+			output[ear] = append(output[ear], r.mix[ear]...)
+		}
+		r.bufferPos = 0
+	}
+
+	return output, nil
+}
+
+// Flush zero-pads and renders the samples still buffered by ProcessChunk,
+// followed by the HRIR tail of TailLength samples. The renderer is ready for
+// a new stream afterwards.
+func (r *BinauralRenderer) Flush() [][]float64 {
+	remaining := r.bufferPos
+	if r.streaming {
+		remaining += r.tailLength
+	}
+	output := [][]float64{make([]float64, 0, remaining), make([]float64, 0, remaining)}
+	for remaining > 0 {
+		for ch := 0; ch < 4; ch++ {
+			for i := r.bufferPos; i < r.blockSize; i++ {
+				r.inputBuffers[ch][i] = 0
+			}
+		}
+		r.renderBlock()
+		n := min(remaining, r.blockSize)
+		for ear := range output {
+			output[ear] = append(output[ear], r.mix[ear][:n]...)
+		}
+		remaining -= n
+		r.bufferPos = 0
+	}
+	r.Reset()
+	return output
+}
+
+// Reset clears the buffered input and convolution history.
+func (r *BinauralRenderer) Reset() {
+	for ch := 0; ch < 4; ch++ {
+		for ear := 0; ear < 2; ear++ {
+			r.convolvers[ch][ear].Reset()
+		}
+	}
+	r.bufferPos = 0
+	r.streaming = false
+}
+
+// renderBlock convolves the full input buffers into mix.
+func (r *BinauralRenderer) renderBlock() {
+	for ear := range r.mix {
+		for i := range r.mix[ear] {
+			r.mix[ear][i] = 0
+		}
+	}
+	for ch := 0; ch < 4; ch++ {
+		for ear := 0; ear < 2; ear++ {
+			r.convolvers[ch][ear].ProcessBlock(r.inputBuffers[ch], r.block)
+			for i, v := range r.block {
+				r.mix[ear][i] += v
+			}
+		}
+	}
+}
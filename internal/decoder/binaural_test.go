@@ -0,0 +1,158 @@
+package decoder_test
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/cwbudde/go-sq-tool/internal/decoder"
+)
+
+var quadSpeakerAzimuths = [4]float64{45, -45, 135, -135}
+
+func TestBinauralRenderer_ChunksMatchProcess(t *testing.T) {
+	t.Parallel()
+
+	const sampleRate = 48000
+	const numSamples = 5000
+	rng := rand.New(rand.NewSource(3))
+	input := make([][]float64, 4)
+	for ch := range input {
+		input[ch] = make([]float64, numSamples)
+		for i := range input[ch] {
+			input[ch][i] = rng.NormFloat64() * 0.25
+		}
+	}
+
+	r, err := decoder.NewBinauralRenderer(decoder.SphericalHeadHRIRs(quadSpeakerAzimuths, sampleRate))
+	if err != nil {
+		t.Fatalf("NewBinauralRenderer() error = %v", err)
+	}
+	want, err := r.Process(input)
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if len(want) != 2 || len(want[0]) != numSamples+r.TailLength() {
+		t.Fatalf("Process returned %d channels of %d samples", len(want), len(want[0]))
+	}
+
+	got := [][]float64{nil, nil}
+	for start, size := 0, 1; start < numSamples; start, size = start+size, size*3%997+1 {
+		end := min(start+size, numSamples)
+		chunk := make([][]float64, 4)
+		for ch := range chunk {
+			chunk[ch] = input[ch][start:end]
+		}
+		out, err := r.ProcessChunk(chunk)
+		if err != nil {
+			t.Fatalf("ProcessChunk failed: %v", err)
+		}
+		for ear := range got {
+			got[ear] = append(got[ear], out[ear]...)
+		}
+	}
+	tail := r.Flush()
+	for ear := range got {
+		got[ear] = append(got[ear], tail[ear]...)
+		if len(got[ear]) != len(want[ear]) {
+			t.Fatalf("ear %d: got %d samples, want %d", ear, len(got[ear]), len(want[ear]))
+		}
+		for i := range got[ear] {
+			if math.Abs(got[ear][i]-want[ear][i]) > 1e-9 {
+				t.Fatalf("ear %d sample %d: chunked %.9f, whole %.9f", ear, i, got[ear][i], want[ear][i])
+			}
+		}
+	}
+}
+
+func TestBinauralRenderer_LateralisesSpeakers(t *testing.T) {
+	t.Parallel()
+
+	const sampleRate = 48000
+	const numSamples = sampleRate / 2
+	r, err := decoder.NewBinauralRenderer(decoder.SphericalHeadHRIRs(quadSpeakerAzimuths, sampleRate))
+	if err != nil {
+		t.Fatalf("NewBinauralRenderer() error = %v", err)
+	}
+
+	for ch, name := range []string{"LF", "RF", "LB", "RB"} {
+		input := make([][]float64, 4)
+		for i := range input {
+			input[i] = make([]float64, numSamples)
+		}
+		for i := range input[ch] {
+			input[ch][i] = math.Sin(2 * math.Pi * 3000 * float64(i) / sampleRate)
+		}
+
+		out, err := r.Process(input)
+		if err != nil {
+			t.Fatalf("Process failed: %v", err)
+		}
+		left, right := rmsOf(out[0]), rmsOf(out[1])
+		if ch%2 == 0 && left <= 2*right {
+			t.Errorf("%s: left ear %.3f, right ear %.3f, want left louder", name, left, right)
+		}
+		if ch%2 == 1 && right <= 2*left {
+			t.Errorf("%s: left ear %.3f, right ear %.3f, want right louder", name, left, right)
+		}
+	}
+}
+
+func TestBinauralRenderer_FlushEmitsHRIRTail(t *testing.T) {
+	t.Parallel()
+
+	// A 700-tap HRIR spans several convolution blocks; an impulse near the
+	// end of the input must come out with every tap.
+	const numSamples = 300
+	ir := make([]float64, 700)
+	for i := range ir {
+		ir[i] = 1.0 / float64(i+1)
+	}
+	var hrirs [4]decoder.HRIR
+	for ch := range hrirs {
+		hrirs[ch] = decoder.HRIR{Left: ir, Right: []float64{1}}
+	}
+	r, err := decoder.NewBinauralRenderer(hrirs)
+	if err != nil {
+		t.Fatalf("NewBinauralRenderer() error = %v", err)
+	}
+	if r.TailLength() != len(ir)-1 {
+		t.Fatalf("TailLength() = %d, want %d", r.TailLength(), len(ir)-1)
+	}
+
+	input := make([][]float64, 4)
+	for ch := range input {
+		input[ch] = make([]float64, numSamples)
+	}
+	input[0][numSamples-1] = 1
+	out, err := r.Process(input)
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if len(out[0]) != numSamples+len(ir)-1 || len(out[1]) != len(out[0]) {
+		t.Fatalf("got %d and %d samples, want %d", len(out[0]), len(out[1]), numSamples+len(ir)-1)
+	}
+	for i, v := range ir {
+		if got := out[0][numSamples-1+i]; math.Abs(got-v) > 1e-9 {
+			t.Fatalf("left sample %d = %g, want HRIR tap %d = %g", numSamples-1+i, got, i, v)
+		}
+	}
+}
+
+func TestNewBinauralRenderer_RejectsEmptyHRIR(t *testing.T) {
+	t.Parallel()
+
+	hrirs := decoder.SphericalHeadHRIRs(quadSpeakerAzimuths, 48000)
+	hrirs[2].Right = nil
+	if _, err := decoder.NewBinauralRenderer(hrirs); err == nil {
+		t.Fatal("NewBinauralRenderer accepted an empty HRIR")
+	}
+}
+
+func rmsOf(x []float64) float64 {
+	sum := 0.0
+	for _, v := range x {
+		sum += v * v
+	}
+	return math.Sqrt(sum / float64(len(x)))
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"math"
 
+	"github.com/cwbudde/go-sq-tool/internal/matrix"
 	"github.com/cwbudde/go-sq-tool/pkg/sqmath"
 )
 
@@ -16,21 +17,33 @@ const (
 
 // SQDecoder implements the SQ² (FFT-based) quadrophonic decoder
 type SQDecoder struct {
-	blockSize     int
-	overlap       int
-	initialDelay  int
-	sqrt2         float64
-	hilbertLeft   *sqmath.HilbertTransformer
-	hilbertRight  *sqmath.HilbertTransformer
-	sampleRate    int
-	logicConfig   LogicSteeringConfig
-	logicEnv      [4]float64
-	attackCoeff   float64
-	releaseCoeff  float64
-	inputBufferL  []float64
-	inputBufferR  []float64
-	outputBuffers [4][]float64
-	bufferPos     int
+	blockSize      int
+	overlap        int
+	initialDelay   int
+	sqrt2          float64
+	hilbertLeft    *sqmath.HilbertTransformer
+	hilbertRight   *sqmath.HilbertTransformer
+	phaseLeft      *sqmath.PhaseDifferenceNetwork
+	phaseRight     *sqmath.PhaseDifferenceNetwork
+	sampleRate     int
+	matrix         matrix.Matrix
+	directions     *directionModel
+	logicConfig    LogicSteeringConfig
+	logicDetectors DirectionDetectors
+	bandSplitters  [4]*sqmath.CrossoverBank
+	bandSignals    [4][]float64
+	bandDetectors  []DirectionDetectors
+	attackCoeff    float64
+	releaseCoeff   float64
+	waveConfig     WaveMatchingConfig
+	wave           waveMatchState
+	waveCoeff      float64
+	// decorrelators hold the LB and RB decorrelators, nil when disabled.
+	decorrelationConfig DecorrelationConfig
+	decorrelators       [2]*sqmath.BandDecorrelator
+	inputBufferL        []float64
+	inputBufferR        []float64
+	bufferPos           int
 }
 
 // NewSQDecoder creates a new SQ decoder with FFT-based Hilbert transform
@@ -44,29 +57,41 @@ func NewSQDecoderWithParams(blockSize, overlap int) *SQDecoder {
 	initialDelay := overlap + overlap/2
 
 	decoder := &SQDecoder{
-		blockSize:    blockSize,
-		overlap:      overlap,
-		initialDelay: initialDelay,
-		sqrt2:        math.Sqrt(2.0) / 2.0, // ≈ 0.707
-		hilbertLeft:  sqmath.NewHilbertTransformer(blockSize, overlap),
-		hilbertRight: sqmath.NewHilbertTransformer(blockSize, overlap),
-		sampleRate:   44100,
-		logicConfig:  DefaultLogicSteeringConfig(),
-		inputBufferL: make([]float64, blockSize),
-		inputBufferR: make([]float64, blockSize),
-		bufferPos:    0,
-	}
-
-	// Initialize output buffers
-	for i := 0; i < 4; i++ {
-		decoder.outputBuffers[i] = make([]float64, blockSize)
+		blockSize:           blockSize,
+		overlap:             overlap,
+		initialDelay:        initialDelay,
+		sqrt2:               math.Sqrt(2.0) / 2.0, // ≈ 0.707
+		hilbertLeft:         sqmath.NewHilbertTransformer(blockSize, overlap),
+		hilbertRight:        sqmath.NewHilbertTransformer(blockSize, overlap),
+		sampleRate:          44100,
+		matrix:              matrix.SQ,
+		directions:          directionModelFor(matrix.SQ),
+		logicConfig:         DefaultLogicSteeringConfig(),
+		waveConfig:          DefaultWaveMatchingConfig(),
+		decorrelationConfig: DefaultDecorrelationConfig(),
+		inputBufferL:        make([]float64, blockSize),
+		inputBufferR:        make([]float64, blockSize),
+		bufferPos:           sqmath.HilbertLookbehind(overlap),
 	}
 
 	decoder.updateLogicCoefficients()
+	decoder.resetWaveMatching()
 
 	return decoder
 }
 
+// NewSQDecoderIIR creates a zero-latency SQ decoder that derives the 90°
+// shifted signals from a cascaded IIR all-pass phase difference network
+// instead of the FFT Hilbert transform. All outputs carry the phase
+// response of the network's direct path.
+func NewSQDecoderIIR(coefs sqmath.PhaseNetworkCoefficients) *SQDecoder {
+	decoder := NewSQDecoderWithParams(DefaultBlockSize, DefaultOverlap)
+	decoder.initialDelay = 0
+	decoder.phaseLeft = sqmath.NewPhaseDifferenceNetwork(coefs)
+	decoder.phaseRight = sqmath.NewPhaseDifferenceNetwork(coefs)
+	return decoder
+}
+
 // SetSampleRate sets the sample rate used for logic steering envelopes.
 func (d *SQDecoder) SetSampleRate(sampleRate int) {
 	if sampleRate <= 0 {
@@ -76,6 +101,14 @@ func (d *SQDecoder) SetSampleRate(sampleRate int) {
 	d.updateLogicCoefficients()
 }
 
+// SetMatrix selects the matrix system of the input (SQ by default). Logic
+// steering assumes the SQ matrix.
+func (d *SQDecoder) SetMatrix(m matrix.Matrix) {
+	d.matrix = m
+	d.directions = directionModelFor(m)
+	d.resetWaveMatching()
+}
+
 // EnableLogicSteering toggles CBS-style logic steering.
 func (d *SQDecoder) EnableLogicSteering(enabled bool) {
 	d.logicConfig.Enabled = enabled
@@ -93,6 +126,9 @@ func (d *SQDecoder) updateLogicCoefficients() {
 	}
 	d.attackCoeff = timeToCoeff(d.logicConfig.AttackTime, d.sampleRate)
 	d.releaseCoeff = timeToCoeff(d.logicConfig.ReleaseTime, d.sampleRate)
+	d.waveCoeff = timeToCoeff(d.waveConfig.SmoothingTime, d.sampleRate)
+	d.updateBandSplitters()
+	d.updateDecorrelators()
 }
 
 // Process decodes stereo SQ-encoded audio to 4-channel quadrophonic
@@ -108,6 +144,10 @@ func (d *SQDecoder) Process(input [][]float64) ([][]float64, error) {
 		return nil, fmt.Errorf("input channels must have same length")
 	}
 
+	if d.phaseLeft != nil {
+		return d.decodeIIR(input), nil
+	}
+
 	// Pad input to block boundaries
 	numBlocks := (numSamples + d.overlap - 1) / d.overlap
 
@@ -118,77 +158,232 @@ func (d *SQDecoder) Process(input [][]float64) ([][]float64, error) {
 	}
 
 	// Process in blocks with overlap
+	blockL := make([]float64, d.blockSize)
+	blockR := make([]float64, d.blockSize)
 	for blockIdx := 0; blockIdx < numBlocks; blockIdx++ {
 		startIdx := blockIdx * d.overlap
+		blockStart := startIdx - d.lookbehind()
 
 		// Prepare input block (with zero padding if needed)
-		blockL := make([]float64, d.blockSize)
-		blockR := make([]float64, d.blockSize)
-
 		for i := 0; i < d.blockSize; i++ {
-			srcIdx := startIdx + i
-			if srcIdx < numSamples {
+			srcIdx := blockStart + i
+			if srcIdx >= 0 && srcIdx < numSamples {
 				blockL[i] = input[0][srcIdx]
 				blockR[i] = input[1][srcIdx]
+			} else {
+				blockL[i] = 0
+				blockR[i] = 0
 			}
-			// else remains 0 (zero padding)
 		}
 
-		// Apply Hilbert transform
-		phaseShiftedL := d.hilbertLeft.ProcessBlock(blockL)
-		phaseShiftedR := d.hilbertRight.ProcessBlock(blockR)
-
-		// Apply SQ decode matrix
-		// Based on SQ² VSTDataModule.pas V2M_Process
-		outputOffset := d.overlap / 2
-		inputOffset := d.overlap / 4
+		count := min(d.overlap, numSamples-startIdx)
+		d.decodeBlock(blockL, blockR, output, startIdx, count)
+	}
 
-		for i := 0; i < d.overlap; i++ {
-			outIdx := startIdx + i
-			if outIdx >= numSamples {
-				break
-			}
+	return output, nil
+}
 
-			inIdx := inputOffset + i
-			if inIdx >= d.blockSize {
-				break
-			}
+// ProcessChunk decodes an arbitrary-sized chunk of a continuous stream.
+// Input: [2][chunkSize] - LT, RT
+// Output: [4][n] - LF, RF, LB, RB for every block that became complete.
+//
+// Overlap state and logic envelopes are carried between calls, so the
+// concatenation of all ProcessChunk outputs followed by Flush is identical
+// to a single Process call on the whole stream.
+func (d *SQDecoder) ProcessChunk(input [][]float64) ([][]float64, error) {
+	if len(input) != 2 {
+		return nil, fmt.Errorf("input must have 2 channels, got %d", len(input))
+	}
 
-			phaseIdx := outputOffset + i
-			if phaseIdx >= d.blockSize {
-				break
-			}
+	numSamples := len(input[0])
+	if len(input[1]) != numSamples {
+		return nil, fmt.Errorf("input channels must have same length")
+	}
 
-			// SQ Decode Matrix:
-			// LF = LT (pass through)
-			// RF = RT (pass through)
-			// LB = sqrt(2)/2 * H(LT) - sqrt(2)/2 * RT
-			// RB = sqrt(2)/2 * LT - sqrt(2)/2 * H(RT)
+	if d.phaseLeft != nil {
+		return d.decodeIIR(input), nil
+	}
 
-			lt := blockL[inIdx]
-			rt := blockR[inIdx]
-			hlt := phaseShiftedL[phaseIdx]
-			hrt := phaseShiftedR[phaseIdx]
+	// Every complete block releases exactly one hop of output.
+	numBlocks := 0
+	if pending := d.bufferPos + numSamples; pending >= d.blockSize {
+		numBlocks = (pending-d.blockSize)/d.overlap + 1
+	}
 
-			lf := lt
-			rf := rt
-			lb := d.sqrt2*hlt - d.sqrt2*rt
-			rb := d.sqrt2*lt - d.sqrt2*hrt
+	output := make([][]float64, 4)
+	for i := 0; i < 4; i++ {
+		output[i] = make([]float64, numBlocks*d.overlap)
+	}
 
-			if d.logicConfig.Enabled {
-				lf, rf, lb, rb = d.applyLogicSteering(lf, rf, lb, rb)
-			}
+	outPos := 0
+	srcIdx := 0
+	for srcIdx < numSamples {
+		n := copy(d.inputBufferL[d.bufferPos:], input[0][srcIdx:])
+		copy(d.inputBufferR[d.bufferPos:], input[1][srcIdx:srcIdx+n])
+		d.bufferPos += n
+		srcIdx += n
 
-			output[0][outIdx] = lf
-			output[1][outIdx] = rf
-			output[2][outIdx] = lb
-			output[3][outIdx] = rb
+		if d.bufferPos < d.blockSize {
+			break
 		}
+
+		d.decodeBlock(d.inputBufferL, d.inputBufferR, output, outPos, d.overlap)
+		outPos += d.overlap
+		d.advanceBuffer()
 	}
 
 	return output, nil
 }
 
+// Flush zero-pads and decodes the samples still buffered by ProcessChunk.
+// Output: [4][n] - the remaining LF, RF, LB, RB samples of the stream.
+// The decoder is ready for a new stream afterwards.
+func (d *SQDecoder) Flush() [][]float64 {
+	pending := max(d.bufferPos-d.lookbehind(), 0)
+	output := make([][]float64, 4)
+	for i := 0; i < 4; i++ {
+		output[i] = make([]float64, pending)
+	}
+
+	outPos := 0
+	for pending > 0 {
+		for i := d.bufferPos; i < d.blockSize; i++ {
+			d.inputBufferL[i] = 0
+			d.inputBufferR[i] = 0
+		}
+
+		count := min(d.overlap, pending)
+		d.decodeBlock(d.inputBufferL, d.inputBufferR, output, outPos, count)
+		outPos += count
+		pending -= count
+		d.advanceBuffer()
+	}
+
+	d.Reset()
+
+	return output
+}
+
+// Reset discards buffered input, detector envelopes, band-split filter,
+// wave-matching, decorrelator and phase network state.
+func (d *SQDecoder) Reset() {
+	for i := range d.inputBufferL {
+		d.inputBufferL[i] = 0
+		d.inputBufferR[i] = 0
+	}
+	d.bufferPos = d.lookbehind()
+	d.logicDetectors = DirectionDetectors{}
+	for b := range d.bandDetectors {
+		d.bandDetectors[b] = DirectionDetectors{}
+	}
+	for ch := 0; ch < 4; ch++ {
+		if d.bandSplitters[ch] != nil {
+			d.bandSplitters[ch].Reset()
+		}
+	}
+	d.resetWaveMatching()
+	d.resetDecorrelators()
+	if d.phaseLeft != nil {
+		d.phaseLeft.Reset()
+		d.phaseRight.Reset()
+	}
+}
+
+// lookbehind is the number of past samples at the start of every block.
+func (d *SQDecoder) lookbehind() int {
+	return sqmath.HilbertLookbehind(d.overlap)
+}
+
+// advanceBuffer drops one hop from the front of the streaming input buffers.
+func (d *SQDecoder) advanceBuffer() {
+	hop := min(d.overlap, d.bufferPos)
+	copy(d.inputBufferL, d.inputBufferL[hop:d.bufferPos])
+	copy(d.inputBufferR, d.inputBufferR[hop:d.bufferPos])
+	d.bufferPos -= hop
+}
+
+// decodeBlock decodes up to count samples of one block into output starting at outPos.
+func (d *SQDecoder) decodeBlock(blockL, blockR []float64, output [][]float64, outPos, count int) {
+	// Apply Hilbert transform
+	phaseShiftedL := d.hilbertLeft.ProcessBlock(blockL)
+	phaseShiftedR := d.hilbertRight.ProcessBlock(blockR)
+
+	// Apply SQ decode matrix
+	// Based on SQ² VSTDataModule.pas V2M_Process
+	// Read the direct and the shifted path time aligned.
+	inputOffset, outputOffset := sqmath.HilbertOffsets(d.overlap)
+
+	for i := 0; i < count; i++ {
+		inIdx := inputOffset + i
+		if inIdx >= d.blockSize {
+			break
+		}
+
+		phaseIdx := outputOffset + i
+		if phaseIdx >= d.blockSize {
+			break
+		}
+
+		lt := blockL[inIdx]
+		rt := blockR[inIdx]
+		hlt := phaseShiftedL[phaseIdx]
+		hrt := phaseShiftedR[phaseIdx]
+
+		lf, rf, lb, rb := d.decodeSample(lt, rt, hlt, hrt)
+		lb, rb = d.decorrelateRears(lb, rb)
+
+		outIdx := outPos + i
+		output[0][outIdx] = lf
+		output[1][outIdx] = rf
+		output[2][outIdx] = lb
+		output[3][outIdx] = rb
+	}
+}
+
+// decodeIIR decodes a chunk sample by sample through the all-pass phase
+// networks. The filter state carries over between calls.
+func (d *SQDecoder) decodeIIR(input [][]float64) [][]float64 {
+	numSamples := len(input[0])
+	output := make([][]float64, 4)
+	for i := 0; i < 4; i++ {
+		output[i] = make([]float64, numSamples)
+	}
+
+	for i := 0; i < numSamples; i++ {
+		lt, hlt := d.phaseLeft.Process(input[0][i])
+		rt, hrt := d.phaseRight.Process(input[1][i])
+		lf, rf, lb, rb := d.decodeSample(lt, rt, hlt, hrt)
+		lb, rb = d.decorrelateRears(lb, rb)
+		output[0][i], output[1][i], output[2][i], output[3][i] = lf, rf, lb, rb
+	}
+
+	return output
+}
+
+// decodeSample applies the decode matrix (and steering) to one sample of
+// Lt/Rt and their 90° shifted versions.
+func (d *SQDecoder) decodeSample(lt, rt, hlt, hrt float64) (float64, float64, float64, float64) {
+	if d.waveConfig.Enabled {
+		return d.applyWaveMatching(lt, rt, hlt, hrt)
+	}
+
+	// Passive decode matrix, for SQ:
+	// LF = LT (pass through)
+	// RF = RT (pass through)
+	// LB = sqrt(2)/2 * H(LT) - sqrt(2)/2 * RT
+	// RB = sqrt(2)/2 * LT - sqrt(2)/2 * H(RT)
+	var out [4]float64
+	for ch, row := range d.matrix.Decode {
+		out[ch] = real(row[0])*lt + imag(row[0])*hlt + real(row[1])*rt + imag(row[1])*hrt
+	}
+	lf, rf, lb, rb := out[0], out[1], out[2], out[3]
+
+	if d.logicConfig.Enabled {
+		return d.applyLogicSteering(lf, rf, lb, rb)
+	}
+	return lf, rf, lb, rb
+}
+
 // GetLatency returns the decoder latency in samples
 func (d *SQDecoder) GetLatency() int {
 	return d.initialDelay
@@ -196,6 +391,10 @@ func (d *SQDecoder) GetLatency() int {
 
 // GetInfo returns information about the decoder configuration
 func (d *SQDecoder) GetInfo() string {
+	if d.phaseLeft != nil {
+		return "SQ Decoder (IIR all-pass)\n" +
+			"Latency: 0 samples"
+	}
 	return fmt.Sprintf("SQ² Decoder (FFT-based)\n"+
 		"Block Size: %d samples\n"+
 		"Overlap: %d samples\n"+
@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/cwbudde/go-sq-tool/internal/decoder"
+	"github.com/cwbudde/go-sq-tool/pkg/sqmath"
 )
 
 func TestSQDecoder_Process_FrontChannelsShifted(t *testing.T) {
@@ -45,6 +46,46 @@ func TestSQDecoder_Process_FrontChannelsShifted(t *testing.T) {
 	}
 }
 
+func TestSQDecoder_Process_RearChannelsUnityGainAligned(t *testing.T) {
+	t.Parallel()
+
+	const (
+		blockSize = 1024
+		overlap   = 512
+		n         = 10 * overlap
+		period    = 37.0
+	)
+
+	// With Lt only, the passive SQ matrix gives LB = sqrt(2)/2·H(Lt) and
+	// RB = sqrt(2)/2·Lt. H(sin) = -cos, so LB must be a unity-gain quadrature
+	// copy of RB, time aligned with the front channels.
+	lt := make([]float64, n)
+	for i := range lt {
+		lt[i] = 0.5 * math.Sin(2.0*math.Pi*float64(i)/period)
+	}
+
+	sqDec := decoder.NewSQDecoderWithParams(blockSize, overlap)
+	out, err := sqDec.Process([][]float64{lt, make([]float64, n)})
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+
+	shift := overlap / 4
+	k := math.Sqrt2 / 2
+	var maxErrLB, maxErrRB float64
+	for i := overlap; i < n-overlap; i++ {
+		phi := 2.0 * math.Pi * float64(i+shift) / period
+		maxErrLB = math.Max(maxErrLB, math.Abs(out[2][i]+k*0.5*math.Cos(phi)))
+		maxErrRB = math.Max(maxErrRB, math.Abs(out[3][i]-k*0.5*math.Sin(phi)))
+	}
+	if maxErrLB > 0.01 {
+		t.Errorf("LB max error vs -sqrt(2)/2·0.5·cos = %.4f, want <= 0.01", maxErrLB)
+	}
+	if maxErrRB > 1e-12 {
+		t.Errorf("RB max error vs sqrt(2)/2·Lt = %.2e, want <= 1e-12", maxErrRB)
+	}
+}
+
 func TestSQDecoder_Process_ZeroInputIsZeroOutput(t *testing.T) {
 	t.Parallel()
 
@@ -120,3 +161,108 @@ func TestSQDecoder_Process_Errors(t *testing.T) {
 		t.Fatalf("expected error for length mismatch")
 	}
 }
+
+func TestSQDecoder_ProcessChunk_MatchesProcess(t *testing.T) {
+	t.Parallel()
+
+	const (
+		blockSize = 1024
+		overlap   = 512
+		n         = 9*overlap + 137
+	)
+
+	lt := make([]float64, n)
+	rt := make([]float64, n)
+	for i := 0; i < n; i++ {
+		lt[i] = 0.5 * math.Sin(2.0*math.Pi*float64(i)/97.0)
+		rt[i] = 0.4 * math.Cos(2.0*math.Pi*float64(i)/61.0)
+	}
+
+	whole := decoder.NewSQDecoderWithParams(blockSize, overlap)
+	whole.SetSampleRate(44100)
+	whole.EnableLogicSteering(true)
+	want, err := whole.Process([][]float64{lt, rt})
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+
+	stream := decoder.NewSQDecoderWithParams(blockSize, overlap)
+	stream.SetSampleRate(44100)
+	stream.EnableLogicSteering(true)
+
+	got := make([][]float64, 4)
+	chunkSizes := []int{1, 300, 1023, 2, 700, 4096, 17}
+	for pos, c := 0, 0; pos < n; c++ {
+		end := min(pos+chunkSizes[c%len(chunkSizes)], n)
+		out, err := stream.ProcessChunk([][]float64{lt[pos:end], rt[pos:end]})
+		if err != nil {
+			t.Fatalf("ProcessChunk() error = %v", err)
+		}
+		for ch := range got {
+			got[ch] = append(got[ch], out[ch]...)
+		}
+		pos = end
+	}
+	tail := stream.Flush()
+	for ch := range got {
+		got[ch] = append(got[ch], tail[ch]...)
+	}
+
+	for ch := 0; ch < 4; ch++ {
+		if len(got[ch]) != n {
+			t.Fatalf("len(out[%d]) = %d, want %d", ch, len(got[ch]), n)
+		}
+		for i := 0; i < n; i++ {
+			if got[ch][i] != want[ch][i] {
+				t.Fatalf("out[%d][%d] = %.15f, want %.15f", ch, i, got[ch][i], want[ch][i])
+			}
+		}
+	}
+}
+
+func TestSQDecoderIIR_ProcessChunk_MatchesProcess(t *testing.T) {
+	t.Parallel()
+
+	const n = 5000
+
+	lt := make([]float64, n)
+	rt := make([]float64, n)
+	for i := 0; i < n; i++ {
+		lt[i] = 0.5 * math.Sin(2.0*math.Pi*float64(i)/97.0)
+		rt[i] = 0.4 * math.Cos(2.0*math.Pi*float64(i)/61.0)
+	}
+
+	whole := decoder.NewSQDecoderIIR(sqmath.NiemitaloCoefficients)
+	want, err := whole.Process([][]float64{lt, rt})
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+
+	stream := decoder.NewSQDecoderIIR(sqmath.NiemitaloCoefficients)
+	got := make([][]float64, 4)
+	for pos := 0; pos < n; pos += 333 {
+		end := min(pos+333, n)
+		out, err := stream.ProcessChunk([][]float64{lt[pos:end], rt[pos:end]})
+		if err != nil {
+			t.Fatalf("ProcessChunk() error = %v", err)
+		}
+		// Nothing is buffered, so every input sample is decoded immediately.
+		if len(out[0]) != end-pos {
+			t.Fatalf("ProcessChunk() returned %d samples, want %d", len(out[0]), end-pos)
+		}
+		for ch := range got {
+			got[ch] = append(got[ch], out[ch]...)
+		}
+	}
+	if tail := stream.Flush(); len(tail[0]) != 0 {
+		t.Fatalf("Flush() returned %d samples, want 0", len(tail[0]))
+	}
+
+	for ch := 0; ch < 4; ch++ {
+		for i := 0; i < n; i++ {
+			if got[ch][i] != want[ch][i] {
+				t.Fatalf("out[%d][%d] = %.15f, want %.15f", ch, i, got[ch][i], want[ch][i])
+			}
+		}
+	}
+}
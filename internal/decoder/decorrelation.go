@@ -0,0 +1,192 @@
+package decoder
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+
+	algofft "github.com/MeKo-Christian/algo-fft"
+	"github.com/cwbudde/go-sq-tool/pkg/sqmath"
+)
+
+// Rear decorrelation filters.
+const (
+	DecorrelationAllpass = "allpass"
+	DecorrelationVelvet  = "velvet"
+)
+
+// DecorrelationConfig defines the rear-channel decorrelation of SQDecoder.
+type DecorrelationConfig struct {
+	Enabled bool
+	// Method is DecorrelationAllpass or DecorrelationVelvet.
+	Method string
+	// Crossovers lists ascending band edges in Hz.
+	Crossovers []float64
+	// Strengths holds the decorrelation strength of every band from low to
+	// high (0 = off, 1 = fully decorrelated), one more entry than
+	// Crossovers. Missing entries are 1.
+	Strengths []float64
+	// Seed selects the decorrelation filters; LB and RB use different ones.
+	Seed uint64
+}
+
+// DefaultDecorrelationConfig returns rear decorrelation defaults: the lows
+// stay untouched for a solid bass and mono fold-down, the mids are
+// decorrelated partly and the highs fully.
+func DefaultDecorrelationConfig() DecorrelationConfig {
+	return DecorrelationConfig{
+		Enabled:    false,
+		Method:     DecorrelationAllpass,
+		Crossovers: []float64{250, 2500},
+		Strengths:  []float64{0, 0.5, 1},
+		Seed:       1,
+	}
+}
+
+// monoCheckSize is the FFT size of the mono fold-down check.
+const monoCheckSize = 8192
+
+// SetDecorrelationConfig updates the rear decorrelation. In the passive
+// matrix LB and RB are linear combinations of Lt and Rt, so ambience in the
+// rears is correlated with the fronts and collapses toward the head;
+// decorrelating the rears restores envelopment.
+func (d *SQDecoder) SetDecorrelationConfig(config DecorrelationConfig) error {
+	if config.Method != DecorrelationAllpass && config.Method != DecorrelationVelvet {
+		return fmt.Errorf("unknown decorrelation method %q (use %s, %s)", config.Method, DecorrelationAllpass, DecorrelationVelvet)
+	}
+	for i, f := range config.Crossovers {
+		if f <= 0 || (i > 0 && f <= config.Crossovers[i-1]) {
+			return fmt.Errorf("decorrelation crossovers must be positive and ascending")
+		}
+	}
+	if len(config.Strengths) > len(config.Crossovers)+1 {
+		return fmt.Errorf("%d decorrelation strengths for %d bands", len(config.Strengths), len(config.Crossovers)+1)
+	}
+	for _, s := range config.Strengths {
+		if s < 0 || s > 1 {
+			return fmt.Errorf("decorrelation strength must be in [0, 1], got %g", s)
+		}
+	}
+
+	d.decorrelationConfig = config
+	d.updateDecorrelators()
+	return nil
+}
+
+// updateDecorrelators rebuilds the rear decorrelators for the current
+// configuration and sample rate.
+func (d *SQDecoder) updateDecorrelators() {
+	d.decorrelators = [2]*sqmath.BandDecorrelator{}
+	if !d.decorrelationConfig.Enabled {
+		return
+	}
+	for i := range d.decorrelators {
+		d.decorrelators[i] = d.newRearDecorrelator(i)
+	}
+}
+
+// newRearDecorrelator creates the decorrelator of rear channel i (0 = LB,
+// 1 = RB).
+func (d *SQDecoder) newRearDecorrelator(i int) *sqmath.BandDecorrelator {
+	config := d.decorrelationConfig
+	sampleRate := float64(d.sampleRate)
+	seed := config.Seed*2 + uint64(i)
+
+	var filter sqmath.Decorrelator
+	if config.Method == DecorrelationVelvet {
+		filter = sqmath.NewVelvetDecorrelator(sampleRate, seed)
+	} else {
+		filter = sqmath.NewAllpassDecorrelator(sampleRate, seed)
+	}
+	return sqmath.NewBandDecorrelator(filter, config.Crossovers, config.Strengths, sampleRate)
+}
+
+// decorrelateRears applies the rear decorrelators, if enabled.
+func (d *SQDecoder) decorrelateRears(lb, rb float64) (float64, float64) {
+	if d.decorrelators[0] == nil {
+		return lb, rb
+	}
+	return d.decorrelators[0].Process(lb), d.decorrelators[1].Process(rb)
+}
+
+// resetDecorrelators clears the rear decorrelator state.
+func (d *SQDecoder) resetDecorrelators() {
+	for _, dec := range d.decorrelators {
+		if dec != nil {
+			dec.Reset()
+		}
+	}
+}
+
+// MonoFoldDownDeviation checks that the mono fold-down LF+RF+LB+RB of the
+// passive decoder stays compatible with rear decorrelation. For a diffuse
+// field (uncorrelated sources from every direction) it compares the
+// fold-down power spectrum with and without the decorrelators and returns
+// the largest deviation of a third-octave band in dB and its centre
+// frequency in Hz. It returns 0 when decorrelation is disabled.
+func (d *SQDecoder) MonoFoldDownDeviation() (float64, float64) {
+	if !d.decorrelationConfig.Enabled {
+		return 0, 0
+	}
+
+	plan, err := algofft.NewPlan64(monoCheckSize)
+	if err != nil {
+		panic(err)
+	}
+	var responses [2][]complex128
+	for i := range responses {
+		dec := d.newRearDecorrelator(i)
+		impulse := make([]complex128, monoCheckSize)
+		for n := range impulse {
+			x := 0.0
+			if n == 0 {
+				x = 1
+			}
+			impulse[n] = complex(dec.Process(x), 0)
+		}
+		responses[i] = make([]complex128, monoCheckSize)
+		if err := plan.Forward(responses[i], impulse); err != nil {
+			panic(err)
+		}
+	}
+
+	// Fold-down gains of the fronts and rears for every direction; for
+	// positive frequencies a coefficient c acts as conj(c).
+	candidates := d.directions.candidates
+	fronts := make([]complex128, len(candidates))
+	rears := make([][2]complex128, len(candidates))
+	for j, c := range candidates {
+		for ch, row := range d.matrix.Decode {
+			g := cmplx.Conj(row[0]*c.vector[0] + row[1]*c.vector[1])
+			if ch < 2 {
+				fronts[j] += g
+			} else {
+				rears[j][ch-2] = g
+			}
+		}
+	}
+
+	// Compare the power in third-octave bands: narrow comb notches are
+	// inaudible, a broad colouration is not.
+	worst, worstFreq := 0.0, 0.0
+	for lo := 1; lo < monoCheckSize/2; {
+		hi := min(monoCheckSize/2, max(lo+1, int(math.Ceil(float64(lo)*math.Pow(2, 1.0/3.0)))))
+		var ref, dec float64
+		for k := lo; k < hi; k++ {
+			for j := range candidates {
+				dry := fronts[j] + rears[j][0] + rears[j][1]
+				wet := fronts[j] + responses[0][k]*rears[j][0] + responses[1][k]*rears[j][1]
+				ref += real(dry)*real(dry) + imag(dry)*imag(dry)
+				dec += real(wet)*real(wet) + imag(wet)*imag(wet)
+			}
+		}
+		if ref > logicEpsilon {
+			if deviation := 10.0 * math.Log10(dec/ref); math.Abs(deviation) > math.Abs(worst) {
+				worst = deviation
+				worstFreq = math.Sqrt(float64(lo)*float64(hi)) * float64(d.sampleRate) / monoCheckSize
+			}
+		}
+		lo = hi
+	}
+	return worst, worstFreq
+}
@@ -0,0 +1,94 @@
+package decoder_test
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/cwbudde/go-sq-tool/internal/decoder"
+)
+
+func TestSQDecoder_DecorrelatesRears(t *testing.T) {
+	t.Parallel()
+
+	const n = 1 << 16
+	rng := rand.New(rand.NewPCG(1, 2))
+	noise := make([]float64, n)
+	for i := range noise {
+		noise[i] = 0.1 * rng.NormFloat64()
+	}
+
+	// Front centre: passively LB = k·H(Lt) - k·Rt follows LF = Lt.
+	frontRearCorrelation := func(config decoder.DecorrelationConfig) float64 {
+		d := decoder.NewSQDecoder()
+		if err := d.SetDecorrelationConfig(config); err != nil {
+			t.Fatalf("SetDecorrelationConfig() error = %v", err)
+		}
+		out, err := d.Process([][]float64{noise, noise})
+		if err != nil {
+			t.Fatalf("Process() error = %v", err)
+		}
+		var xy, xx, yy float64
+		for i := 4096; i < n-4096; i++ {
+			xy += out[0][i] * out[2][i]
+			xx += out[0][i] * out[0][i]
+			yy += out[2][i] * out[2][i]
+		}
+		return xy / math.Sqrt(xx*yy)
+	}
+
+	config := decoder.DefaultDecorrelationConfig()
+	if c := frontRearCorrelation(config); math.Abs(c+math.Sqrt2/2) > 0.02 {
+		t.Errorf("passive LF/LB correlation = %.3f, want -0.707", c)
+	}
+	config.Enabled = true
+	config.Strengths = []float64{1, 1, 1}
+	if c := frontRearCorrelation(config); math.Abs(c) > 0.1 {
+		t.Errorf("decorrelated LF/LB correlation = %.3f, want ~0", c)
+	}
+}
+
+func TestSQDecoder_MonoFoldDownDeviation(t *testing.T) {
+	t.Parallel()
+
+	d := decoder.NewSQDecoder()
+	if dev, _ := d.MonoFoldDownDeviation(); dev != 0 {
+		t.Errorf("disabled decorrelation deviates by %.2f dB", dev)
+	}
+
+	config := decoder.DefaultDecorrelationConfig()
+	config.Enabled = true
+	if err := d.SetDecorrelationConfig(config); err != nil {
+		t.Fatalf("SetDecorrelationConfig() error = %v", err)
+	}
+	if dev, freq := d.MonoFoldDownDeviation(); math.Abs(dev) > 3 {
+		t.Errorf("default decorrelation deviates by %.2f dB at %.0f Hz", dev, freq)
+	}
+
+	// The short velvet-noise filter colours the fold-down in the lows.
+	config.Method = decoder.DecorrelationVelvet
+	config.Strengths = []float64{1, 1, 1}
+	if err := d.SetDecorrelationConfig(config); err != nil {
+		t.Fatalf("SetDecorrelationConfig() error = %v", err)
+	}
+	if dev, freq := d.MonoFoldDownDeviation(); math.Abs(dev) < 3 || freq > 250 {
+		t.Errorf("full-band velvet decorrelation deviates by %.2f dB at %.0f Hz, want > 3 dB in the lows", dev, freq)
+	}
+}
+
+func TestSQDecoder_SetDecorrelationConfig_Errors(t *testing.T) {
+	t.Parallel()
+
+	for _, mutate := range []func(*decoder.DecorrelationConfig){
+		func(c *decoder.DecorrelationConfig) { c.Method = "reverb" },
+		func(c *decoder.DecorrelationConfig) { c.Crossovers = []float64{2500, 250} },
+		func(c *decoder.DecorrelationConfig) { c.Strengths = []float64{0, 0.5, 1, 1} },
+		func(c *decoder.DecorrelationConfig) { c.Strengths = []float64{0, 1.5} },
+	} {
+		config := decoder.DefaultDecorrelationConfig()
+		mutate(&config)
+		if err := decoder.NewSQDecoder().SetDecorrelationConfig(config); err == nil {
+			t.Errorf("SetDecorrelationConfig(%+v) succeeded", config)
+		}
+	}
+}
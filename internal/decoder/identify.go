@@ -0,0 +1,381 @@
+package decoder
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+	"sort"
+	"sync"
+
+	algofft "github.com/MeKo-Christian/algo-fft"
+	"github.com/cwbudde/go-sq-tool/internal/matrix"
+)
+
+// Format identification compares the Lt/Rt direction of every coherent
+// time-frequency tile with the directions each format can produce. A
+// format whose sound stage contains a tile's direction explains it; formats
+// with a smaller stage are preferred when several do, so front-only
+// material scores as plain stereo and any quadrature or anti-phase content
+// points to the matrix that puts a source there.
+
+const (
+	identifyFFTSize = 2048
+	identifyHop     = 1024
+	// identifySmoothingTime is the time constant of the per-bin Lt/Rt
+	// statistics in seconds.
+	identifySmoothingTime = 0.1
+	// Analysis band; outside it the encoder phase shifters are least accurate.
+	identifyMinFreq = 150.0
+	identifyMaxFreq = 8000.0
+	// identifyCoherence is the minimum coherence (0.5 = diffuse, 1 = single
+	// source) of a tile that carries direction information.
+	identifyCoherence = 0.9
+	// identifyConcentration sets how far (in direction score) a tile may
+	// lie from a format's stage and still count as explained.
+	identifyConcentration = 50.0
+	// identifyOutlier is the likelihood floor of a tile that a format
+	// cannot explain, limiting the weight of single stray tiles.
+	identifyOutlier = 1e-3
+	// identifyEvidence scales the mean log-likelihood into confidences.
+	identifyEvidence = 5.0
+)
+
+// StereoFormat is the format name of plain (non-matrixed) stereo.
+const StereoFormat = "stereo"
+
+// FormatScore is the identification result for one format.
+type FormatScore struct {
+	Name        string
+	Description string
+	// Confidence is the probability of the format (all formats sum to 1).
+	Confidence float64
+	// Fit is the energy-weighted mean direction score of the coherent tiles
+	// at their best matching direction (1 = every tile lies on the format's
+	// sound stage).
+	Fit float64
+	// Directions is the share of coherent energy decoded to LF, RF, LB, RB.
+	Directions [4]float64
+}
+
+// PhaseStatistics is the distribution of the Rt-Lt phase difference of the
+// coherent signal, weighted by how well the phase is defined (both channels
+// carrying the source).
+type PhaseStatistics struct {
+	// InPhase is the share within 45° of 0°.
+	InPhase float64
+	// Quadrature is the share between 45° and 135° either way.
+	Quadrature float64
+	// AntiPhase is the share within 45° of 180°.
+	AntiPhase float64
+}
+
+// Identification is the result of IdentifyFormat.
+type Identification struct {
+	// Formats are sorted by descending confidence.
+	Formats []FormatScore
+	// Coherence is the share of analysed energy in coherent tiles.
+	Coherence float64
+	Phase     PhaseStatistics
+}
+
+// stereoDirections is the direction model of plain stereo: in-phase
+// amplitude panning between the front speakers.
+var stereoDirections = sync.OnceValue(func() *directionModel {
+	candidates := make([]directionCandidate, 91)
+	for i := range candidates {
+		pan := float64(i) * math.Pi / 180.0
+		azimuth := matrix.SpeakerAzimuths[0] - float64(i)
+		candidates[i] = directionCandidate{
+			azimuth: azimuth,
+			vector:  [2]complex128{complex(math.Cos(pan), 0), complex(math.Sin(pan), 0)},
+			norm:    1,
+			gains:   matrix.PanGains(azimuth),
+		}
+	}
+	return newDirectionModel([4][2]complex128{{1, 0}, {0, 1}}, candidates)
+})
+
+// makeLikelihoodTable returns the log-likelihood of a single source in
+// every direction table cell, assuming sources are spread evenly over the
+// candidates.
+func makeLikelihoodTable(candidates []directionCandidate) [][]float64 {
+	table := make([][]float64, directionTableMagnitudeSteps+1)
+	for m := range table {
+		table[m] = make([]float64, directionTablePhaseSteps)
+		for p := range table[m] {
+			covLL, covRR, covLR := directionCellCovariance(m, p)
+
+			sum := 0.0
+			for _, c := range candidates {
+				sum += math.Exp(identifyConcentration * (c.score(covLL, covRR, covLR) - 1.0))
+			}
+			table[m][p] = math.Log(sum/float64(len(candidates)) + identifyOutlier)
+		}
+	}
+	return table
+}
+
+// principalDirection returns the covariance of the dominant source of a
+// Lt/Rt covariance, normalized to unit power, and its coherence.
+func principalDirection(covLL, covRR float64, covLR complex128) (float64, float64, complex128, float64) {
+	total := covLL + covRR
+	lambda := 0.5 * (total + math.Sqrt((covLL-covRR)*(covLL-covRR)+4.0*real(covLR*cmplx.Conj(covLR))))
+
+	// Eigenvector of [[LL, LR], [conj(LR), RR]] for the larger eigenvalue.
+	var l, r complex128
+	if covLL >= covRR {
+		l, r = complex(lambda-covRR, 0), cmplx.Conj(covLR)
+	} else {
+		l, r = covLR, complex(lambda-covLL, 0)
+	}
+	norm := real(l*cmplx.Conj(l) + r*cmplx.Conj(r))
+	if norm <= logicEpsilon {
+		return 1, 0, 0, lambda / total
+	}
+	return real(l*cmplx.Conj(l)) / norm, real(r*cmplx.Conj(r)) / norm, l * cmplx.Conj(r) / complex(norm, 0), lambda / total
+}
+
+// identifyHypothesis accumulates the evidence for one format.
+type identifyHypothesis struct {
+	score  FormatScore
+	model  *directionModel
+	logSum float64
+	fitSum float64
+}
+
+// FormatIdentifier estimates the matrix format of a stereo stream chunk by
+// chunk, so long inputs do not have to be held in memory. Feed the stream
+// with ProcessChunk and read the estimate with Result.
+type FormatIdentifier struct {
+	hypotheses []*identifyHypothesis
+	plan       *algofft.Plan[complex128]
+	window     []float64
+	minBin     int
+	maxBin     int
+	smoothing  float64
+	bins       []spectralBin
+
+	inputBuffers [2][]float64
+	bufferPos    int
+	frames       int
+	frame        [2][]complex128
+	spectrum     [2][]complex128
+
+	totalEnergy    float64
+	coherentEnergy float64
+	phaseWeight    float64
+	phase          PhaseStatistics
+}
+
+// NewFormatIdentifier creates an identifier that weighs plain stereo
+// against the given matrices.
+func NewFormatIdentifier(sampleRate int, matrices []matrix.Matrix) (*FormatIdentifier, error) {
+	if sampleRate <= 0 {
+		return nil, fmt.Errorf("sample rate must be > 0")
+	}
+
+	plan, err := algofft.NewPlan64(identifyFFTSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create FFT plan: %w", err)
+	}
+
+	binWidth := float64(sampleRate) / identifyFFTSize
+	f := &FormatIdentifier{
+		hypotheses: []*identifyHypothesis{{
+			score: FormatScore{Name: StereoFormat, Description: "Plain stereo"},
+			model: stereoDirections(),
+		}},
+		plan:      plan,
+		window:    make([]float64, identifyFFTSize),
+		minBin:    max(1, int(math.Ceil(identifyMinFreq/binWidth))),
+		maxBin:    min(identifyFFTSize/2-1, int(identifyMaxFreq/binWidth)),
+		smoothing: math.Exp(-identifyHop / (identifySmoothingTime * float64(sampleRate))),
+		bins:      make([]spectralBin, identifyFFTSize/2+1),
+	}
+	for _, m := range matrices {
+		f.hypotheses = append(f.hypotheses, &identifyHypothesis{
+			score: FormatScore{Name: m.Name, Description: m.Description},
+			model: directionModelFor(m),
+		})
+	}
+	for n := range f.window {
+		f.window[n] = 0.5 * (1.0 - math.Cos(2.0*math.Pi*float64(n)/identifyFFTSize))
+	}
+	for ch := 0; ch < 2; ch++ {
+		f.inputBuffers[ch] = make([]float64, identifyFFTSize)
+		f.frame[ch] = make([]complex128, identifyFFTSize)
+		f.spectrum[ch] = make([]complex128, identifyFFTSize)
+	}
+	return f, nil
+}
+
+// ProcessChunk analyses an arbitrary-sized chunk of a continuous stream.
+// Input: [2][chunkSize] - LT, RT
+func (f *FormatIdentifier) ProcessChunk(input [][]float64) error {
+	if len(input) != 2 {
+		return fmt.Errorf("input must have 2 channels, got %d", len(input))
+	}
+	numSamples := len(input[0])
+	if len(input[1]) != numSamples {
+		return fmt.Errorf("input channels must have same length")
+	}
+
+	srcIdx := 0
+	for srcIdx < numSamples {
+		n := copy(f.inputBuffers[0][f.bufferPos:], input[0][srcIdx:])
+		copy(f.inputBuffers[1][f.bufferPos:], input[1][srcIdx:srcIdx+n])
+		f.bufferPos += n
+		srcIdx += n
+
+		if f.bufferPos < identifyFFTSize {
+			break
+		}
+		if err := f.processFrame(); err != nil {
+			return err
+		}
+		for ch := 0; ch < 2; ch++ {
+			copy(f.inputBuffers[ch], f.inputBuffers[ch][identifyHop:])
+		}
+		f.bufferPos -= identifyHop
+	}
+	return nil
+}
+
+// processFrame analyses the full input buffer.
+func (f *FormatIdentifier) processFrame() error {
+	f.frames++
+	for ch := 0; ch < 2; ch++ {
+		for i := range f.frame[ch] {
+			f.frame[ch][i] = complex(f.inputBuffers[ch][i]*f.window[i], 0)
+		}
+		if err := f.plan.Forward(f.spectrum[ch], f.frame[ch]); err != nil {
+			return fmt.Errorf("FFT failed: %w", err)
+		}
+	}
+
+	a := f.smoothing
+	for k := f.minBin; k <= f.maxBin; k++ {
+		// conj(X) follows the coefficient convention, see steerBins.
+		l := cmplx.Conj(f.spectrum[0][k])
+		r := cmplx.Conj(f.spectrum[1][k])
+
+		bin := &f.bins[k]
+		bin.covLL = a*bin.covLL + (1.0-a)*(real(l)*real(l)+imag(l)*imag(l))
+		bin.covRR = a*bin.covRR + (1.0-a)*(real(r)*real(r)+imag(r)*imag(r))
+		bin.covLR = complex(a, 0)*bin.covLR + complex(1.0-a, 0)*l*cmplx.Conj(r)
+
+		energy := bin.covLL + bin.covRR
+		if energy <= logicEpsilon {
+			continue
+		}
+		f.totalEnergy += energy
+
+		covLL, covRR, covLR, coherence := principalDirection(bin.covLL, bin.covRR, bin.covLR)
+		if coherence < identifyCoherence {
+			continue
+		}
+		f.coherentEnergy += energy
+
+		// |covLR| = |Lt||Rt| is 0.5 when both channels carry the source
+		// equally and 0 when it is hard panned.
+		weight := 2.0 * energy * cmplx.Abs(covLR)
+		f.phaseWeight += weight
+		switch diff := math.Abs(cmplx.Phase(covLR)); {
+		case diff < math.Pi/4.0:
+			f.phase.InPhase += weight
+		case diff > 3.0*math.Pi/4.0:
+			f.phase.AntiPhase += weight
+		default:
+			f.phase.Quadrature += weight
+		}
+
+		mag, p := directionCell(covLL, covRR, covLR)
+		for _, h := range f.hypotheses {
+			h.logSum += energy * h.model.likelihood()[mag][p]
+
+			candidate := h.model.candidates[h.model.table()[mag][p]]
+			h.fitSum += energy * candidate.score(covLL, covRR, covLR)
+			for ch, g := range candidate.gains {
+				h.score.Directions[ch] += energy * g * g
+			}
+		}
+	}
+	return nil
+}
+
+// Result returns the identification of the stream so far. A stream shorter
+// than one analysis frame is analysed zero-padded. Call it after the last
+// ProcessChunk.
+func (f *FormatIdentifier) Result() (Identification, error) {
+	if f.frames == 0 {
+		for ch := 0; ch < 2; ch++ {
+			for i := f.bufferPos; i < identifyFFTSize; i++ {
+				f.inputBuffers[ch][i] = 0
+			}
+		}
+		if err := f.processFrame(); err != nil {
+			return Identification{}, err
+		}
+	}
+
+	result := Identification{}
+	if f.totalEnergy > 0 {
+		result.Coherence = f.coherentEnergy / f.totalEnergy
+	}
+	if f.phaseWeight > 0 {
+		result.Phase = PhaseStatistics{
+			InPhase:    f.phase.InPhase / f.phaseWeight,
+			Quadrature: f.phase.Quadrature / f.phaseWeight,
+			AntiPhase:  f.phase.AntiPhase / f.phaseWeight,
+		}
+	}
+
+	// Confidences are a softmax over the mean log-likelihoods; without
+	// coherent signal every format is equally likely.
+	scores := make([]FormatScore, len(f.hypotheses))
+	logs := make([]float64, len(f.hypotheses))
+	maxLog := math.Inf(-1)
+	for i, h := range f.hypotheses {
+		scores[i] = h.score
+		logs[i] = h.logSum
+		if f.coherentEnergy > 0 {
+			logs[i] /= f.coherentEnergy
+			scores[i].Fit = h.fitSum / f.coherentEnergy
+			for ch := range scores[i].Directions {
+				scores[i].Directions[ch] /= f.coherentEnergy
+			}
+		}
+		maxLog = math.Max(maxLog, logs[i])
+	}
+	sum := 0.0
+	for i := range scores {
+		scores[i].Confidence = math.Exp(identifyEvidence * (logs[i] - maxLog))
+		sum += scores[i].Confidence
+	}
+	for i := range scores {
+		scores[i].Confidence /= sum
+	}
+	sort.SliceStable(scores, func(i, j int) bool {
+		return scores[i].Confidence > scores[j].Confidence
+	})
+	result.Formats = scores
+
+	return result, nil
+}
+
+// IdentifyFormat estimates whether a stereo signal is plain stereo or
+// encoded with one of the given matrices.
+// Input: [2][numSamples] - LT, RT
+func IdentifyFormat(input [][]float64, sampleRate int, matrices []matrix.Matrix) (Identification, error) {
+	if len(input) != 2 {
+		return Identification{}, fmt.Errorf("input must have 2 channels, got %d", len(input))
+	}
+	f, err := NewFormatIdentifier(sampleRate, matrices)
+	if err != nil {
+		return Identification{}, err
+	}
+	if err := f.ProcessChunk(input); err != nil {
+		return Identification{}, err
+	}
+	return f.Result()
+}
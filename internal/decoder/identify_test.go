@@ -0,0 +1,157 @@
+package decoder_test
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/cwbudde/go-sq-tool/internal/decoder"
+	"github.com/cwbudde/go-sq-tool/internal/encoder"
+	"github.com/cwbudde/go-sq-tool/internal/matrix"
+	"github.com/cwbudde/go-sq-tool/pkg/sqmath"
+)
+
+// identifyTone returns tone cluster s: three sines on frequencies of its
+// own, so different clusters do not share time-frequency tiles.
+func identifyTone(s, sampleRate, numSamples int) []float64 {
+	tone := make([]float64, numSamples)
+	for h := 0; h < 3; h++ {
+		freq := 250.0 + 150.0*float64(s+8*h)
+		for i := range tone {
+			tone[i] += 0.1 * math.Sin(2*math.Pi*freq*float64(i)/float64(sampleRate))
+		}
+	}
+	return tone
+}
+
+// identifySources returns quad test material: tone clusters at the four
+// speakers, at the sides, front and back centre.
+func identifySources(sampleRate, numSamples int) [][]float64 {
+	feeds := [][4]float64{
+		{1, 0, 0, 0},
+		{0, 1, 0, 0},
+		{0, 0, 1, 0},
+		{0, 0, 0, 1},
+		{math.Sqrt2 / 2, 0, math.Sqrt2 / 2, 0},
+		{0, math.Sqrt2 / 2, 0, math.Sqrt2 / 2},
+		{math.Sqrt2 / 2, math.Sqrt2 / 2, 0, 0},
+		{0, 0, math.Sqrt2 / 2, math.Sqrt2 / 2},
+	}
+
+	quad := make([][]float64, 4)
+	for ch := range quad {
+		quad[ch] = make([]float64, numSamples)
+	}
+	for s, feed := range feeds {
+		tone := identifyTone(s, sampleRate, numSamples)
+		for ch := range feed {
+			for i, v := range tone {
+				quad[ch][i] += feed[ch] * v
+			}
+		}
+	}
+	return quad
+}
+
+func TestIdentifyFormat_RecognizesMatrix(t *testing.T) {
+	t.Parallel()
+
+	const sampleRate = 44100
+	quad := identifySources(sampleRate, sampleRate*2)
+
+	for _, m := range []matrix.Matrix{matrix.SQ, matrix.QS, matrix.EV4, matrix.UHJ} {
+		enc := encoder.NewSQEncoderIIR(sqmath.NiemitaloCoefficients)
+		enc.SetMatrix(m)
+		encoded, err := enc.Process(quad)
+		if err != nil {
+			t.Fatalf("%s: encode failed: %v", m.Name, err)
+		}
+
+		result, err := decoder.IdentifyFormat(encoded, sampleRate, matrix.All())
+		if err != nil {
+			t.Fatalf("%s: identify failed: %v", m.Name, err)
+		}
+		if got := result.Formats[0].Name; got != m.Name {
+			t.Errorf("%s material identified as %s", m.Name, got)
+		}
+	}
+}
+
+func TestIdentifyFormat_RecognizesStereo(t *testing.T) {
+	t.Parallel()
+
+	const sampleRate = 44100
+	numSamples := sampleRate * 2
+
+	// Tone clusters amplitude panned across the stereo stage.
+	stereo := [][]float64{make([]float64, numSamples), make([]float64, numSamples)}
+	for s := 0; s < 8; s++ {
+		pan := float64(s) / 7 * math.Pi / 2
+		tone := identifyTone(s, sampleRate, numSamples)
+		for i, v := range tone {
+			stereo[0][i] += math.Cos(pan) * v
+			stereo[1][i] += math.Sin(pan) * v
+		}
+	}
+
+	result, err := decoder.IdentifyFormat(stereo, sampleRate, matrix.All())
+	if err != nil {
+		t.Fatalf("identify failed: %v", err)
+	}
+	if got := result.Formats[0].Name; got != decoder.StereoFormat {
+		t.Errorf("stereo material identified as %s", got)
+	}
+	if result.Phase.InPhase < 0.99 {
+		t.Errorf("in-phase share = %.3f, want ~1", result.Phase.InPhase)
+	}
+}
+
+func TestIdentifyFormat_RejectsInvalidInput(t *testing.T) {
+	t.Parallel()
+
+	if _, err := decoder.IdentifyFormat(make([][]float64, 4), 44100, matrix.All()); err == nil {
+		t.Error("expected error for 4-channel input")
+	}
+	if _, err := decoder.IdentifyFormat([][]float64{{0}, {0}}, 0, matrix.All()); err == nil {
+		t.Error("expected error for zero sample rate")
+	}
+}
+
+func TestFormatIdentifier_ChunksMatchWholeSignal(t *testing.T) {
+	t.Parallel()
+
+	const sampleRate = 44100
+	quad := identifySources(sampleRate, sampleRate)
+	enc := encoder.NewSQEncoderIIR(sqmath.NiemitaloCoefficients)
+	encoded, err := enc.Process(quad)
+	if err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+
+	// A stream shorter than one analysis frame is analysed zero-padded.
+	for _, numSamples := range []int{len(encoded[0]), 1000} {
+		want, err := decoder.IdentifyFormat([][]float64{encoded[0][:numSamples], encoded[1][:numSamples]}, sampleRate, matrix.All())
+		if err != nil {
+			t.Fatalf("IdentifyFormat() error = %v", err)
+		}
+
+		f, err := decoder.NewFormatIdentifier(sampleRate, matrix.All())
+		if err != nil {
+			t.Fatalf("NewFormatIdentifier() error = %v", err)
+		}
+		for pos := 0; pos < numSamples; pos += 777 {
+			end := min(pos+777, numSamples)
+			if err := f.ProcessChunk([][]float64{encoded[0][pos:end], encoded[1][pos:end]}); err != nil {
+				t.Fatalf("ProcessChunk() error = %v", err)
+			}
+		}
+		got, err := f.Result()
+		if err != nil {
+			t.Fatalf("Result() error = %v", err)
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%d samples: chunked result %+v differs from whole-signal result %+v", numSamples, got, want)
+		}
+	}
+}
@@ -0,0 +1,333 @@
+package decoder
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/cwbudde/go-sq-tool/pkg/sqmath"
+)
+
+// Channel labels of the output layouts.
+const (
+	ChannelFrontLeft   = "FL"
+	ChannelFrontRight  = "FR"
+	ChannelFrontCenter = "FC"
+	ChannelLFE         = "LFE"
+	ChannelBackLeft    = "BL"
+	ChannelBackRight   = "BR"
+	ChannelBackCenter  = "BC"
+	ChannelSideLeft    = "SL"
+	ChannelSideRight   = "SR"
+)
+
+// Layout is a speaker layout rendered from the decoded LF, RF, LB, RB
+// channels.
+type Layout struct {
+	Name string
+	// Channels lists the output channel labels in WAV channel-mask order.
+	Channels []string
+}
+
+// Output layouts. Quad passes the decoder output through unchanged.
+var (
+	LayoutQuad = Layout{Name: "quad", Channels: []string{
+		ChannelFrontLeft, ChannelFrontRight, ChannelBackLeft, ChannelBackRight,
+	}}
+	Layout5_1 = Layout{Name: "5.1", Channels: []string{
+		ChannelFrontLeft, ChannelFrontRight, ChannelFrontCenter, ChannelLFE,
+		ChannelBackLeft, ChannelBackRight,
+	}}
+	Layout6_1 = Layout{Name: "6.1", Channels: []string{
+		ChannelFrontLeft, ChannelFrontRight, ChannelFrontCenter, ChannelLFE,
+		ChannelBackLeft, ChannelBackRight, ChannelBackCenter,
+	}}
+	Layout7_1 = Layout{Name: "7.1", Channels: []string{
+		ChannelFrontLeft, ChannelFrontRight, ChannelFrontCenter, ChannelLFE,
+		ChannelBackLeft, ChannelBackRight, ChannelSideLeft, ChannelSideRight,
+	}}
+)
+
+// Slots of the channel labels in a rendered frame.
+const (
+	slotFrontLeft = iota
+	slotFrontRight
+	slotFrontCenter
+	slotLFE
+	slotBackLeft
+	slotBackRight
+	slotBackCenter
+	slotSideLeft
+	slotSideRight
+	numSlots
+)
+
+var channelSlots = map[string]int{
+	ChannelFrontLeft:   slotFrontLeft,
+	ChannelFrontRight:  slotFrontRight,
+	ChannelFrontCenter: slotFrontCenter,
+	ChannelLFE:         slotLFE,
+	ChannelBackLeft:    slotBackLeft,
+	ChannelBackRight:   slotBackRight,
+	ChannelBackCenter:  slotBackCenter,
+	ChannelSideLeft:    slotSideLeft,
+	ChannelSideRight:   slotSideRight,
+}
+
+var layouts = map[string]Layout{
+	LayoutQuad.Name: LayoutQuad,
+	Layout5_1.Name:  Layout5_1,
+	Layout6_1.Name:  Layout6_1,
+	Layout7_1.Name:  Layout7_1,
+}
+
+// LookupLayout returns the output layout with the given name.
+func LookupLayout(name string) (Layout, error) {
+	layout, ok := layouts[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return Layout{}, fmt.Errorf("unknown layout %q (use %s)", name, strings.Join(LayoutNames(), ", "))
+	}
+	return layout, nil
+}
+
+// LayoutNames returns the names of all output layouts in sorted order.
+func LayoutNames() []string {
+	names := make([]string, 0, len(layouts))
+	for name := range layouts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LayoutConfig defines the parameters of the layout renderer.
+type LayoutConfig struct {
+	// SmoothingTime is the time constant of the pair correlation detectors
+	// in seconds.
+	SmoothingTime float64
+	// CoherenceThreshold is the pair coherence (0.5 = uncorrelated, 1 = a
+	// single source) above which correlated content moves to the phantom
+	// centre speaker of the pair.
+	CoherenceThreshold float64
+	// Strength scales the extraction (0 = none, 1 = full).
+	Strength float64
+	// LFECrossover is the LFE lowpass frequency in Hz.
+	LFECrossover float64
+	// BassManagement removes the content below LFECrossover from the main
+	// channels; otherwise the LFE carries a copy of it.
+	BassManagement bool
+}
+
+// DefaultLayoutConfig returns layout renderer defaults.
+func DefaultLayoutConfig() LayoutConfig {
+	return LayoutConfig{
+		SmoothingTime:      0.02,
+		CoherenceThreshold: 0.6,
+		Strength:           1.0,
+		LFECrossover:       80,
+		BassManagement:     false,
+	}
+}
+
+// lfeGain compensates the +10 dB playback gain of the LFE channel.
+var lfeGain = math.Pow(10, -10.0/20.0)
+
+// pairExtractor moves the in-phase correlated content of a speaker pair to
+// a phantom speaker between them.
+type pairExtractor struct {
+	covAA float64
+	covBB float64
+	covAB float64
+}
+
+// process splits one sample of the pair (a, b) into a, centre, b. The
+// dominant source of the pair is re-panned over the three speakers by its
+// position between a and b; the uncorrelated remainder stays in a and b.
+func (p *pairExtractor) process(a, b, smoothing float64, config LayoutConfig) (float64, float64, float64) {
+	s := smoothing
+	p.covAA = s*p.covAA + (1.0-s)*a*a
+	p.covBB = s*p.covBB + (1.0-s)*b*b
+	p.covAB = s*p.covAB + (1.0-s)*a*b
+
+	total := p.covAA + p.covBB
+	if total <= logicEpsilon || p.covAB <= 0 {
+		return a, 0, b
+	}
+
+	// Principal axis of the pair covariance: 0 = a only, π/2 = b only.
+	angle := 0.5 * math.Atan2(2.0*p.covAB, p.covAA-p.covBB)
+	spread := math.Sqrt((p.covAA-p.covBB)*(p.covAA-p.covBB) + 4.0*p.covAB*p.covAB)
+	coherence := 0.5 * (total + spread) / total
+	weight := coherenceWeight(coherence, config.CoherenceThreshold, config.Strength)
+	if weight <= 0 {
+		return a, 0, b
+	}
+
+	cosA, sinA := math.Cos(angle), math.Sin(angle)
+	source := cosA*a + sinA*b
+	residual := -sinA*a + cosA*b
+
+	// Constant-power panning over a, centre, b.
+	var gainA, gainC, gainB float64
+	if pos := angle / (math.Pi / 2.0); pos <= 0.5 {
+		phi := pos * math.Pi
+		gainA, gainC = math.Cos(phi), math.Sin(phi)
+	} else {
+		phi := (pos - 0.5) * math.Pi
+		gainC, gainB = math.Cos(phi), math.Sin(phi)
+	}
+
+	steeredA := gainA*source - sinA*residual
+	steeredB := gainB*source + cosA*residual
+	return (1.0-weight)*a + weight*steeredA, weight * gainC * source, (1.0-weight)*b + weight*steeredB
+}
+
+// LayoutRenderer renders decoded quad audio to a speaker layout. The front
+// centre takes the correlated content of LF/RF (for SQ the correlated
+// Lt+Rt content), the back centre that of LB/RB (where the SQ centre-back
+// direction decodes) and the 7.1 sides that of each LF/LB and RF/RB pair.
+type LayoutRenderer struct {
+	layout     Layout
+	config     LayoutConfig
+	smoothing  float64
+	slots      []int
+	has        [numSlots]bool
+	front      pairExtractor
+	back       pairExtractor
+	left       pairExtractor
+	right      pairExtractor
+	lfe        *sqmath.LinkwitzRiley4
+	bassSplits [numSlots]*sqmath.LinkwitzRiley4
+}
+
+// NewLayoutRenderer creates a renderer for layout at sampleRate Hz.
+func NewLayoutRenderer(layout Layout, sampleRate int, config LayoutConfig) *LayoutRenderer {
+	r := &LayoutRenderer{
+		layout:    layout,
+		config:    config,
+		smoothing: timeToCoeff(config.SmoothingTime, sampleRate),
+		slots:     make([]int, len(layout.Channels)),
+	}
+	for i, label := range layout.Channels {
+		r.slots[i] = channelSlots[label]
+		r.has[r.slots[i]] = true
+	}
+	if r.has[slotLFE] {
+		if config.BassManagement {
+			for _, slot := range r.slots {
+				if slot != slotLFE {
+					r.bassSplits[slot] = sqmath.NewLinkwitzRiley4(config.LFECrossover, float64(sampleRate))
+				}
+			}
+		} else {
+			r.lfe = sqmath.NewLinkwitzRiley4(config.LFECrossover, float64(sampleRate))
+		}
+	}
+	return r
+}
+
+// Layout returns the output layout.
+func (r *LayoutRenderer) Layout() Layout {
+	return r.layout
+}
+
+// Process renders a whole signal.
+// Input: [4][numSamples] - LF, RF, LB, RB
+// Output: [len(Layout().Channels)][numSamples] in layout channel order.
+func (r *LayoutRenderer) Process(input [][]float64) ([][]float64, error) {
+	r.Reset()
+	output, err := r.ProcessChunk(input)
+	r.Reset()
+	return output, err
+}
+
+// ProcessChunk renders a chunk of a continuous stream. Rendering adds no
+// latency, so every call returns as many samples as it consumes.
+func (r *LayoutRenderer) ProcessChunk(input [][]float64) ([][]float64, error) {
+	if len(input) != 4 {
+		return nil, fmt.Errorf("input must have 4 channels, got %d", len(input))
+	}
+	numSamples := len(input[0])
+	for i := 1; i < 4; i++ {
+		if len(input[i]) != numSamples {
+			return nil, fmt.Errorf("input channels must have same length")
+		}
+	}
+
+	output := make([][]float64, len(r.layout.Channels))
+	for ch := range output {
+		output[ch] = make([]float64, numSamples)
+	}
+
+	var frame [numSlots]float64
+	for i := 0; i < numSamples; i++ {
+		r.renderFrame(input[0][i], input[1][i], input[2][i], input[3][i], &frame)
+		for ch, slot := range r.slots {
+			output[ch][i] = frame[slot]
+		}
+	}
+
+	return output, nil
+}
+
+// renderFrame renders one sample into frame.
+func (r *LayoutRenderer) renderFrame(lf, rf, lb, rb float64, frame *[numSlots]float64) {
+	frame[slotFrontLeft], frame[slotFrontRight] = lf, rf
+	frame[slotBackLeft], frame[slotBackRight] = lb, rb
+	if !r.has[slotFrontCenter] {
+		return
+	}
+
+	frame[slotFrontLeft], frame[slotFrontCenter], frame[slotFrontRight] = r.front.process(lf, rf, r.smoothing, r.config)
+	if r.has[slotBackCenter] {
+		frame[slotBackLeft], frame[slotBackCenter], frame[slotBackRight] = r.back.process(lb, rb, r.smoothing, r.config)
+	}
+	if r.has[slotSideLeft] {
+		frame[slotFrontLeft], frame[slotSideLeft], frame[slotBackLeft] = r.left.process(frame[slotFrontLeft], lb, r.smoothing, r.config)
+		frame[slotFrontRight], frame[slotSideRight], frame[slotBackRight] = r.right.process(frame[slotFrontRight], rb, r.smoothing, r.config)
+	}
+
+	if !r.has[slotLFE] {
+		return
+	}
+	sum := 0.0
+	for _, slot := range r.slots {
+		if slot == slotLFE {
+			continue
+		}
+		if split := r.bassSplits[slot]; split != nil {
+			low, high := split.Process(frame[slot])
+			frame[slot] = high
+			sum += low
+		} else {
+			sum += frame[slot]
+		}
+	}
+	if r.lfe != nil {
+		sum, _ = r.lfe.Process(sum)
+	}
+	frame[slotLFE] = lfeGain * sum
+}
+
+// Flush returns the (empty) remainder of the stream and resets the renderer.
+func (r *LayoutRenderer) Flush() [][]float64 {
+	r.Reset()
+	return make([][]float64, len(r.layout.Channels))
+}
+
+// Reset clears the detector and filter state.
+func (r *LayoutRenderer) Reset() {
+	r.front = pairExtractor{}
+	r.back = pairExtractor{}
+	r.left = pairExtractor{}
+	r.right = pairExtractor{}
+	if r.lfe != nil {
+		r.lfe.Reset()
+	}
+	for _, split := range r.bassSplits {
+		if split != nil {
+			split.Reset()
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package decoder_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/cwbudde/go-sq-tool/internal/decoder"
+)
+
+// renderQuad renders a quad signal whose channels carry sine at the given
+// gains and returns the steady-state RMS of every output channel.
+func renderQuad(t *testing.T, layout decoder.Layout, config decoder.LayoutConfig, gains [4]float64, freq float64) map[string]float64 {
+	t.Helper()
+
+	const sampleRate = 48000
+	const numSamples = sampleRate
+	quad := make([][]float64, 4)
+	for ch := range quad {
+		quad[ch] = make([]float64, numSamples)
+		for i := range quad[ch] {
+			quad[ch][i] = gains[ch] * math.Sin(2*math.Pi*freq*float64(i)/sampleRate)
+		}
+	}
+
+	r := decoder.NewLayoutRenderer(layout, sampleRate, config)
+	out, err := r.Process(quad)
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if len(out) != len(layout.Channels) {
+		t.Fatalf("got %d channels, want %d", len(out), len(layout.Channels))
+	}
+
+	rms := make(map[string]float64, len(out))
+	for ch, label := range layout.Channels {
+		sum := 0.0
+		for _, v := range out[ch][numSamples/2:] {
+			sum += v * v
+		}
+		rms[label] = math.Sqrt(sum / float64(numSamples/2))
+	}
+	return rms
+}
+
+func TestLayoutRenderer_ExtractsPhantomCentres(t *testing.T) {
+	t.Parallel()
+
+	config := decoder.DefaultLayoutConfig()
+	k := math.Sqrt2 / 2
+	cases := []struct {
+		name   string
+		layout decoder.Layout
+		gains  [4]float64
+		target string
+	}{
+		{"front centre", decoder.Layout5_1, [4]float64{k, k, 0, 0}, decoder.ChannelFrontCenter},
+		{"back centre", decoder.Layout6_1, [4]float64{0, 0, k, k}, decoder.ChannelBackCenter},
+		{"left side", decoder.Layout7_1, [4]float64{k, 0, k, 0}, decoder.ChannelSideLeft},
+		{"right side", decoder.Layout7_1, [4]float64{0, k, 0, k}, decoder.ChannelSideRight},
+		{"left front", decoder.Layout7_1, [4]float64{1, 0, 0, 0}, decoder.ChannelFrontLeft},
+	}
+
+	for _, tc := range cases {
+		rms := renderQuad(t, tc.layout, config, tc.gains, 1000)
+		want := math.Sqrt2 / 2 // RMS of a unit sine
+		for label, got := range rms {
+			switch {
+			case label == tc.target:
+				if math.Abs(got-want) > 0.01 {
+					t.Errorf("%s: %s RMS = %.4f, want %.4f", tc.name, label, got, want)
+				}
+			case label == decoder.ChannelLFE:
+			default:
+				if got > 0.01 {
+					t.Errorf("%s: %s RMS = %.4f, want ~0", tc.name, label, got)
+				}
+			}
+		}
+	}
+}
+
+func TestLayoutRenderer_QuadIsTransparent(t *testing.T) {
+	t.Parallel()
+
+	rms := renderQuad(t, decoder.LayoutQuad, decoder.DefaultLayoutConfig(), [4]float64{0.1, 0.2, 0.3, 0.4}, 1000)
+	for i, label := range decoder.LayoutQuad.Channels {
+		want := 0.1 * float64(i+1) * math.Sqrt2 / 2
+		if math.Abs(rms[label]-want) > 1e-3 {
+			t.Errorf("%s RMS = %.4f, want %.4f", label, rms[label], want)
+		}
+	}
+}
+
+func TestLayoutRenderer_BassManagement(t *testing.T) {
+	t.Parallel()
+
+	config := decoder.DefaultLayoutConfig()
+	config.BassManagement = true
+	lfeGain := math.Pow(10, -10.0/20.0)
+	unit := math.Sqrt2 / 2
+
+	low := renderQuad(t, decoder.Layout5_1, config, [4]float64{1, 0, 0, 0}, 20)
+	if got, want := low[decoder.ChannelLFE], lfeGain*unit; math.Abs(got-want) > 0.02*want {
+		t.Errorf("20 Hz LFE RMS = %.4f, want %.4f", got, want)
+	}
+	if got := low[decoder.ChannelFrontLeft]; got > 0.02 {
+		t.Errorf("20 Hz FL RMS = %.4f, want ~0 with bass management", got)
+	}
+
+	high := renderQuad(t, decoder.Layout5_1, config, [4]float64{1, 0, 0, 0}, 2000)
+	if got := high[decoder.ChannelLFE]; got > 0.01 {
+		t.Errorf("2 kHz LFE RMS = %.4f, want ~0", got)
+	}
+	if got := high[decoder.ChannelFrontLeft]; math.Abs(got-unit) > 0.01 {
+		t.Errorf("2 kHz FL RMS = %.4f, want %.4f", got, unit)
+	}
+
+	config.BassManagement = false
+	full := renderQuad(t, decoder.Layout5_1, config, [4]float64{1, 0, 0, 0}, 20)
+	if got := full[decoder.ChannelFrontLeft]; math.Abs(got-unit) > 0.01 {
+		t.Errorf("20 Hz FL RMS without bass management = %.4f, want %.4f", got, unit)
+	}
+	if got, want := full[decoder.ChannelLFE], lfeGain*unit; math.Abs(got-want) > 0.02*want {
+		t.Errorf("20 Hz LFE RMS without bass management = %.4f, want %.4f", got, want)
+	}
+}
+
+func TestLookupLayout(t *testing.T) {
+	t.Parallel()
+
+	for _, name := range decoder.LayoutNames() {
+		if _, err := decoder.LookupLayout(name); err != nil {
+			t.Errorf("LookupLayout(%q) error = %v", name, err)
+		}
+	}
+	if _, err := decoder.LookupLayout("9.1"); err == nil {
+		t.Error("LookupLayout(\"9.1\") succeeded")
+	}
+}
+
+func TestLayoutRenderer_ChunksMatchProcess(t *testing.T) {
+	t.Parallel()
+
+	const sampleRate = 48000
+	const numSamples = 4000
+	input := make([][]float64, 4)
+	for ch := range input {
+		input[ch] = make([]float64, numSamples)
+		for i := range input[ch] {
+			input[ch][i] = math.Sin(2*math.Pi*float64(200*(ch+1))*float64(i)/sampleRate) * 0.5
+		}
+	}
+
+	r := decoder.NewLayoutRenderer(decoder.Layout7_1, sampleRate, decoder.DefaultLayoutConfig())
+	want, err := r.Process(input)
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+
+	got := make([][]float64, len(want))
+	for start, size := 0, 7; start < numSamples; start, size = start+size, size*2%611+1 {
+		end := min(start+size, numSamples)
+		chunk := make([][]float64, 4)
+		for ch := range chunk {
+			chunk[ch] = input[ch][start:end]
+		}
+		out, err := r.ProcessChunk(chunk)
+		if err != nil {
+			t.Fatalf("ProcessChunk failed: %v", err)
+		}
+		for ch := range got {
+			got[ch] = append(got[ch], out[ch]...)
+		}
+	}
+	for ch, tail := range r.Flush() {
+		got[ch] = append(got[ch], tail...)
+	}
+
+	for ch := range want {
+		if len(got[ch]) != len(want[ch]) {
+			t.Fatalf("channel %d: got %d samples, want %d", ch, len(got[ch]), len(want[ch]))
+		}
+		for i := range want[ch] {
+			if math.Abs(got[ch][i]-want[ch][i]) > 1e-12 {
+				t.Fatalf("channel %d sample %d: chunked %.12f, whole %.12f", ch, i, got[ch][i], want[ch][i])
+			}
+		}
+	}
+}
@@ -1,21 +1,43 @@
 package decoder
 
-import "math"
+import (
+	"math"
+
+	"github.com/cwbudde/go-sq-tool/pkg/sqmath"
+)
 
 const logicEpsilon = 1e-12
 
 // LogicSteeringConfig defines CBS-style logic steering parameters.
 type LogicSteeringConfig struct {
-	Enabled            bool
-	AttackTime         float64
-	ReleaseTime        float64
+	Enabled     bool
+	AttackTime  float64
+	ReleaseTime float64
+	// DominanceThreshold is the direction detector ratio (0..1) above which
+	// steering engages.
+	DominanceThreshold float64
+	MaxBoost           float64
+	MinGain            float64
+	// Crossovers lists ascending band edges in Hz. When empty, steering is
+	// broadband and uses DominanceThreshold/MaxBoost/MinGain above.
+	Crossovers []float64
+	// Bands holds per-band parameters from low to high, one more entry than
+	// Crossovers. Missing entries fall back to the broadband parameters.
+	Bands []LogicBandConfig
+}
+
+// LogicBandConfig defines the steering parameters of a single band.
+type LogicBandConfig struct {
 	DominanceThreshold float64
 	MaxBoost           float64
 	MinGain            float64
 }
 
 // DefaultLogicSteeringConfig returns conservative logic steering defaults.
+// Steering is split into lows, mids and highs, with the lows steered less so
+// that a dominant bass line does not pull the whole image.
 func DefaultLogicSteeringConfig() LogicSteeringConfig {
+	crossovers := []float64{250, 2500}
 	return LogicSteeringConfig{
 		Enabled:            false,
 		AttackTime:         0.01,
@@ -23,9 +45,32 @@ func DefaultLogicSteeringConfig() LogicSteeringConfig {
 		DominanceThreshold: 0.55,
 		MaxBoost:           1.6,
 		MinGain:            0.4,
+		Crossovers:         crossovers,
+		Bands:              DefaultLogicBands(len(crossovers) + 1),
 	}
 }
 
+// DefaultLogicBands returns band parameters for numBands bands. The lowest
+// band is steered gently when there is more than one band.
+func DefaultLogicBands(numBands int) []LogicBandConfig {
+	bands := make([]LogicBandConfig, numBands)
+	for i := range bands {
+		bands[i] = LogicBandConfig{
+			DominanceThreshold: 0.55,
+			MaxBoost:           1.6,
+			MinGain:            0.4,
+		}
+	}
+	if numBands > 1 {
+		bands[0] = LogicBandConfig{
+			DominanceThreshold: 0.7,
+			MaxBoost:           1.2,
+			MinGain:            0.75,
+		}
+	}
+	return bands
+}
+
 func timeToCoeff(seconds float64, sampleRate int) float64 {
 	if seconds <= 0 || sampleRate <= 0 {
 		return 0
@@ -33,54 +78,183 @@ func timeToCoeff(seconds float64, sampleRate int) float64 {
 	return math.Exp(-1.0 / (seconds * float64(sampleRate)))
 }
 
+// bandConfig returns the parameters of band b, falling back to the broadband ones.
+func (c LogicSteeringConfig) bandConfig(b int) LogicBandConfig {
+	if b < len(c.Bands) {
+		return c.Bands[b]
+	}
+	return c.broadbandConfig()
+}
+
+// broadbandConfig returns the parameters used when steering is not band-split.
+func (c LogicSteeringConfig) broadbandConfig() LogicBandConfig {
+	return LogicBandConfig{
+		DominanceThreshold: c.DominanceThreshold,
+		MaxBoost:           c.MaxBoost,
+		MinGain:            c.MinGain,
+	}
+}
+
+// DirectionDetectors holds the smoothed energies of the CBS direction
+// detector signals derived from Lt/Rt and their Hilbert transforms.
+type DirectionDetectors struct {
+	Left  float64 // Lt
+	Right float64 // Rt
+	Front float64 // F = Lt + Rt (centre front)
+	Back  float64 // B = H(Lt) - H(Rt) (centre back)
+	Diag1 float64 // D1 = Lt - H(Rt) (right back)
+	Diag2 float64 // D2 = H(Lt) - Rt (left back)
+}
+
+// LeftRight returns the left/right detector ratio in [-1, 1]; +1 is left front.
+func (dd DirectionDetectors) LeftRight() float64 {
+	return detectorRatio(dd.Left, dd.Right)
+}
+
+// FrontBack returns the front/back detector ratio in [-1, 1]; +1 is centre front.
+func (dd DirectionDetectors) FrontBack() float64 {
+	return detectorRatio(dd.Front, dd.Back)
+}
+
+// Diagonal returns the diagonal detector ratio in [-1, 1]; +1 is right back.
+func (dd DirectionDetectors) Diagonal() float64 {
+	return detectorRatio(dd.Diag1, dd.Diag2)
+}
+
+func detectorRatio(a, b float64) float64 {
+	return (a - b) / (a + b + logicEpsilon)
+}
+
+// logicTargets lists the outputs (LF, RF, LB, RB) favoured by each steering
+// direction: left front, right front, left back, right back, centre front
+// and centre back.
+var logicTargets = [6][4]bool{
+	{true, false, false, false},
+	{false, true, false, false},
+	{false, false, true, false},
+	{false, false, false, true},
+	{true, true, false, false},
+	{false, false, true, true},
+}
+
+// Detectors returns the current direction detector envelopes, one entry per
+// steering band (a single entry for broadband steering).
+func (d *SQDecoder) Detectors() []DirectionDetectors {
+	if len(d.bandDetectors) == 0 {
+		return []DirectionDetectors{d.logicDetectors}
+	}
+	return append([]DirectionDetectors(nil), d.bandDetectors...)
+}
+
+// updateBandSplitters rebuilds the per-channel crossovers for the current config.
+func (d *SQDecoder) updateBandSplitters() {
+	crossovers := d.logicConfig.Crossovers
+	if len(crossovers) == 0 {
+		d.bandSplitters = [4]*sqmath.CrossoverBank{}
+		d.bandDetectors = nil
+		return
+	}
+
+	for ch := 0; ch < 4; ch++ {
+		d.bandSplitters[ch] = sqmath.NewCrossoverBank(crossovers, float64(d.sampleRate))
+		d.bandSignals[ch] = make([]float64, len(crossovers)+1)
+	}
+	d.bandDetectors = make([]DirectionDetectors, len(crossovers)+1)
+}
+
 func (d *SQDecoder) applyLogicSteering(lf, rf, lb, rb float64) (float64, float64, float64, float64) {
-	energies := [4]float64{lf * lf, rf * rf, lb * lb, rb * rb}
-	for i := 0; i < 4; i++ {
-		env := d.logicEnv[i]
-		energy := energies[i]
-		if energy > env {
-			d.logicEnv[i] = d.attackCoeff*env + (1.0-d.attackCoeff)*energy
-		} else {
-			d.logicEnv[i] = d.releaseCoeff*env + (1.0-d.releaseCoeff)*energy
+	in := [4]float64{lf, rf, lb, rb}
+
+	if d.bandSplitters[0] == nil {
+		out := d.steer(&d.logicDetectors, d.logicConfig.broadbandConfig(), in)
+		return out[0], out[1], out[2], out[3]
+	}
+
+	for ch := 0; ch < 4; ch++ {
+		d.bandSplitters[ch].Process(in[ch], d.bandSignals[ch])
+	}
+
+	var out [4]float64
+	for b := range d.bandDetectors {
+		bandIn := [4]float64{
+			d.bandSignals[0][b],
+			d.bandSignals[1][b],
+			d.bandSignals[2][b],
+			d.bandSignals[3][b],
+		}
+		bandOut := d.steer(&d.bandDetectors[b], d.logicConfig.bandConfig(b), bandIn)
+		for ch := 0; ch < 4; ch++ {
+			out[ch] += bandOut[ch]
 		}
 	}
 
+	return out[0], out[1], out[2], out[3]
+}
+
+// steer updates the detector envelopes in det from the matrix outputs in
+// (LF, RF, LB, RB) and applies direction-based gains to them.
+func (d *SQDecoder) steer(det *DirectionDetectors, config LogicBandConfig, in [4]float64) [4]float64 {
+	// The passive matrix outputs are linear in Lt, Rt, H(Lt) and H(Rt):
+	// LF = Lt, RF = Rt, LB = k*D2 and RB = k*D1 with k = sqrt(2)/2, which
+	// also gives B = H(Lt) - H(Rt) = D1 + D2 - (Lt - Rt).
+	lt := in[0]
+	rt := in[1]
+	diag1 := in[3] / d.sqrt2
+	diag2 := in[2] / d.sqrt2
+	front := lt + rt
+	back := diag1 + diag2 - (lt - rt)
+
+	d.follow(&det.Left, lt*lt)
+	d.follow(&det.Right, rt*rt)
+	d.follow(&det.Front, front*front)
+	d.follow(&det.Back, back*back)
+	d.follow(&det.Diag1, diag1*diag1)
+	d.follow(&det.Diag2, diag2*diag2)
+
+	leftRight := det.LeftRight()
+	frontBack := det.FrontBack()
+	diagonal := det.Diagonal()
+	strengths := [6]float64{leftRight, -leftRight, -diagonal, diagonal, frontBack, -frontBack}
+
 	maxIdx := 0
-	maxVal := d.logicEnv[0]
-	sum := d.logicEnv[0] + d.logicEnv[1] + d.logicEnv[2] + d.logicEnv[3] + logicEpsilon
-	for i := 1; i < 4; i++ {
-		if d.logicEnv[i] > maxVal {
-			maxVal = d.logicEnv[i]
+	for i := 1; i < len(strengths); i++ {
+		if strengths[i] > strengths[maxIdx] {
 			maxIdx = i
 		}
 	}
 
-	dominance := maxVal / sum
-	if dominance <= d.logicConfig.DominanceThreshold {
-		return lf, rf, lb, rb
+	dominance := strengths[maxIdx]
+	if dominance <= config.DominanceThreshold {
+		return in
 	}
 
-	intensity := (dominance - d.logicConfig.DominanceThreshold) / (1.0 - d.logicConfig.DominanceThreshold)
+	intensity := (dominance - config.DominanceThreshold) / (1.0 - config.DominanceThreshold)
 	if intensity < 0 {
 		intensity = 0
 	} else if intensity > 1 {
 		intensity = 1
 	}
 
-	boost := 1.0 + (d.logicConfig.MaxBoost-1.0)*intensity
-	cut := 1.0 - (1.0-d.logicConfig.MinGain)*intensity
+	boost := 1.0 + (config.MaxBoost-1.0)*intensity
+	cut := 1.0 - (1.0-config.MinGain)*intensity
 
-	gains := [4]float64{cut, cut, cut, cut}
-	gains[maxIdx] = boost
+	var gains [4]float64
+	for ch := 0; ch < 4; ch++ {
+		if logicTargets[maxIdx][ch] {
+			gains[ch] = boost
+		} else {
+			gains[ch] = cut
+		}
+	}
 
 	out := [4]float64{
-		lf * gains[0],
-		rf * gains[1],
-		lb * gains[2],
-		rb * gains[3],
+		in[0] * gains[0],
+		in[1] * gains[1],
+		in[2] * gains[2],
+		in[3] * gains[3],
 	}
 
-	preEnergy := energies[0] + energies[1] + energies[2] + energies[3]
+	preEnergy := in[0]*in[0] + in[1]*in[1] + in[2]*in[2] + in[3]*in[3]
 	postEnergy := out[0]*out[0] + out[1]*out[1] + out[2]*out[2] + out[3]*out[3]
 	if preEnergy > logicEpsilon && postEnergy > logicEpsilon {
 		scale := math.Sqrt(preEnergy / postEnergy)
@@ -90,5 +264,14 @@ func (d *SQDecoder) applyLogicSteering(lf, rf, lb, rb float64) (float64, float64
 		out[3] *= scale
 	}
 
-	return out[0], out[1], out[2], out[3]
+	return out
+}
+
+// follow advances a detector envelope with the attack/release coefficients.
+func (d *SQDecoder) follow(env *float64, energy float64) {
+	if energy > *env {
+		*env = d.attackCoeff**env + (1.0-d.attackCoeff)*energy
+	} else {
+		*env = d.releaseCoeff**env + (1.0-d.releaseCoeff)*energy
+	}
 }
@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/cwbudde/go-sq-tool/internal/decoder"
+	"github.com/cwbudde/go-sq-tool/internal/encoder"
 )
 
 func TestLogicSteering_IncreasesDominantRatio(t *testing.T) {
@@ -55,3 +56,151 @@ func dominantRatio(out [][]float64, skip int) float64 {
 	}
 	return rf / (sum + eps)
 }
+
+func TestLogicSteering_BandSplitSteersBassLess(t *testing.T) {
+	t.Parallel()
+
+	const (
+		blockSize  = 1024
+		overlap    = 512
+		n          = 40 * overlap
+		skip       = 8 * overlap
+		sampleRate = 44100
+	)
+
+	lt := make([]float64, n)
+	rt := make([]float64, n)
+	for i := 0; i < n; i++ {
+		rt[i] = 0.8 * math.Sin(2.0*math.Pi*60.0*float64(i)/sampleRate)
+	}
+
+	broadbandConfig := decoder.DefaultLogicSteeringConfig()
+	broadbandConfig.Enabled = true
+	broadbandConfig.Crossovers = nil
+	broadbandConfig.Bands = nil
+
+	broadband := decoder.NewSQDecoderWithParams(blockSize, overlap)
+	broadband.SetSampleRate(sampleRate)
+	broadband.SetLogicSteeringConfig(broadbandConfig)
+	outBroadband, err := broadband.Process([][]float64{lt, rt})
+	if err != nil {
+		t.Fatalf("broadband Process() error = %v", err)
+	}
+
+	banded := decoder.NewSQDecoderWithParams(blockSize, overlap)
+	banded.SetSampleRate(sampleRate)
+	banded.EnableLogicSteering(true)
+	outBanded, err := banded.Process([][]float64{lt, rt})
+	if err != nil {
+		t.Fatalf("banded Process() error = %v", err)
+	}
+
+	ratioBroadband := dominantRatio(outBroadband, skip)
+	ratioBanded := dominantRatio(outBanded, skip)
+	if ratioBanded >= ratioBroadband {
+		t.Fatalf("bass dominant ratio banded = %.4f, want < broadband %.4f", ratioBanded, ratioBroadband)
+	}
+}
+
+func TestLogicSteering_BandSplitIsTransparentBelowThreshold(t *testing.T) {
+	t.Parallel()
+
+	const (
+		blockSize = 1024
+		overlap   = 512
+		n         = 20 * overlap
+		skip      = 4 * overlap
+	)
+
+	lt := make([]float64, n)
+	rt := make([]float64, n)
+	for i := 0; i < n; i++ {
+		lt[i] = 0.5 * math.Sin(2.0*math.Pi*float64(i)/97.0)
+		rt[i] = 0.5 * math.Sin(2.0*math.Pi*float64(i)/97.0+0.3)
+	}
+
+	config := decoder.DefaultLogicSteeringConfig()
+	config.Enabled = true
+	for b := range config.Bands {
+		config.Bands[b].DominanceThreshold = 1.0
+	}
+
+	sqDec := decoder.NewSQDecoderWithParams(blockSize, overlap)
+	sqDec.SetSampleRate(44100)
+	sqDec.SetLogicSteeringConfig(config)
+	out, err := sqDec.Process([][]float64{lt, rt})
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+
+	// With steering inactive, the band sum is an all-pass of the matrix output,
+	// so the front channel energy must be preserved.
+	var inEnergy, outEnergy float64
+	for i := skip; i < n-overlap; i++ {
+		inEnergy += lt[i] * lt[i]
+		outEnergy += out[0][i] * out[0][i]
+	}
+	if math.Abs(outEnergy/inEnergy-1.0) > 0.02 {
+		t.Fatalf("LF energy ratio = %.4f, want ~1.0", outEnergy/inEnergy)
+	}
+}
+
+func TestLogicSteering_DetectorsPointAtSource(t *testing.T) {
+	t.Parallel()
+
+	const (
+		blockSize  = 1024
+		overlap    = 512
+		n          = 20 * overlap
+		sampleRate = 44100
+	)
+
+	tests := []struct {
+		name    string
+		channel int
+		ratio   func(decoder.DirectionDetectors) float64
+		sign    float64
+	}{
+		{name: "LF", channel: 0, ratio: decoder.DirectionDetectors.LeftRight, sign: 1},
+		{name: "RF", channel: 1, ratio: decoder.DirectionDetectors.LeftRight, sign: -1},
+		{name: "LB", channel: 2, ratio: decoder.DirectionDetectors.Diagonal, sign: -1},
+		{name: "RB", channel: 3, ratio: decoder.DirectionDetectors.Diagonal, sign: 1},
+	}
+
+	for _, tc := range tests {
+		quad := make([][]float64, 4)
+		for ch := range quad {
+			quad[ch] = make([]float64, n)
+		}
+		for i := 0; i < n; i++ {
+			quad[tc.channel][i] = 0.5 * math.Sin(2.0*math.Pi*1000.0*float64(i)/sampleRate)
+		}
+
+		stereo, err := encoder.NewSQEncoderWithParams(blockSize, overlap).Process(quad)
+		if err != nil {
+			t.Fatalf("%s: encoder Process() error = %v", tc.name, err)
+		}
+
+		config := decoder.DefaultLogicSteeringConfig()
+		config.Enabled = true
+		config.Crossovers = nil
+		config.Bands = nil
+
+		sqDec := decoder.NewSQDecoderWithParams(blockSize, overlap)
+		sqDec.SetSampleRate(sampleRate)
+		sqDec.SetLogicSteeringConfig(config)
+
+		// Stop before the zero-padded tail so the envelopes reflect the tone.
+		if _, err := sqDec.ProcessChunk(stereo); err != nil {
+			t.Fatalf("%s: ProcessChunk() error = %v", tc.name, err)
+		}
+
+		detectors := sqDec.Detectors()
+		if len(detectors) != 1 {
+			t.Fatalf("%s: len(Detectors()) = %d, want 1", tc.name, len(detectors))
+		}
+		if got := tc.sign * tc.ratio(detectors[0]); got < 0.8 {
+			t.Fatalf("%s: detector ratio = %.3f, want >= 0.8 toward source (%+v)", tc.name, got, detectors[0])
+		}
+	}
+}
@@ -0,0 +1,403 @@
+package decoder
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+
+	"github.com/cwbudde/go-sq-tool/internal/matrix"
+	"github.com/cwbudde/go-sq-tool/pkg/sqmath"
+)
+
+// Direction lookup table resolution over the Lt/Rt magnitude angle
+// atan(|Rt|/|Lt|) in [0, π/2] and the Rt-Lt phase difference in [-π, π).
+const (
+	directionTableMagnitudeSteps = 64
+	directionTablePhaseSteps     = 128
+)
+
+// makeDirectionTable maps every quantized (magnitude angle, phase
+// difference) pair to the index of the best matching candidate.
+func makeDirectionTable(candidates []directionCandidate) [][]int {
+	table := make([][]int, directionTableMagnitudeSteps+1)
+	for m := range table {
+		table[m] = make([]int, directionTablePhaseSteps)
+		for p := range table[m] {
+			covLL, covRR, covLR := directionCellCovariance(m, p)
+
+			best := 0
+			bestScore := -1.0
+			for i, c := range candidates {
+				score := c.score(covLL, covRR, covLR)
+				if score > bestScore {
+					bestScore = score
+					best = i
+				}
+			}
+			table[m][p] = best
+		}
+	}
+	return table
+}
+
+// directionCellCovariance returns the covariance of a unit-power Lt/Rt
+// pair at the centre of a direction table cell.
+func directionCellCovariance(mag, p int) (float64, float64, complex128) {
+	theta := float64(mag) / directionTableMagnitudeSteps * math.Pi / 2.0
+	phi := -math.Pi + float64(p)*2.0*math.Pi/directionTablePhaseSteps
+	l := complex(math.Cos(theta), 0)
+	r := cmplx.Rect(math.Sin(theta), phi)
+	return real(l) * real(l), math.Sin(theta) * math.Sin(theta), l * cmplx.Conj(r)
+}
+
+// lookupDirection returns the direction candidate matching the magnitude
+// ratio and phase difference of a Lt/Rt covariance.
+func (m *directionModel) lookupDirection(covLL, covRR float64, covLR complex128) directionCandidate {
+	mag, p := directionCell(covLL, covRR, covLR)
+	return m.candidates[m.table()[mag][p]]
+}
+
+// directionCell returns the direction table cell (magnitude angle index,
+// phase difference index) of a Lt/Rt covariance.
+func directionCell(covLL, covRR float64, covLR complex128) (int, int) {
+	theta := math.Atan2(math.Sqrt(covRR), math.Sqrt(covLL))
+	mag := int(math.Round(theta / (math.Pi / 2.0) * directionTableMagnitudeSteps))
+	mag = max(0, min(directionTableMagnitudeSteps, mag))
+
+	// covLR = |Lt||Rt|·e^(-iφ) for a Rt-Lt phase difference φ.
+	phi := -cmplx.Phase(covLR)
+	p := int(math.Round((phi + math.Pi) / (2.0 * math.Pi) * directionTablePhaseSteps))
+	p %= directionTablePhaseSteps
+
+	return mag, p
+}
+
+// SpectralSteeringConfig defines the per-bin steering parameters.
+type SpectralSteeringConfig struct {
+	// SmoothingTime is the time constant of the per-bin Lt/Rt statistics in seconds.
+	SmoothingTime float64
+	// CoherenceThreshold is the per-bin direction score (0.5 = diffuse,
+	// 1 = single source) above which a bin is steered.
+	CoherenceThreshold float64
+	// Strength scales the steering (0 = passive, 1 = full).
+	Strength float64
+}
+
+// DefaultSpectralSteeringConfig returns per-bin steering defaults.
+func DefaultSpectralSteeringConfig() SpectralSteeringConfig {
+	return SpectralSteeringConfig{
+		SmoothingTime:      0.03,
+		CoherenceThreshold: 0.6,
+		Strength:           1.0,
+	}
+}
+
+// spectralBin holds the smoothed Lt/Rt statistics of one STFT bin.
+type spectralBin struct {
+	covLL float64
+	covRR float64
+	covLR complex128
+}
+
+// SpectralDecoder is a frequency-domain SQ decoder. It estimates the SQ
+// direction of every STFT bin from the Lt/Rt magnitude ratio and phase
+// difference and steers each bin to its decoded position, so simultaneous
+// sources in different directions and frequency ranges each keep their
+// position.
+//
+// Process output is time aligned with the input; the streaming API delays
+// it by GetLatency samples internally and discards that delay again.
+type SpectralDecoder struct {
+	stft       *sqmath.STFT
+	sampleRate int
+	config     SpectralSteeringConfig
+	smoothing  float64
+	directions *directionModel
+	// ambiX selects first-order AmbiX output instead of LF, RF, LB, RB.
+	ambiX bool
+	// source is the matrix system of the input.
+	source matrix.Matrix
+	// target is the matrix system of the output when transcoding, nil when
+	// decoding; passive transcodes the unsteered remainder of a bin to it.
+	target  *matrix.Matrix
+	passive [2][2]complex128
+	bins    []spectralBin
+}
+
+// NewSpectralDecoder creates a spectral decoder with default parameters.
+func NewSpectralDecoder() *SpectralDecoder {
+	d, err := NewSpectralDecoderWithParams(DefaultBlockSize, DefaultOverlap)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// NewSpectralDecoderWithParams creates a spectral decoder with an FFT size
+// (power of 2) and a hop size of at most fftSize/2.
+func NewSpectralDecoderWithParams(fftSize, hop int) (*SpectralDecoder, error) {
+	d := &SpectralDecoder{
+		sampleRate: 44100,
+		config:     DefaultSpectralSteeringConfig(),
+		directions: directionModelFor(matrix.SQ),
+		source:     matrix.SQ,
+	}
+
+	stft, err := sqmath.NewSTFT(fftSize, hop, 2, 4, d.steerBins)
+	if err != nil {
+		return nil, fmt.Errorf("spectral decoder: %w", err)
+	}
+	d.stft = stft
+	d.bins = make([]spectralBin, fftSize/2+1)
+
+	d.updateSmoothing()
+	d.Reset()
+	return d, nil
+}
+
+// SetSampleRate sets the sample rate used for the per-bin smoothing.
+func (d *SpectralDecoder) SetSampleRate(sampleRate int) {
+	if sampleRate <= 0 {
+		return
+	}
+	d.sampleRate = sampleRate
+	d.updateSmoothing()
+}
+
+// SetMatrix selects the matrix system of the input (SQ by default).
+func (d *SpectralDecoder) SetMatrix(m matrix.Matrix) {
+	d.directions = directionModelFor(m)
+	d.source = m
+	if d.target != nil {
+		d.passive = m.Transcode(*d.target)
+	}
+}
+
+// SetAmbiXOutput selects first-order AmbiX output (W, Y, Z, X; ACN/SN3D)
+// instead of the four speakers. The steered part of every bin is encoded as
+// a plane wave from its estimated direction; the unsteered remainder is the
+// passive decode, encoded from the speaker directions. Enabling it ends
+// transcoding.
+func (d *SpectralDecoder) SetAmbiXOutput(enabled bool) {
+	d.ambiX = enabled
+	if enabled {
+		d.target = nil
+	}
+}
+
+// SetTranscodeTarget selects transcoding: the output is the (Lt, Rt) of
+// the matrix system target instead of the four speakers. The steered part
+// of every bin is re-encoded with target from its estimated direction, so
+// the direction estimate survives without a bounce through four speakers;
+// the unsteered remainder is transcoded passively (see
+// matrix.Matrix.Transcode). It replaces AmbiX output.
+func (d *SpectralDecoder) SetTranscodeTarget(target matrix.Matrix) {
+	d.target = &target
+	d.passive = d.source.Transcode(target)
+	d.ambiX = false
+}
+
+// outputs returns the number of output channels.
+func (d *SpectralDecoder) outputs() int {
+	if d.target != nil {
+		return 2
+	}
+	return 4
+}
+
+// SetConfig updates the per-bin steering parameters.
+func (d *SpectralDecoder) SetConfig(config SpectralSteeringConfig) {
+	d.config = config
+	d.updateSmoothing()
+}
+
+func (d *SpectralDecoder) updateSmoothing() {
+	// The statistics are updated once per hop.
+	d.smoothing = 0
+	if d.config.SmoothingTime > 0 {
+		d.smoothing = math.Exp(-float64(d.stft.Hop()) / (d.config.SmoothingTime * float64(d.sampleRate)))
+	}
+}
+
+// Process decodes a complete stereo SQ signal to 4 time-aligned channels.
+// Input: [2][numSamples] - LT, RT
+// Output: [4][numSamples] - LF, RF, LB, RB (W, Y, Z, X with AmbiX output),
+// or [2][numSamples] - LT, RT of the target when transcoding
+// Any streaming state is discarded.
+func (d *SpectralDecoder) Process(input [][]float64) ([][]float64, error) {
+	d.Reset()
+	output, err := d.ProcessChunk(input)
+	if err != nil {
+		return nil, err
+	}
+
+	tail := d.Flush()
+	for ch := range output {
+		output[ch] = append(output[ch], tail[ch]...)
+	}
+	return output, nil
+}
+
+// ProcessChunk decodes an arbitrary-sized chunk of a continuous stream.
+// Input: [2][chunkSize] - LT, RT
+// Output: [4][n] - LF, RF, LB, RB for every hop that became complete
+// ([2][n] when transcoding).
+//
+// The concatenation of all ProcessChunk outputs followed by Flush is
+// identical to a single Process call on the whole stream.
+func (d *SpectralDecoder) ProcessChunk(input [][]float64) ([][]float64, error) {
+	if len(input) != 2 {
+		return nil, fmt.Errorf("input must have 2 channels, got %d", len(input))
+	}
+
+	numSamples := len(input[0])
+	if len(input[1]) != numSamples {
+		return nil, fmt.Errorf("input channels must have same length")
+	}
+
+	output := make([][]float64, d.outputs())
+	d.stft.ProcessChunk(output, input)
+	return output, nil
+}
+
+// Flush zero-pads and decodes the samples still buffered by ProcessChunk.
+// Output: [4][n] - the remaining LF, RF, LB, RB samples of the stream
+// ([2][n] when transcoding).
+// The decoder is ready for a new stream afterwards.
+func (d *SpectralDecoder) Flush() [][]float64 {
+	output := make([][]float64, d.outputs())
+	d.stft.Flush(output)
+	d.Reset()
+
+	return output
+}
+
+// Reset discards buffered input and output and the per-bin statistics.
+func (d *SpectralDecoder) Reset() {
+	d.stft.Reset()
+	for k := range d.bins {
+		d.bins[k] = spectralBin{}
+	}
+}
+
+// steerBins decodes every bin of one STFT frame with its own variable
+// matrix.
+func (d *SpectralDecoder) steerBins(in, out [][]complex128) {
+	a := d.smoothing
+	for k := range d.bins {
+		// For positive frequencies the Hilbert transform is a multiplication
+		// by -i, so conj(X) follows the time-domain coefficient convention
+		// of wavematch.go.
+		l := cmplx.Conj(in[0][k])
+		r := cmplx.Conj(in[1][k])
+
+		bin := &d.bins[k]
+		bin.covLL = a*bin.covLL + (1.0-a)*(real(l)*real(l)+imag(l)*imag(l))
+		bin.covRR = a*bin.covRR + (1.0-a)*(real(r)*real(r)+imag(r)*imag(r))
+		bin.covLR = complex(a, 0)*bin.covLR + complex(1.0-a, 0)*l*cmplx.Conj(r)
+
+		if d.target != nil {
+			d.transcodeBin(out, k, l, r, bin)
+			continue
+		}
+		if d.ambiX {
+			d.encodeAmbiXBin(out, k, l, r, bin)
+			continue
+		}
+
+		rows := d.directions.decode
+		if bin.covLL+bin.covRR > logicEpsilon {
+			candidate := d.directions.lookupDirection(bin.covLL, bin.covRR, bin.covLR)
+			score := candidate.score(bin.covLL, bin.covRR, bin.covLR)
+			rows = candidate.matchedRows(d.directions.decode, coherenceWeight(score, d.config.CoherenceThreshold, d.config.Strength))
+		}
+
+		for ch := 0; ch < 4; ch++ {
+			out[ch][k] = outputBin(rows[ch][0]*l + rows[ch][1]*r)
+		}
+	}
+}
+
+// encodeAmbiXBin encodes bin k into AmbiX: the source estimate of the bin's
+// direction, weighted by its coherence, as a plane wave from that
+// direction, plus the passive decode without that source's share from the
+// speaker directions.
+func (d *SpectralDecoder) encodeAmbiXBin(out [][]complex128, k int, l, r complex128, bin *spectralBin) {
+	decode := d.directions.decode
+	var speakers [4]complex128
+	for ch := 0; ch < 4; ch++ {
+		speakers[ch] = decode[ch][0]*l + decode[ch][1]*r
+	}
+
+	var foa [4]complex128
+	if bin.covLL+bin.covRR > logicEpsilon {
+		candidate := d.directions.lookupDirection(bin.covLL, bin.covRR, bin.covLR)
+		score := candidate.score(bin.covLL, bin.covRR, bin.covLR)
+		weight := coherenceWeight(score, d.config.CoherenceThreshold, d.config.Strength)
+		if weight > 0 {
+			e := candidate.vector
+			source := complex(weight/candidate.norm, 0) * (cmplx.Conj(e[0])*l + cmplx.Conj(e[1])*r)
+			for ch := 0; ch < 4; ch++ {
+				speakers[ch] -= (decode[ch][0]*e[0] + decode[ch][1]*e[1]) * source
+			}
+			for j, g := range ambiXGains(candidate.azimuth) {
+				foa[j] += complex(g, 0) * source
+			}
+		}
+	}
+	for ch, azimuth := range matrix.SpeakerAzimuths {
+		for j, g := range ambiXGains(azimuth) {
+			foa[j] += complex(g, 0) * speakers[ch]
+		}
+	}
+
+	for j := range foa {
+		out[j][k] = outputBin(foa[j])
+	}
+}
+
+// transcodeBin re-encodes bin k with the target matrix: the source estimate
+// of the bin's direction, weighted by its coherence, encoded with the target
+// at that direction, plus the passively transcoded remainder.
+func (d *SpectralDecoder) transcodeBin(out [][]complex128, k int, l, r complex128, bin *spectralBin) {
+	var encoded [2]complex128
+	if bin.covLL+bin.covRR > logicEpsilon {
+		candidate := d.directions.lookupDirection(bin.covLL, bin.covRR, bin.covLR)
+		score := candidate.score(bin.covLL, bin.covRR, bin.covLR)
+		weight := coherenceWeight(score, d.config.CoherenceThreshold, d.config.Strength)
+		if weight > 0 {
+			e := candidate.vector
+			source := complex(weight/candidate.norm, 0) * (cmplx.Conj(e[0])*l + cmplx.Conj(e[1])*r)
+			l -= e[0] * source
+			r -= e[1] * source
+			t := d.target.EncodeDirection(candidate.azimuth)
+			encoded[0] = t[0] * source
+			encoded[1] = t[1] * source
+		}
+	}
+	for i := range encoded {
+		encoded[i] += d.passive[i][0]*l + d.passive[i][1]*r
+		out[i][k] = outputBin(encoded[i])
+	}
+}
+
+// outputBin converts an output bin from the coefficient convention back to
+// a spectrum value.
+func outputBin(v complex128) complex128 {
+	return cmplx.Conj(v)
+}
+
+// GetLatency returns the streaming latency in samples.
+func (d *SpectralDecoder) GetLatency() int {
+	return d.stft.Latency()
+}
+
+// GetInfo returns information about the decoder configuration.
+func (d *SpectralDecoder) GetInfo() string {
+	return fmt.Sprintf("SQ Spectral Decoder (per-bin steering)\n"+
+		"FFT Size: %d samples\n"+
+		"Hop: %d samples\n"+
+		"Latency: %d samples (%.2f ms @ 44.1kHz)",
+		d.stft.FFTSize(), d.stft.Hop(), d.GetLatency(),
+		float64(d.GetLatency())/44100.0*1000.0)
+}
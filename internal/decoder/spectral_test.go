@@ -0,0 +1,227 @@
+package decoder_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/cwbudde/go-sq-tool/internal/decoder"
+	"github.com/cwbudde/go-sq-tool/internal/encoder"
+	"github.com/cwbudde/go-sq-tool/internal/matrix"
+)
+
+// bandEnergy returns the energy of x at freq Hz (single-bin DFT).
+func bandEnergy(x []float64, freq, sampleRate float64) float64 {
+	var re, im float64
+	for i, v := range x {
+		phase := 2.0 * math.Pi * freq * float64(i) / sampleRate
+		re += v * math.Cos(phase)
+		im += v * math.Sin(phase)
+	}
+	return re*re + im*im
+}
+
+func TestSpectralDecoder_SeparatesSimultaneousSources(t *testing.T) {
+	t.Parallel()
+
+	const (
+		sampleRate = 44100.0
+		n          = 1 << 15
+		skip       = 4096
+		freqLF     = 500.0
+		freqRB     = 3000.0
+	)
+
+	quad := make([][]float64, 4)
+	for ch := range quad {
+		quad[ch] = make([]float64, n)
+	}
+	for i := 0; i < n; i++ {
+		quad[0][i] = 0.4 * math.Sin(2.0*math.Pi*freqLF*float64(i)/sampleRate)
+		quad[3][i] = 0.4 * math.Sin(2.0*math.Pi*freqRB*float64(i)/sampleRate)
+	}
+
+	encoded, err := encoder.NewSQEncoder().Process(quad)
+	if err != nil {
+		t.Fatalf("encoder.Process() error = %v", err)
+	}
+
+	passive, err := decoder.NewSQDecoder().Process(encoded)
+	if err != nil {
+		t.Fatalf("SQDecoder.Process() error = %v", err)
+	}
+	spectral, err := decoder.NewSpectralDecoder().Process(encoded)
+	if err != nil {
+		t.Fatalf("SpectralDecoder.Process() error = %v", err)
+	}
+	if len(spectral[0]) != n {
+		t.Fatalf("len(out) = %d, want %d", len(spectral[0]), n)
+	}
+
+	// Separation of each source against its leak into the other source's
+	// channel and the rear/front channel it leaks into passively.
+	separation := func(out [][]float64, freq float64, target, leak int) float64 {
+		wanted := bandEnergy(out[target][skip:n-skip], freq, sampleRate)
+		leaked := bandEnergy(out[leak][skip:n-skip], freq, sampleRate)
+		return 10 * math.Log10(wanted/(leaked+1e-30))
+	}
+
+	for _, tc := range []struct {
+		freq         float64
+		target, leak int
+	}{
+		{freq: freqLF, target: 0, leak: 2}, // LF leaks into LB passively
+		{freq: freqRB, target: 3, leak: 1}, // RB leaks into RF passively
+	} {
+		passiveSep := separation(passive, tc.freq, tc.target, tc.leak)
+		spectralSep := separation(spectral, tc.freq, tc.target, tc.leak)
+		if spectralSep < passiveSep+15 {
+			t.Fatalf("%.0f Hz: spectral separation %.1f dB, passive %.1f dB; want >= 15 dB improvement",
+				tc.freq, spectralSep, passiveSep)
+		}
+	}
+}
+
+func TestSpectralDecoder_ZeroStrengthIsTransparent(t *testing.T) {
+	t.Parallel()
+
+	const n = 8192
+
+	lt := make([]float64, n)
+	rt := make([]float64, n)
+	for i := 0; i < n; i++ {
+		lt[i] = 0.5 * math.Sin(2.0*math.Pi*float64(i)/97.0)
+		rt[i] = 0.4 * math.Cos(2.0*math.Pi*float64(i)/61.0)
+	}
+
+	d := decoder.NewSpectralDecoder()
+	config := decoder.DefaultSpectralSteeringConfig()
+	config.Strength = 0
+	d.SetConfig(config)
+
+	out, err := d.Process([][]float64{lt, rt})
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+
+	// Without steering the fronts are Lt and Rt, time aligned.
+	for i := 0; i < n; i++ {
+		if math.Abs(out[0][i]-lt[i]) > 1e-9 || math.Abs(out[1][i]-rt[i]) > 1e-9 {
+			t.Fatalf("sample %d: fronts = %.9f/%.9f, want %.9f/%.9f", i, out[0][i], out[1][i], lt[i], rt[i])
+		}
+	}
+}
+
+func TestSpectralDecoder_ProcessChunk_MatchesProcess(t *testing.T) {
+	t.Parallel()
+
+	const n = 9*512 + 137
+
+	lt := make([]float64, n)
+	rt := make([]float64, n)
+	for i := 0; i < n; i++ {
+		lt[i] = 0.5 * math.Sin(2.0*math.Pi*float64(i)/97.0)
+		rt[i] = 0.4 * math.Sin(2.0*math.Pi*float64(i)/61.0+1.0)
+	}
+
+	want, err := decoder.NewSpectralDecoder().Process([][]float64{lt, rt})
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+
+	stream := decoder.NewSpectralDecoder()
+	got := make([][]float64, 4)
+	chunkSizes := []int{1, 300, 1023, 2, 700, 4096, 17}
+	for pos, c := 0, 0; pos < n; c++ {
+		end := min(pos+chunkSizes[c%len(chunkSizes)], n)
+		out, err := stream.ProcessChunk([][]float64{lt[pos:end], rt[pos:end]})
+		if err != nil {
+			t.Fatalf("ProcessChunk() error = %v", err)
+		}
+		for ch := range got {
+			got[ch] = append(got[ch], out[ch]...)
+		}
+		pos = end
+	}
+	tail := stream.Flush()
+	for ch := range got {
+		got[ch] = append(got[ch], tail[ch]...)
+	}
+
+	for ch := 0; ch < 4; ch++ {
+		if len(got[ch]) != n {
+			t.Fatalf("len(out[%d]) = %d, want %d", ch, len(got[ch]), n)
+		}
+		for i := 0; i < n; i++ {
+			if got[ch][i] != want[ch][i] {
+				t.Fatalf("out[%d][%d] = %.15f, want %.15f", ch, i, got[ch][i], want[ch][i])
+			}
+		}
+	}
+}
+
+func TestSpectralDecoder_TranscodeKeepsDirection(t *testing.T) {
+	t.Parallel()
+
+	const (
+		sampleRate = 44100.0
+		n          = 1 << 15
+		skip       = 4096
+	)
+
+	for _, tc := range []struct {
+		from, to matrix.Matrix
+	}{
+		{matrix.SQ, matrix.QS},
+		{matrix.QS, matrix.SQ},
+		{matrix.SQ, matrix.SQ},
+	} {
+		for azimuth := -180.0; azimuth < 180; azimuth += 30 {
+			gains := matrix.PanGains(azimuth)
+			quad := make([][]float64, 4)
+			for ch := range quad {
+				quad[ch] = make([]float64, n)
+				for i := range quad[ch] {
+					quad[ch][i] = 0.4 * gains[ch] * math.Sin(2.0*math.Pi*1000.0*float64(i)/sampleRate)
+				}
+			}
+			from := encoder.NewSQEncoder()
+			from.SetMatrix(tc.from)
+			encoded, err := from.Process(quad)
+			if err != nil {
+				t.Fatalf("encoder.Process() error = %v", err)
+			}
+			to := encoder.NewSQEncoder()
+			to.SetMatrix(tc.to)
+			want, err := to.Process(quad)
+			if err != nil {
+				t.Fatalf("encoder.Process() error = %v", err)
+			}
+
+			d := decoder.NewSpectralDecoder()
+			d.SetSampleRate(sampleRate)
+			d.SetMatrix(tc.from)
+			d.SetTranscodeTarget(tc.to)
+			got, err := d.Process(encoded)
+			if err != nil {
+				t.Fatalf("Process() error = %v", err)
+			}
+			if len(got) != 2 {
+				t.Fatalf("transcoded channels = %d, want 2", len(got))
+			}
+
+			// The transcoded stereo matches encoding the quad source with
+			// the target directly.
+			var errEnergy, energy float64
+			for ch := range got {
+				for i := skip; i < n-skip; i++ {
+					diff := got[ch][i] - want[ch][i]
+					errEnergy += diff * diff
+					energy += want[ch][i] * want[ch][i]
+				}
+			}
+			if ratio := math.Sqrt(errEnergy / energy); ratio > 0.05 {
+				t.Errorf("%s -> %s at %.0f°: relative error %.3f", tc.from.Name, tc.to.Name, azimuth, ratio)
+			}
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package decoder
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+
+	"github.com/cwbudde/go-sq-tool/pkg/sqmath"
+)
+
+// UpmixConfig defines the stereo-to-quad upmix parameters.
+type UpmixConfig struct {
+	// SmoothingTime is the time constant of the per-bin correlation
+	// estimate in seconds.
+	SmoothingTime float64
+	// Width scales the side signal of the fronts (0 = mono, 1 = original,
+	// 2 = twice as wide).
+	Width float64
+	// RearLevel is the gain of the ambience in the rears in dB.
+	RearLevel float64
+	// RearDelay delays the rears in seconds so that the precedence effect
+	// keeps the image in front and the rears stay decorrelated from the
+	// fronts.
+	RearDelay float64
+}
+
+// DefaultUpmixConfig returns upmix defaults.
+func DefaultUpmixConfig() UpmixConfig {
+	return UpmixConfig{
+		SmoothingTime: 0.05,
+		Width:         1.0,
+		RearLevel:     0.0,
+		RearDelay:     0.012,
+	}
+}
+
+// minUpmixBandBins is the minimum width of an upmix analysis band in bins.
+const minUpmixBandBins = 4
+
+// Upmixer converts plain (non-matrixed) stereo to quad. For every frequency
+// band of the STFT it estimates the power of the uncorrelated ambience,
+// assuming equal ambience power in both channels, and splits each channel
+// power-complementarily: the direct part stays in the front, the ambience
+// goes delayed to the rear on the same side.
+//
+// Process output is time aligned with the input (apart from RearDelay);
+// the streaming API delays it by GetLatency samples internally and discards
+// that delay again.
+type Upmixer struct {
+	stft       *sqmath.STFT
+	sampleRate int
+	config     UpmixConfig
+	smoothing  float64
+	rearGain   float64
+	bins       []spectralBin
+	// bandEdges are the first bins of the analysis bands, followed by the
+	// bin count.
+	bandEdges []int
+
+	// rearDelay holds the delay lines of LB and RB.
+	rearDelay    [2][]float64
+	rearDelayPos int
+}
+
+// NewUpmixer creates an upmixer with default parameters.
+func NewUpmixer() *Upmixer {
+	u, err := NewUpmixerWithParams(DefaultBlockSize, DefaultOverlap)
+	if err != nil {
+		panic(err)
+	}
+	return u
+}
+
+// NewUpmixerWithParams creates an upmixer with an FFT size (power of 2) and
+// a hop size of at most fftSize/2.
+func NewUpmixerWithParams(fftSize, hop int) (*Upmixer, error) {
+	u := &Upmixer{
+		sampleRate: 44100,
+		config:     DefaultUpmixConfig(),
+	}
+
+	stft, err := sqmath.NewSTFT(fftSize, hop, 2, 4, u.splitBins)
+	if err != nil {
+		return nil, fmt.Errorf("upmixer: %w", err)
+	}
+	u.stft = stft
+	u.bins = make([]spectralBin, fftSize/2+1)
+
+	// Analysis bands of about an eighth of their frequency, at least
+	// minUpmixBandBins wide.
+	for k := 0; k <= fftSize/2; k += max(minUpmixBandBins, k/8) {
+		u.bandEdges = append(u.bandEdges, k)
+	}
+	u.bandEdges = append(u.bandEdges, fftSize/2+1)
+
+	u.updateParams()
+	return u, nil
+}
+
+// SetSampleRate sets the sample rate used for the smoothing and rear delay.
+func (u *Upmixer) SetSampleRate(sampleRate int) {
+	if sampleRate <= 0 {
+		return
+	}
+	u.sampleRate = sampleRate
+	u.updateParams()
+}
+
+// SetConfig updates the upmix parameters and resets the stream.
+func (u *Upmixer) SetConfig(config UpmixConfig) {
+	u.config = config
+	u.updateParams()
+}
+
+func (u *Upmixer) updateParams() {
+	// The statistics are updated once per hop.
+	u.smoothing = 0
+	if u.config.SmoothingTime > 0 {
+		u.smoothing = math.Exp(-float64(u.stft.Hop()) / (u.config.SmoothingTime * float64(u.sampleRate)))
+	}
+	u.rearGain = math.Pow(10, u.config.RearLevel/20.0)
+
+	delay := max(0, int(math.Round(u.config.RearDelay*float64(u.sampleRate))))
+	for ch := range u.rearDelay {
+		u.rearDelay[ch] = make([]float64, delay)
+	}
+	u.Reset()
+}
+
+// Process upmixes a complete stereo signal to 4 time-aligned channels.
+// Input: [2][numSamples] - L, R
+// Output: [4][numSamples] - LF, RF, LB, RB
+// Any streaming state is discarded.
+func (u *Upmixer) Process(input [][]float64) ([][]float64, error) {
+	u.Reset()
+	output, err := u.ProcessChunk(input)
+	if err != nil {
+		return nil, err
+	}
+
+	tail := u.Flush()
+	for ch := range output {
+		output[ch] = append(output[ch], tail[ch]...)
+	}
+	return output, nil
+}
+
+// ProcessChunk upmixes an arbitrary-sized chunk of a continuous stream.
+// Input: [2][chunkSize] - L, R
+// Output: [4][n] - LF, RF, LB, RB for every hop that became complete.
+//
+// The concatenation of all ProcessChunk outputs followed by Flush is
+// identical to a single Process call on the whole stream.
+func (u *Upmixer) ProcessChunk(input [][]float64) ([][]float64, error) {
+	if len(input) != 2 {
+		return nil, fmt.Errorf("input must have 2 channels, got %d", len(input))
+	}
+
+	numSamples := len(input[0])
+	if len(input[1]) != numSamples {
+		return nil, fmt.Errorf("input channels must have same length")
+	}
+
+	output := make([][]float64, 4)
+	u.stft.ProcessChunk(output, input)
+	u.delayRears(output)
+	return output, nil
+}
+
+// Flush zero-pads and upmixes the samples still buffered by ProcessChunk.
+// Output: [4][n] - the remaining LF, RF, LB, RB samples of the stream.
+// The upmixer is ready for a new stream afterwards.
+func (u *Upmixer) Flush() [][]float64 {
+	output := make([][]float64, 4)
+	u.stft.Flush(output)
+	u.delayRears(output)
+	u.Reset()
+
+	return output
+}
+
+// Reset discards buffered input and output, the per-bin statistics and the
+// rear delay lines.
+func (u *Upmixer) Reset() {
+	u.stft.Reset()
+	for ch := range u.rearDelay {
+		for i := range u.rearDelay[ch] {
+			u.rearDelay[ch][i] = 0
+		}
+	}
+	for k := range u.bins {
+		u.bins[k] = spectralBin{}
+	}
+	u.rearDelayPos = 0
+}
+
+// splitBins splits every bin of one STFT frame into front direct sound and
+// rear ambience.
+func (u *Upmixer) splitBins(in, out [][]complex128) {
+	a := u.smoothing
+	for k := range u.bins {
+		l := in[0][k]
+		r := in[1][k]
+
+		bin := &u.bins[k]
+		bin.covLL = a*bin.covLL + (1.0-a)*(real(l)*real(l)+imag(l)*imag(l))
+		bin.covRR = a*bin.covRR + (1.0-a)*(real(r)*real(r)+imag(r)*imag(r))
+		bin.covLR = complex(a, 0)*bin.covLR + complex(1.0-a, 0)*l*cmplx.Conj(r)
+	}
+
+	for b := 0; b+1 < len(u.bandEdges); b++ {
+		var band spectralBin
+		for k := u.bandEdges[b]; k < u.bandEdges[b+1]; k++ {
+			band.covLL += u.bins[k].covLL
+			band.covRR += u.bins[k].covRR
+			band.covLR += u.bins[k].covLR
+		}
+
+		// With L = S + Al and R = g·S + Ar for uncorrelated ambience of
+		// equal power, the ambience power is the smaller eigenvalue of the
+		// covariance matrix.
+		diff := band.covLL - band.covRR
+		cross := real(band.covLR)*real(band.covLR) + imag(band.covLR)*imag(band.covLR)
+		ambience := 0.5 * (band.covLL + band.covRR - math.Sqrt(diff*diff+4.0*cross))
+
+		gl, al := ambienceSplit(ambience, band.covLL)
+		gr, ar := ambienceSplit(ambience, band.covRR)
+		for k := u.bandEdges[b]; k < u.bandEdges[b+1]; k++ {
+			l := in[0][k]
+			r := in[1][k]
+
+			directL := complex(gl, 0) * l
+			directR := complex(gr, 0) * r
+			mid := 0.5 * (directL + directR)
+			side := complex(0.5*u.config.Width, 0) * (directL - directR)
+
+			out[0][k] = mid + side
+			out[1][k] = mid - side
+			out[2][k] = complex(u.rearGain*al, 0) * l
+			out[3][k] = complex(u.rearGain*ar, 0) * r
+		}
+	}
+}
+
+// ambienceSplit returns the power-complementary direct and ambience gains
+// of a channel of power total containing ambience of power ambience.
+func ambienceSplit(ambience, total float64) (float64, float64) {
+	if total <= logicEpsilon {
+		return 1, 0
+	}
+	share := max(0, min(1, ambience/total))
+	return math.Sqrt(1.0 - share), math.Sqrt(share)
+}
+
+// delayRears runs LB and RB in output through the rear delay lines.
+func (u *Upmixer) delayRears(output [][]float64) {
+	delay := len(u.rearDelay[0])
+	if delay == 0 {
+		return
+	}
+	for i := range output[2] {
+		for j, line := range u.rearDelay {
+			samples := output[2+j]
+			samples[i], line[u.rearDelayPos] = line[u.rearDelayPos], samples[i]
+		}
+		u.rearDelayPos = (u.rearDelayPos + 1) % delay
+	}
+}
+
+// GetLatency returns the streaming latency in samples.
+func (u *Upmixer) GetLatency() int {
+	return u.stft.Latency()
+}
+
+// GetInfo returns information about the upmixer configuration.
+func (u *Upmixer) GetInfo() string {
+	return fmt.Sprintf("Stereo Upmixer (per-bin ambience extraction)\n"+
+		"FFT Size: %d samples\n"+
+		"Hop: %d samples\n"+
+		"Latency: %d samples (%.2f ms @ 44.1kHz)",
+		u.stft.FFTSize(), u.stft.Hop(), u.GetLatency(),
+		float64(u.GetLatency())/44100.0*1000.0)
+}
@@ -0,0 +1,170 @@
+package decoder_test
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/cwbudde/go-sq-tool/internal/decoder"
+)
+
+// energies returns the energy of every channel from skip to len-skip.
+func energies(x [][]float64, skip int) []float64 {
+	e := make([]float64, len(x))
+	for ch := range x {
+		for _, v := range x[ch][skip : len(x[ch])-skip] {
+			e[ch] += v * v
+		}
+	}
+	return e
+}
+
+func TestUpmixer_KeepsCorrelatedSoundInFront(t *testing.T) {
+	t.Parallel()
+
+	const n = 1 << 15
+	rng := rand.New(rand.NewPCG(1, 2))
+	l := make([]float64, n)
+	r := make([]float64, n)
+	for i := range l {
+		// A source panned left of centre.
+		s := rng.NormFloat64() * 0.1
+		l[i] = 0.8 * s
+		r[i] = 0.4 * s
+	}
+
+	out, err := decoder.NewUpmixer().Process([][]float64{l, r})
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	e := energies(out, 4096)
+	if rear := (e[2] + e[3]) / (e[0] + e[1]); rear > 0.01 {
+		t.Errorf("rear/front energy = %.4f, want < 0.01", rear)
+	}
+	if ratio := e[0] / e[1]; math.Abs(ratio-4) > 0.1 {
+		t.Errorf("LF/RF energy = %.3f, want 4", ratio)
+	}
+}
+
+func TestUpmixer_SendsUncorrelatedSoundToRears(t *testing.T) {
+	t.Parallel()
+
+	const n = 1 << 15
+	rng := rand.New(rand.NewPCG(3, 4))
+	l := make([]float64, n)
+	r := make([]float64, n)
+	for i := range l {
+		l[i] = rng.NormFloat64() * 0.1
+		r[i] = rng.NormFloat64() * 0.1
+	}
+
+	out, err := decoder.NewUpmixer().Process([][]float64{l, r})
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	e := energies(out, 4096)
+	if rear := (e[2] + e[3]) / (e[0] + e[1] + e[2] + e[3]); rear < 0.7 {
+		t.Errorf("rear share of the energy = %.3f, want > 0.7", rear)
+	}
+	// Each side keeps its own ambience.
+	var lbL, lbR float64
+	delay := int(math.Round(decoder.DefaultUpmixConfig().RearDelay * 44100))
+	for i := 4096; i < n-4096; i++ {
+		lbL += out[2][i+delay] * l[i]
+		lbR += out[2][i+delay] * r[i]
+	}
+	if math.Abs(lbR) > 0.1*math.Abs(lbL) {
+		t.Errorf("LB correlates with R (%.3f) as much as with L (%.3f)", lbR, lbL)
+	}
+}
+
+func TestUpmixer_WidthAndRearLevel(t *testing.T) {
+	t.Parallel()
+
+	const n = 1 << 14
+	rng := rand.New(rand.NewPCG(5, 6))
+	l := make([]float64, n)
+	r := make([]float64, n)
+	for i := range l {
+		s := rng.NormFloat64() * 0.1
+		l[i] = s + 0.05*rng.NormFloat64()
+		r[i] = 0.5*s + 0.05*rng.NormFloat64()
+	}
+
+	reference, err := decoder.NewUpmixer().Process([][]float64{l, r})
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+
+	u := decoder.NewUpmixer()
+	config := decoder.DefaultUpmixConfig()
+	config.Width = 0
+	config.RearLevel = -6
+	u.SetConfig(config)
+	out, err := u.Process([][]float64{l, r})
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+
+	for i := range out[0] {
+		if math.Abs(out[0][i]-out[1][i]) > 1e-12 {
+			t.Fatalf("sample %d: width 0 gives LF %.6f != RF %.6f", i, out[0][i], out[1][i])
+		}
+	}
+	gain := math.Pow(10, -6.0/20)
+	for ch := 2; ch < 4; ch++ {
+		for i := range out[ch] {
+			if math.Abs(out[ch][i]-gain*reference[ch][i]) > 1e-12 {
+				t.Fatalf("rear %d sample %d = %.6f, want %.6f", ch, i, out[ch][i], gain*reference[ch][i])
+			}
+		}
+	}
+}
+
+func TestUpmixer_ProcessChunk_MatchesProcess(t *testing.T) {
+	t.Parallel()
+
+	const n = 9*512 + 137
+
+	l := make([]float64, n)
+	r := make([]float64, n)
+	for i := 0; i < n; i++ {
+		l[i] = 0.5 * math.Sin(2.0*math.Pi*float64(i)/97.0)
+		r[i] = 0.4 * math.Sin(2.0*math.Pi*float64(i)/61.0+1.0)
+	}
+
+	want, err := decoder.NewUpmixer().Process([][]float64{l, r})
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+
+	stream := decoder.NewUpmixer()
+	got := make([][]float64, 4)
+	chunkSizes := []int{1, 300, 1023, 2, 700, 4096, 17}
+	for pos, c := 0, 0; pos < n; c++ {
+		end := min(pos+chunkSizes[c%len(chunkSizes)], n)
+		out, err := stream.ProcessChunk([][]float64{l[pos:end], r[pos:end]})
+		if err != nil {
+			t.Fatalf("ProcessChunk() error = %v", err)
+		}
+		for ch := range got {
+			got[ch] = append(got[ch], out[ch]...)
+		}
+		pos = end
+	}
+	tail := stream.Flush()
+	for ch := range got {
+		got[ch] = append(got[ch], tail[ch]...)
+	}
+
+	for ch := 0; ch < 4; ch++ {
+		if len(got[ch]) != n {
+			t.Fatalf("len(out[%d]) = %d, want %d", ch, len(got[ch]), n)
+		}
+		for i := 0; i < n; i++ {
+			if got[ch][i] != want[ch][i] {
+				t.Fatalf("out[%d][%d] = %.15f, want %.15f", ch, i, got[ch][i], want[ch][i])
+			}
+		}
+	}
+}
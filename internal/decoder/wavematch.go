@@ -0,0 +1,243 @@
+package decoder
+
+import (
+	"math"
+	"math/cmplx"
+	"sync"
+
+	"github.com/cwbudde/go-sq-tool/internal/matrix"
+)
+
+// Coefficients in this file follow the convention of the matrix package:
+// a complex c acts on x as real(c)*x + imag(c)*H(x), so the matrices become
+// plain complex 2-vectors over (Lt, Rt).
+
+// waveMatchInterval is the number of samples between decode matrix updates.
+const waveMatchInterval = 32
+
+// directionResolution is the azimuth step of the direction search in degrees.
+const directionResolution = 1.0
+
+// WaveMatchingConfig defines the variable-matrix (wave-matching) decoder parameters.
+type WaveMatchingConfig struct {
+	Enabled bool
+	// SmoothingTime is the time constant of the direction detector in seconds.
+	SmoothingTime float64
+	// CoherenceThreshold is the detector coherence (0.5 = diffuse, 1 = single
+	// source) above which the matrix starts to adapt.
+	CoherenceThreshold float64
+	// Strength scales the crosstalk cancellation (0 = passive, 1 = full).
+	Strength float64
+}
+
+// DefaultWaveMatchingConfig returns wave-matching defaults.
+func DefaultWaveMatchingConfig() WaveMatchingConfig {
+	return WaveMatchingConfig{
+		Enabled:            false,
+		SmoothingTime:      0.02,
+		CoherenceThreshold: 0.6,
+		Strength:           1.0,
+	}
+}
+
+// waveMatchState holds the direction detector and current decode matrix.
+type waveMatchState struct {
+	covLL     float64
+	covRR     float64
+	covLR     complex128
+	azimuth   float64
+	coherence float64
+	rows      [4][2]complex128
+	countdown int
+}
+
+// directionCandidate is a precomputed encoding for one azimuth.
+type directionCandidate struct {
+	azimuth float64
+	vector  [2]complex128
+	norm    float64
+	gains   [4]float64
+}
+
+// directionModel holds the direction search data of one matrix.
+type directionModel struct {
+	decode     [4][2]complex128
+	candidates []directionCandidate
+	// table is the spectral direction lookup table, built on first use.
+	table func() [][]int
+	// likelihood is the format identification likelihood table, built on
+	// first use.
+	likelihood func() [][]float64
+}
+
+// directionModels caches a *directionModel per matrix name.
+var directionModels sync.Map
+
+// newDirectionModel creates a direction model with lazily built tables.
+func newDirectionModel(decode [4][2]complex128, candidates []directionCandidate) *directionModel {
+	model := &directionModel{
+		decode:     decode,
+		candidates: candidates,
+	}
+	model.table = sync.OnceValue(func() [][]int {
+		return makeDirectionTable(model.candidates)
+	})
+	model.likelihood = sync.OnceValue(func() [][]float64 {
+		return makeLikelihoodTable(model.candidates)
+	})
+	return model
+}
+
+// directionModelFor returns the (cached) direction model of m.
+func directionModelFor(m matrix.Matrix) *directionModel {
+	if model, ok := directionModels.Load(m.Name); ok {
+		return model.(*directionModel)
+	}
+
+	model := newDirectionModel(m.Decode, makeDirectionCandidates(m))
+	actual, _ := directionModels.LoadOrStore(m.Name, model)
+	return actual.(*directionModel)
+}
+
+func makeDirectionCandidates(m matrix.Matrix) []directionCandidate {
+	n := int(360.0 / directionResolution)
+	candidates := make([]directionCandidate, n)
+	for i := range candidates {
+		azimuth := -180.0 + float64(i)*directionResolution
+		vector := m.EncodeDirection(azimuth)
+		candidates[i] = directionCandidate{
+			azimuth: azimuth,
+			vector:  vector,
+			norm:    real(vector[0])*real(vector[0]) + imag(vector[0])*imag(vector[0]) + real(vector[1])*real(vector[1]) + imag(vector[1])*imag(vector[1]),
+			gains:   matrix.PanGains(azimuth),
+		}
+	}
+	return candidates
+}
+
+// EnableWaveMatching toggles the wave-matching variable-matrix decoder.
+func (d *SQDecoder) EnableWaveMatching(enabled bool) {
+	d.waveConfig.Enabled = enabled
+}
+
+// SetWaveMatchingConfig updates wave-matching parameters. The detector and
+// all other filter state carry over, so it can be called mid-stream.
+func (d *SQDecoder) SetWaveMatchingConfig(config WaveMatchingConfig) {
+	d.waveConfig = config
+	if d.sampleRate > 0 {
+		d.waveCoeff = timeToCoeff(config.SmoothingTime, d.sampleRate)
+	}
+}
+
+// Direction returns the current wave-matching direction estimate: the
+// azimuth in degrees (0 = front, positive towards the left) and its
+// coherence (0.5 = diffuse, 1 = single source).
+func (d *SQDecoder) Direction() (float64, float64) {
+	return d.wave.azimuth, d.wave.coherence
+}
+
+// resetWaveMatching restores the passive matrix and clears the detector.
+func (d *SQDecoder) resetWaveMatching() {
+	d.wave = waveMatchState{rows: d.matrix.Decode}
+}
+
+// applyWaveMatching decodes one sample with the current variable matrix.
+func (d *SQDecoder) applyWaveMatching(lt, rt, hlt, hrt float64) (float64, float64, float64, float64) {
+	// Analytic signals matching the coefficient convention: c*x -> c*z.
+	zL := complex(lt, -hlt)
+	zR := complex(rt, -hrt)
+
+	a := d.waveCoeff
+	d.wave.covLL = a*d.wave.covLL + (1.0-a)*(real(zL)*real(zL)+imag(zL)*imag(zL))
+	d.wave.covRR = a*d.wave.covRR + (1.0-a)*(real(zR)*real(zR)+imag(zR)*imag(zR))
+	d.wave.covLR = complex(a, 0)*d.wave.covLR + complex(1.0-a, 0)*zL*cmplx.Conj(zR)
+
+	d.wave.countdown--
+	if d.wave.countdown <= 0 {
+		d.wave.countdown = waveMatchInterval
+		d.updateWaveMatrix()
+	}
+
+	var out [4]float64
+	for ch := 0; ch < 4; ch++ {
+		row := d.wave.rows[ch]
+		out[ch] = real(row[0])*lt + imag(row[0])*hlt + real(row[1])*rt + imag(row[1])*hrt
+	}
+	return out[0], out[1], out[2], out[3]
+}
+
+// updateWaveMatrix finds the dominant direction and matches every output's
+// response to it against the ideal panning gain, cancelling the crosstalk
+// terms of the passive matrix instead of merely attenuating them.
+func (d *SQDecoder) updateWaveMatrix() {
+	total := d.wave.covLL + d.wave.covRR
+	if total <= logicEpsilon {
+		d.wave.rows = d.matrix.Decode
+		d.wave.coherence = 0
+		return
+	}
+
+	best := 0
+	bestScore := -1.0
+	candidates := d.directions.candidates
+	for i, c := range candidates {
+		score := c.score(d.wave.covLL, d.wave.covRR, d.wave.covLR)
+		if score > bestScore {
+			bestScore = score
+			best = i
+		}
+	}
+
+	candidate := candidates[best]
+	d.wave.azimuth = candidate.azimuth
+	d.wave.coherence = bestScore
+	d.wave.rows = candidate.matchedRows(d.matrix.Decode, coherenceWeight(bestScore, d.waveConfig.CoherenceThreshold, d.waveConfig.Strength))
+}
+
+// score returns the share of the covariance energy explained by a source
+// at the candidate direction (1 = single source there).
+func (c directionCandidate) score(covLL, covRR float64, covLR complex128) float64 {
+	total := covLL + covRR
+	if total <= logicEpsilon {
+		return 0
+	}
+	e0, e1 := c.vector[0], c.vector[1]
+	power := (real(e0)*real(e0)+imag(e0)*imag(e0))*covLL +
+		(real(e1)*real(e1)+imag(e1)*imag(e1))*covRR +
+		2.0*real(cmplx.Conj(e0)*covLR*e1)
+	return power / (c.norm * total)
+}
+
+// matchedRows returns the passive decode rows with weight (0..1) of the
+// crosstalk for a source at the candidate direction cancelled: every
+// output's response to that source is moved towards its panning gain.
+func (c directionCandidate) matchedRows(decode [4][2]complex128, weight float64) [4][2]complex128 {
+	// d_e = conj(e)/|e|^2 is the matched decode row for the direction.
+	e := c.vector
+	match := [2]complex128{
+		cmplx.Conj(e[0]) / complex(c.norm, 0),
+		cmplx.Conj(e[1]) / complex(c.norm, 0),
+	}
+
+	var rows [4][2]complex128
+	for ch := 0; ch < 4; ch++ {
+		row := decode[ch]
+		response := row[0]*e[0] + row[1]*e[1]
+		correction := complex(weight, 0) * (complex(c.gains[ch], 0) - response)
+		rows[ch] = [2]complex128{
+			row[0] + correction*match[0],
+			row[1] + correction*match[1],
+		}
+	}
+	return rows
+}
+
+// coherenceWeight maps a direction score to a steering weight that rises
+// from 0 at threshold to strength at a perfect single-source score.
+func coherenceWeight(score, threshold, strength float64) float64 {
+	weight := 0.0
+	if threshold < 1.0 {
+		weight = (score - threshold) / (1.0 - threshold)
+	}
+	return math.Max(0, math.Min(1, weight)) * strength
+}
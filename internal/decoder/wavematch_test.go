@@ -0,0 +1,134 @@
+package decoder_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/cwbudde/go-sq-tool/internal/decoder"
+	"github.com/cwbudde/go-sq-tool/internal/encoder"
+	"github.com/cwbudde/go-sq-tool/internal/metrics"
+)
+
+func TestWaveMatching_CancelsCrosstalkForCorners(t *testing.T) {
+	t.Parallel()
+
+	const (
+		blockSize  = 1024
+		overlap    = 512
+		n          = 40 * overlap
+		skip       = 8 * overlap
+		sampleRate = 44100
+	)
+
+	azimuths := []float64{45, -45, 135, -135}
+	for ch, azimuth := range azimuths {
+		quad := make([][]float64, 4)
+		for i := range quad {
+			quad[i] = make([]float64, n)
+		}
+		for i := 0; i < n; i++ {
+			quad[ch][i] = 0.5 * math.Sin(2.0*math.Pi*1000.0*float64(i)/sampleRate)
+		}
+
+		stereo, err := encoder.NewSQEncoderWithParams(blockSize, overlap).Process(quad)
+		if err != nil {
+			t.Fatalf("encoder Process() error = %v", err)
+		}
+
+		passive := decoder.NewSQDecoderWithParams(blockSize, overlap)
+		passive.SetSampleRate(sampleRate)
+		outPassive, err := passive.Process(stereo)
+		if err != nil {
+			t.Fatalf("passive Process() error = %v", err)
+		}
+
+		wave := decoder.NewSQDecoderWithParams(blockSize, overlap)
+		wave.SetSampleRate(sampleRate)
+		wave.EnableWaveMatching(true)
+		if _, err := wave.ProcessChunk(stereo); err != nil {
+			t.Fatalf("ProcessChunk() error = %v", err)
+		}
+		gotAzimuth, coherence := wave.Direction()
+		if math.Abs(gotAzimuth-azimuth) > 3 {
+			t.Fatalf("channel %d: azimuth = %.1f, want %.1f", ch, gotAzimuth, azimuth)
+		}
+		if coherence < 0.95 {
+			t.Fatalf("channel %d: coherence = %.3f, want >= 0.95", ch, coherence)
+		}
+
+		wave.Reset()
+		outWave, err := wave.Process(stereo)
+		if err != nil {
+			t.Fatalf("wave Process() error = %v", err)
+		}
+
+		trim := func(out [][]float64) [][]float64 {
+			trimmed := make([][]float64, len(out))
+			for i := range out {
+				trimmed[i] = out[i][skip : n-overlap]
+			}
+			return trimmed
+		}
+		options := metrics.SeparationOptions{LeakMode: metrics.LeakModeMax}
+		sepPassive := metrics.ChannelSeparation(trim(outPassive), ch, options).SeparationDB
+		sepWave := metrics.ChannelSeparation(trim(outWave), ch, options).SeparationDB
+		t.Logf("channel %d: passive %.2f dB, wave-matching %.2f dB", ch, sepPassive, sepWave)
+		if sepWave < sepPassive+15 {
+			t.Fatalf("channel %d: separation = %.2f dB, want >= %.2f dB", ch, sepWave, sepPassive+15)
+		}
+	}
+}
+
+func TestWaveMatching_SetConfigKeepsFilterState(t *testing.T) {
+	t.Parallel()
+
+	const (
+		n          = 8192
+		sampleRate = 44100
+	)
+
+	stereo := [][]float64{make([]float64, n), make([]float64, n)}
+	for i := 0; i < n; i++ {
+		stereo[0][i] = 0.5 * math.Sin(2.0*math.Pi*440.0*float64(i)/sampleRate)
+		stereo[1][i] = 0.3 * math.Sin(2.0*math.Pi*3000.0*float64(i)/sampleRate)
+	}
+
+	newDecoder := func() *decoder.SQDecoder {
+		d := decoder.NewSQDecoder()
+		d.SetSampleRate(sampleRate)
+		d.EnableLogicSteering(true)
+		config := decoder.DefaultDecorrelationConfig()
+		config.Enabled = true
+		if err := d.SetDecorrelationConfig(config); err != nil {
+			t.Fatalf("SetDecorrelationConfig() error = %v", err)
+		}
+		return d
+	}
+	decode := func(d *decoder.SQDecoder, from, to int) [][]float64 {
+		out, err := d.ProcessChunk([][]float64{stereo[0][from:to], stereo[1][from:to]})
+		if err != nil {
+			t.Fatalf("ProcessChunk() error = %v", err)
+		}
+		return out
+	}
+
+	// Changing the (disabled) wave-matching parameters mid-stream must not
+	// reset the logic band splitters or the rear decorrelators.
+	want := decode(newDecoder(), 0, n)
+
+	d := newDecoder()
+	first := decode(d, 0, n/2)
+	config := decoder.DefaultWaveMatchingConfig()
+	config.SmoothingTime = 0.05
+	d.SetWaveMatchingConfig(config)
+	second := decode(d, n/2, n)
+
+	for ch := range want {
+		got := append(append([]float64{}, first[ch]...), second[ch]...)
+		for i := range want[ch] {
+			if got[i] != want[ch][i] {
+				t.Fatalf("channel %d sample %d = %g, want %g", ch, i, got[i], want[ch][i])
+			}
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package encoder
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+
+	"github.com/cwbudde/go-sq-tool/internal/matrix"
+	"github.com/cwbudde/go-sq-tool/pkg/sqmath"
+)
+
+// ambiXSmoothingTime is the time constant of the per-bin intensity and
+// energy estimates in seconds.
+const ambiXSmoothingTime = 0.03
+
+// ambiXEpsilon is the bin energy below which a bin counts as silent.
+const ambiXEpsilon = 1e-20
+
+// ambiXBin holds the smoothed sound field statistics of one STFT bin.
+type ambiXBin struct {
+	intensityX float64
+	intensityY float64
+	energy     float64
+}
+
+// AmbiXEncoder encodes first-order AmbiX (W, Y, Z, X; ACN order, SN3D) to
+// matrix stereo. A first-order sound field cannot be mapped to SQ by a
+// fixed matrix (the SQ phase relationships change from quadrant to
+// quadrant), so the encoder estimates the direction and diffuseness of
+// every STFT bin from the active intensity vector and encodes the direct
+// part of the bin with the exact Lt/Rt amplitude and phase of its
+// direction. The diffuse part goes through the least-squares first-order
+// approximation of the matrix.
+//
+// Process output is time aligned with the input; the streaming API delays
+// it by GetLatency samples internally and discards that delay again.
+type AmbiXEncoder struct {
+	stft       *sqmath.STFT
+	sampleRate int
+	smoothing  float64
+	matrix     matrix.Matrix
+	// directions holds the encoding vector of every whole degree of
+	// azimuth from -180.
+	directions [360][2]complex128
+	// diffuse holds the (Lt, Rt) coefficients of W, Y and X.
+	diffuse [2][3]complex128
+	bins    []ambiXBin
+}
+
+// NewAmbiXEncoder creates an AmbiX encoder with default parameters.
+func NewAmbiXEncoder() *AmbiXEncoder {
+	e, err := NewAmbiXEncoderWithParams(DefaultBlockSize, DefaultOverlap)
+	if err != nil {
+		panic(err)
+	}
+	return e
+}
+
+// NewAmbiXEncoderWithParams creates an AmbiX encoder with an FFT size
+// (power of 2) and a hop size of at most fftSize/2.
+func NewAmbiXEncoderWithParams(fftSize, hop int) (*AmbiXEncoder, error) {
+	e := &AmbiXEncoder{
+		sampleRate: 44100,
+	}
+
+	stft, err := sqmath.NewSTFT(fftSize, hop, 4, 2, e.encodeBins)
+	if err != nil {
+		return nil, fmt.Errorf("AmbiX encoder: %w", err)
+	}
+	e.stft = stft
+	e.bins = make([]ambiXBin, fftSize/2+1)
+
+	e.SetMatrix(matrix.SQ)
+	e.updateSmoothing()
+	e.Reset()
+	return e, nil
+}
+
+// SetSampleRate sets the sample rate used for the per-bin smoothing.
+func (e *AmbiXEncoder) SetSampleRate(sampleRate int) {
+	if sampleRate <= 0 {
+		return
+	}
+	e.sampleRate = sampleRate
+	e.updateSmoothing()
+}
+
+// SetMatrix selects the matrix system to encode with (SQ by default).
+func (e *AmbiXEncoder) SetMatrix(m matrix.Matrix) {
+	e.matrix = m
+	e.diffuse = [2][3]complex128{}
+	for i := range e.directions {
+		azimuth := float64(i) - 180.0
+		vector := m.EncodeDirection(azimuth)
+		e.directions[i] = vector
+
+		// Fourier coefficients of the encoding vector over the circle: the
+		// least-squares fit by 1, sin and cos of the azimuth.
+		sin, cos := math.Sincos(azimuth * math.Pi / 180.0)
+		for c := 0; c < 2; c++ {
+			e.diffuse[c][0] += vector[c] / 360.0
+			e.diffuse[c][1] += vector[c] * complex(2.0*sin/360.0, 0)
+			e.diffuse[c][2] += vector[c] * complex(2.0*cos/360.0, 0)
+		}
+	}
+}
+
+func (e *AmbiXEncoder) updateSmoothing() {
+	// The statistics are updated once per hop.
+	e.smoothing = math.Exp(-float64(e.stft.Hop()) / (ambiXSmoothingTime * float64(e.sampleRate)))
+}
+
+// Process encodes a complete AmbiX signal to time-aligned stereo.
+// Input: [4][numSamples] - W, Y, Z, X
+// Output: [2][numSamples] - LT, RT
+// Any streaming state is discarded.
+func (e *AmbiXEncoder) Process(input [][]float64) ([][]float64, error) {
+	e.Reset()
+	output, err := e.ProcessChunk(input)
+	if err != nil {
+		return nil, err
+	}
+
+	tail := e.Flush()
+	for ch := range output {
+		output[ch] = append(output[ch], tail[ch]...)
+	}
+	return output, nil
+}
+
+// ProcessChunk encodes an arbitrary-sized chunk of a continuous stream.
+// Input: [4][chunkSize] - W, Y, Z, X
+// Output: [2][n] - LT, RT for every hop that became complete.
+//
+// The concatenation of all ProcessChunk outputs followed by Flush is
+// identical to a single Process call on the whole stream.
+func (e *AmbiXEncoder) ProcessChunk(input [][]float64) ([][]float64, error) {
+	if len(input) != 4 {
+		return nil, fmt.Errorf("input must have 4 channels, got %d", len(input))
+	}
+
+	numSamples := len(input[0])
+	for i := 1; i < 4; i++ {
+		if len(input[i]) != numSamples {
+			return nil, fmt.Errorf("input channels must have same length")
+		}
+	}
+
+	output := make([][]float64, 2)
+	e.stft.ProcessChunk(output, input)
+	return output, nil
+}
+
+// Flush zero-pads and encodes the samples still buffered by ProcessChunk.
+// Output: [2][n] - the remaining LT, RT samples of the stream.
+// The encoder is ready for a new stream afterwards.
+func (e *AmbiXEncoder) Flush() [][]float64 {
+	output := make([][]float64, 2)
+	e.stft.Flush(output)
+	e.Reset()
+
+	return output
+}
+
+// Reset discards buffered input and output and the per-bin statistics.
+func (e *AmbiXEncoder) Reset() {
+	e.stft.Reset()
+	for k := range e.bins {
+		e.bins[k] = ambiXBin{}
+	}
+}
+
+// encodeBins splits every bin of one STFT frame into a plane wave from its
+// intensity direction and a diffuse remainder and encodes both.
+func (e *AmbiXEncoder) encodeBins(in, out [][]complex128) {
+	a := e.smoothing
+	for k := range e.bins {
+		w := in[0][k]
+		y := in[1][k]
+		z := in[2][k]
+		x := in[3][k]
+
+		// Active intensity and energy density in SN3D: a plane wave has
+		// |I| = E = |W|², a diffuse field has I = 0.
+		bin := &e.bins[k]
+		bin.intensityX = a*bin.intensityX + (1.0-a)*real(cmplx.Conj(w)*x)
+		bin.intensityY = a*bin.intensityY + (1.0-a)*real(cmplx.Conj(w)*y)
+		energy := real(w*cmplx.Conj(w)) + real(x*cmplx.Conj(x)) + real(y*cmplx.Conj(y)) + real(z*cmplx.Conj(z))
+		bin.energy = a*bin.energy + (1.0-a)*0.5*energy
+
+		diffuseness := 1.0
+		var vector [2]complex128
+		if bin.energy > ambiXEpsilon {
+			diffuseness = math.Max(0, 1.0-math.Hypot(bin.intensityX, bin.intensityY)/bin.energy)
+			azimuth := math.Atan2(bin.intensityY, bin.intensityX) * 180.0 / math.Pi
+			vector = e.directions[int(math.Round(azimuth+180.0))%360]
+		}
+		direct := complex(math.Sqrt(1.0-diffuseness), 0)
+		diffuse := complex(math.Sqrt(diffuseness), 0)
+
+		// For positive frequencies the Hilbert transform is a multiplication
+		// by -i, so a coefficient c acts on a spectrum as conj(c).
+		for c := 0; c < 2; c++ {
+			fit := e.diffuse[c]
+			out[c][k] = direct*cmplx.Conj(vector[c])*w +
+				diffuse*(cmplx.Conj(fit[0])*w+cmplx.Conj(fit[1])*y+cmplx.Conj(fit[2])*x)
+		}
+	}
+}
+
+// GetLatency returns the streaming latency in samples.
+func (e *AmbiXEncoder) GetLatency() int {
+	return e.stft.Latency()
+}
+
+// GetInfo returns information about the encoder configuration.
+func (e *AmbiXEncoder) GetInfo() string {
+	return fmt.Sprintf("AmbiX Encoder (per-bin direction)\n"+
+		"FFT Size: %d samples\n"+
+		"Hop: %d samples\n"+
+		"Latency: %d samples (%.2f ms @ 44.1kHz)",
+		e.stft.FFTSize(), e.stft.Hop(), e.GetLatency(),
+		float64(e.GetLatency())/44100.0*1000.0)
+}
@@ -0,0 +1,119 @@
+package encoder_test
+
+import (
+	"math"
+	"math/cmplx"
+	"math/rand"
+	"testing"
+
+	"github.com/cwbudde/go-sq-tool/internal/encoder"
+	"github.com/cwbudde/go-sq-tool/internal/matrix"
+)
+
+// toneResponse returns the complex amplitude of x at freq Hz.
+func toneResponse(x []float64, freq, sampleRate float64) complex128 {
+	var sum complex128
+	for i, v := range x {
+		sum += complex(v, 0) * cmplx.Exp(complex(0, -2.0*math.Pi*freq*float64(i)/sampleRate))
+	}
+	return sum
+}
+
+// planeWave returns first-order AmbiX (W, Y, Z, X) of a sine from azimuth degrees.
+func planeWave(azimuth, freq, sampleRate float64, n int) [][]float64 {
+	sin, cos := math.Sincos(azimuth * math.Pi / 180.0)
+	gains := [4]float64{1, sin, 0, cos}
+	foa := make([][]float64, 4)
+	for ch := range foa {
+		foa[ch] = make([]float64, n)
+		for i := range foa[ch] {
+			foa[ch][i] = 0.5 * gains[ch] * math.Sin(2.0*math.Pi*freq*float64(i)/sampleRate)
+		}
+	}
+	return foa
+}
+
+func TestAmbiXEncoder_MatchesMatrixForEveryDirection(t *testing.T) {
+	t.Parallel()
+
+	const (
+		sampleRate = 44100.0
+		n          = 1 << 14
+		skip       = 4096
+		freq       = 1000.0
+	)
+
+	for _, m := range []matrix.Matrix{matrix.SQ, matrix.QS} {
+		e := encoder.NewAmbiXEncoder()
+		e.SetSampleRate(sampleRate)
+		e.SetMatrix(m)
+
+		for azimuth := -180.0; azimuth < 180; azimuth += 15 {
+			foa := planeWave(azimuth, freq, sampleRate, n)
+			stereo, err := e.Process(foa)
+			if err != nil {
+				t.Fatalf("Process() error = %v", err)
+			}
+			if len(stereo) != 2 || len(stereo[0]) != n {
+				t.Fatalf("Process() returned %d channels of %d samples", len(stereo), len(stereo[0]))
+			}
+
+			// The Lt/Rt amplitude and phase of the source must be those of
+			// the matrix at its direction; conj follows the coefficient
+			// convention for positive frequencies.
+			source := toneResponse(foa[0][skip:n-skip], freq, sampleRate)
+			want := m.EncodeDirection(azimuth)
+			for c := 0; c < 2; c++ {
+				got := toneResponse(stereo[c][skip:n-skip], freq, sampleRate) / source
+				if cmplx.Abs(got-cmplx.Conj(want[c])) > 0.02 {
+					t.Errorf("%s %.0f°: channel %d = %.3f, want %.3f", m.Name, azimuth, c, got, cmplx.Conj(want[c]))
+				}
+			}
+		}
+	}
+}
+
+func TestAmbiXEncoder_ProcessChunk_MatchesProcess(t *testing.T) {
+	t.Parallel()
+
+	const n = 6000
+	rng := rand.New(rand.NewSource(7))
+	foa := make([][]float64, 4)
+	for ch := range foa {
+		foa[ch] = make([]float64, n)
+		for i := range foa[ch] {
+			foa[ch][i] = 0.2 * rng.NormFloat64()
+		}
+	}
+
+	e := encoder.NewAmbiXEncoder()
+	want, err := e.Process(foa)
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+
+	got := [][]float64{nil, nil}
+	for start, size := 0, 5; start < n; start, size = start+size, size*3%1201+1 {
+		end := min(start+size, n)
+		chunk := [][]float64{foa[0][start:end], foa[1][start:end], foa[2][start:end], foa[3][start:end]}
+		out, err := e.ProcessChunk(chunk)
+		if err != nil {
+			t.Fatalf("ProcessChunk() error = %v", err)
+		}
+		for c := range got {
+			got[c] = append(got[c], out[c]...)
+		}
+	}
+	tail := e.Flush()
+	for c := range got {
+		got[c] = append(got[c], tail[c]...)
+		if len(got[c]) != n {
+			t.Fatalf("channel %d: got %d samples, want %d", c, len(got[c]), n)
+		}
+		for i := range got[c] {
+			if math.Abs(got[c][i]-want[c][i]) > 1e-12 {
+				t.Fatalf("channel %d sample %d: chunked %.15f, whole %.15f", c, i, got[c][i], want[c][i])
+			}
+		}
+	}
+}
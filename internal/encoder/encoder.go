@@ -2,8 +2,8 @@ package encoder
 
 import (
 	"fmt"
-	"math"
 
+	"github.com/cwbudde/go-sq-tool/internal/matrix"
 	"github.com/cwbudde/go-sq-tool/pkg/sqmath"
 )
 
@@ -19,9 +19,11 @@ type SQEncoder struct {
 	blockSize    int
 	overlap      int
 	initialDelay int
-	sqrt2        float64
-	hilbertLB    *sqmath.HilbertTransformer
-	hilbertRB    *sqmath.HilbertTransformer
+	matrix       matrix.Matrix
+	hilbert      [4]*sqmath.HilbertTransformer
+	phase        [4]*sqmath.PhaseDifferenceNetwork
+	inputBuffers [4][]float64
+	bufferPos    int
 }
 
 // NewSQEncoder creates a new SQ encoder with FFT-based Hilbert transform
@@ -33,14 +35,40 @@ func NewSQEncoder() *SQEncoder {
 func NewSQEncoderWithParams(blockSize, overlap int) *SQEncoder {
 	initialDelay := overlap + overlap/2
 
-	return &SQEncoder{
+	encoder := &SQEncoder{
 		blockSize:    blockSize,
 		overlap:      overlap,
 		initialDelay: initialDelay,
-		sqrt2:        math.Sqrt(2.0) / 2.0, // ≈ 0.707
-		hilbertLB:    sqmath.NewHilbertTransformer(blockSize, overlap),
-		hilbertRB:    sqmath.NewHilbertTransformer(blockSize, overlap),
+		matrix:       matrix.SQ,
 	}
+
+	// Initialize Hilbert transformers and streaming input FIFOs (starting
+	// with the zero look-behind)
+	for i := 0; i < 4; i++ {
+		encoder.hilbert[i] = sqmath.NewHilbertTransformer(blockSize, overlap)
+		encoder.inputBuffers[i] = make([]float64, blockSize)
+	}
+	encoder.bufferPos = encoder.lookbehind()
+
+	return encoder
+}
+
+// NewSQEncoderIIR creates a zero-latency SQ encoder that derives the 90°
+// shifted signals from a cascaded IIR all-pass phase difference network
+// instead of the FFT Hilbert transform. All inputs pass through the
+// network's direct path so the channels keep a common phase response.
+func NewSQEncoderIIR(coefs sqmath.PhaseNetworkCoefficients) *SQEncoder {
+	encoder := NewSQEncoderWithParams(DefaultBlockSize, DefaultOverlap)
+	encoder.initialDelay = 0
+	for ch := 0; ch < 4; ch++ {
+		encoder.phase[ch] = sqmath.NewPhaseDifferenceNetwork(coefs)
+	}
+	return encoder
+}
+
+// SetMatrix selects the matrix system to encode with (SQ by default).
+func (e *SQEncoder) SetMatrix(m matrix.Matrix) {
+	e.matrix = m
 }
 
 // Process encodes 4-channel quadrophonic audio to stereo SQ
@@ -58,6 +86,10 @@ func (e *SQEncoder) Process(input [][]float64) ([][]float64, error) {
 		}
 	}
 
+	if e.phase[0] != nil {
+		return e.encodeIIR(input), nil
+	}
+
 	numBlocks := (numSamples + e.overlap - 1) / e.overlap
 
 	output := make([][]float64, 2)
@@ -65,62 +97,215 @@ func (e *SQEncoder) Process(input [][]float64) ([][]float64, error) {
 		output[i] = make([]float64, numSamples)
 	}
 
+	blocks := [4][]float64{}
+	for ch := 0; ch < 4; ch++ {
+		blocks[ch] = make([]float64, e.blockSize)
+	}
+
 	for blockIdx := 0; blockIdx < numBlocks; blockIdx++ {
 		startIdx := blockIdx * e.overlap
+		blockStart := startIdx - e.lookbehind()
 
-		blockLF := make([]float64, e.blockSize)
-		blockRF := make([]float64, e.blockSize)
-		blockLB := make([]float64, e.blockSize)
-		blockRB := make([]float64, e.blockSize)
-
-		for i := 0; i < e.blockSize; i++ {
-			srcIdx := startIdx + i
-			if srcIdx < numSamples {
-				blockLF[i] = input[0][srcIdx]
-				blockRF[i] = input[1][srcIdx]
-				blockLB[i] = input[2][srcIdx]
-				blockRB[i] = input[3][srcIdx]
+		for ch := 0; ch < 4; ch++ {
+			for i := 0; i < e.blockSize; i++ {
+				srcIdx := blockStart + i
+				if srcIdx >= 0 && srcIdx < numSamples {
+					blocks[ch][i] = input[ch][srcIdx]
+				} else {
+					blocks[ch][i] = 0
+				}
 			}
 		}
 
-		phaseShiftedLB := e.hilbertLB.ProcessBlock(blockLB)
-		phaseShiftedRB := e.hilbertRB.ProcessBlock(blockRB)
+		count := min(e.overlap, numSamples-startIdx)
+		e.encodeBlock(blocks, output, startIdx, count)
+	}
 
-		outputOffset := e.overlap / 2
-		inputOffset := e.overlap / 4
+	return output, nil
+}
 
-		for i := 0; i < e.overlap; i++ {
-			outIdx := startIdx + i
-			if outIdx >= numSamples {
-				break
-			}
+// ProcessChunk encodes an arbitrary-sized chunk of a continuous stream.
+// Input: [4][chunkSize] - LF, RF, LB, RB
+// Output: [2][n] - LT, RT for every block that became complete.
+//
+// The input FIFOs carry the overlap between calls, so the concatenation of
+// all ProcessChunk outputs followed by Flush is bit-identical to a single
+// Process call on the whole stream.
+func (e *SQEncoder) ProcessChunk(input [][]float64) ([][]float64, error) {
+	if len(input) != 4 {
+		return nil, fmt.Errorf("input must have 4 channels, got %d", len(input))
+	}
+
+	numSamples := len(input[0])
+	for i := 1; i < 4; i++ {
+		if len(input[i]) != numSamples {
+			return nil, fmt.Errorf("input channels must have same length")
+		}
+	}
+
+	if e.phase[0] != nil {
+		return e.encodeIIR(input), nil
+	}
+
+	// Every complete block releases exactly one hop of output.
+	numBlocks := 0
+	if pending := e.bufferPos + numSamples; pending >= e.blockSize {
+		numBlocks = (pending-e.blockSize)/e.overlap + 1
+	}
+
+	output := make([][]float64, 2)
+	for i := 0; i < 2; i++ {
+		output[i] = make([]float64, numBlocks*e.overlap)
+	}
+
+	outPos := 0
+	srcIdx := 0
+	for srcIdx < numSamples {
+		n := copy(e.inputBuffers[0][e.bufferPos:], input[0][srcIdx:])
+		for ch := 1; ch < 4; ch++ {
+			copy(e.inputBuffers[ch][e.bufferPos:], input[ch][srcIdx:srcIdx+n])
+		}
+		e.bufferPos += n
+		srcIdx += n
+
+		if e.bufferPos < e.blockSize {
+			break
+		}
+
+		e.encodeBlock(e.inputBuffers, output, outPos, e.overlap)
+		outPos += e.overlap
+		e.advanceBuffer()
+	}
+
+	return output, nil
+}
 
-			inIdx := inputOffset + i
-			if inIdx >= e.blockSize {
-				break
+// Flush zero-pads and encodes the samples still buffered by ProcessChunk.
+// Output: [2][n] - the remaining LT, RT samples of the stream.
+// The encoder is ready for a new stream afterwards.
+func (e *SQEncoder) Flush() [][]float64 {
+	pending := max(e.bufferPos-e.lookbehind(), 0)
+	output := make([][]float64, 2)
+	for i := 0; i < 2; i++ {
+		output[i] = make([]float64, pending)
+	}
+
+	outPos := 0
+	for pending > 0 {
+		for ch := 0; ch < 4; ch++ {
+			for i := e.bufferPos; i < e.blockSize; i++ {
+				e.inputBuffers[ch][i] = 0
 			}
+		}
+
+		count := min(e.overlap, pending)
+		e.encodeBlock(e.inputBuffers, output, outPos, count)
+		outPos += count
+		pending -= count
+		e.advanceBuffer()
+	}
+
+	e.Reset()
+
+	return output
+}
+
+// Reset discards buffered input and phase network state so the encoder can
+// start a new stream.
+func (e *SQEncoder) Reset() {
+	for ch := 0; ch < 4; ch++ {
+		for i := range e.inputBuffers[ch] {
+			e.inputBuffers[ch][i] = 0
+		}
+		if e.phase[ch] != nil {
+			e.phase[ch].Reset()
+		}
+	}
+	e.bufferPos = e.lookbehind()
+}
+
+// lookbehind is the number of past samples at the start of every block.
+func (e *SQEncoder) lookbehind() int {
+	return sqmath.HilbertLookbehind(e.overlap)
+}
 
-			phaseIdx := outputOffset + i
-			if phaseIdx >= e.blockSize {
-				break
+// advanceBuffer drops one hop from the front of the streaming input FIFOs.
+func (e *SQEncoder) advanceBuffer() {
+	hop := min(e.overlap, e.bufferPos)
+	for ch := 0; ch < 4; ch++ {
+		copy(e.inputBuffers[ch], e.inputBuffers[ch][hop:e.bufferPos])
+	}
+	e.bufferPos -= hop
+}
+
+// encodeBlock encodes up to count samples of one block into output starting at outPos.
+func (e *SQEncoder) encodeBlock(blocks [4][]float64, output [][]float64, outPos, count int) {
+	// Only channels with a phase-shifted coefficient need the Hilbert path
+	// (LB and RB for SQ).
+	var phaseShifted [4][]float64
+	for ch := 0; ch < 4; ch++ {
+		if e.matrix.HasPhaseShift(ch) {
+			phaseShifted[ch] = e.hilbert[ch].ProcessBlock(blocks[ch])
+		}
+	}
+
+	// Read the direct and the shifted path time aligned.
+	inputOffset, outputOffset := sqmath.HilbertOffsets(e.overlap)
+
+	for i := 0; i < count; i++ {
+		inIdx := inputOffset + i
+		if inIdx >= e.blockSize {
+			break
+		}
+
+		phaseIdx := outputOffset + i
+		if phaseIdx >= e.blockSize {
+			break
+		}
+
+		var in, shifted [4]float64
+		for ch := 0; ch < 4; ch++ {
+			in[ch] = blocks[ch][inIdx]
+			if phaseShifted[ch] != nil {
+				shifted[ch] = phaseShifted[ch][phaseIdx]
 			}
+		}
 
-			lf := blockLF[inIdx]
-			rf := blockRF[inIdx]
-			lb := blockLB[inIdx]
-			rb := blockRB[inIdx]
-			hlb := phaseShiftedLB[phaseIdx]
-			hrb := phaseShiftedRB[phaseIdx]
-
-			// SQ Encode Matrix:
-			// LT = LF + sqrt(2)/2 * RB - sqrt(2)/2 * H(LB)
-			// RT = RF - sqrt(2)/2 * LB + sqrt(2)/2 * H(RB)
-			output[0][outIdx] = lf + e.sqrt2*rb - e.sqrt2*hlb
-			output[1][outIdx] = rf - e.sqrt2*lb + e.sqrt2*hrb
+		output[0][outPos+i], output[1][outPos+i] = e.encodeSample(in, shifted)
+	}
+}
+
+// encodeSample applies the encode matrix to one sample of LF, RF, LB, RB
+// and their 90° shifted versions. For SQ:
+// LT = LF + sqrt(2)/2 * RB - sqrt(2)/2 * H(LB)
+// RT = RF - sqrt(2)/2 * LB + sqrt(2)/2 * H(RB)
+func (e *SQEncoder) encodeSample(in, shifted [4]float64) (float64, float64) {
+	var lt, rt float64
+	for ch, c := range e.matrix.Encode {
+		lt += real(c[0])*in[ch] + imag(c[0])*shifted[ch]
+		rt += real(c[1])*in[ch] + imag(c[1])*shifted[ch]
+	}
+	return lt, rt
+}
+
+// encodeIIR encodes a chunk sample by sample through the all-pass phase
+// networks. The filter state carries over between calls.
+func (e *SQEncoder) encodeIIR(input [][]float64) [][]float64 {
+	numSamples := len(input[0])
+	output := make([][]float64, 2)
+	for i := 0; i < 2; i++ {
+		output[i] = make([]float64, numSamples)
+	}
+
+	var in, shifted [4]float64
+	for i := 0; i < numSamples; i++ {
+		for ch := 0; ch < 4; ch++ {
+			in[ch], shifted[ch] = e.phase[ch].Process(input[ch][i])
 		}
+		output[0][i], output[1][i] = e.encodeSample(in, shifted)
 	}
 
-	return output, nil
+	return output
 }
 
 // GetLatency returns the encoder latency in samples
@@ -130,6 +315,10 @@ func (e *SQEncoder) GetLatency() int {
 
 // GetInfo returns information about the encoder configuration
 func (e *SQEncoder) GetInfo() string {
+	if e.phase[0] != nil {
+		return "SQ Encoder (IIR all-pass)\n" +
+			"Latency: 0 samples"
+	}
 	return fmt.Sprintf("SQ Encoder (FFT-based)\n"+
 		"Block Size: %d samples\n"+
 		"Overlap: %d samples\n"+
@@ -52,6 +52,46 @@ func TestSQEncoder_Process_FrontOnlyShifted(t *testing.T) {
 	}
 }
 
+func TestSQEncoder_Process_BackChannelUnityGainAligned(t *testing.T) {
+	t.Parallel()
+
+	const (
+		blockSize = 1024
+		overlap   = 512
+		n         = 10 * overlap
+		period    = 37.0
+	)
+
+	// LB alone encodes to LT = -sqrt(2)/2·H(LB) and RT = -sqrt(2)/2·LB.
+	// H(sin) = -cos, so LT must be a unity-gain quadrature copy of RT, time
+	// aligned with the front channels.
+	lb := make([]float64, n)
+	for i := range lb {
+		lb[i] = 0.5 * math.Sin(2.0*math.Pi*float64(i)/period)
+	}
+
+	sqEnc := encoder.NewSQEncoderWithParams(blockSize, overlap)
+	stereo, err := sqEnc.Process([][]float64{make([]float64, n), make([]float64, n), lb, make([]float64, n)})
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+
+	shift := overlap / 4
+	k := math.Sqrt2 / 2
+	var maxErrLT, maxErrRT float64
+	for i := overlap; i < n-overlap; i++ {
+		phi := 2.0 * math.Pi * float64(i+shift) / period
+		maxErrLT = math.Max(maxErrLT, math.Abs(stereo[0][i]-k*0.5*math.Cos(phi)))
+		maxErrRT = math.Max(maxErrRT, math.Abs(stereo[1][i]+k*0.5*math.Sin(phi)))
+	}
+	if maxErrLT > 0.01 {
+		t.Errorf("LT max error vs sqrt(2)/2·0.5·cos = %.4f, want <= 0.01", maxErrLT)
+	}
+	if maxErrRT > 1e-12 {
+		t.Errorf("RT max error vs -sqrt(2)/2·LB = %.2e, want <= 1e-12", maxErrRT)
+	}
+}
+
 func TestSQEncoder_Process_ZeroInputIsZeroOutput(t *testing.T) {
 	t.Parallel()
 
@@ -97,3 +137,67 @@ func TestSQEncoder_Process_Errors(t *testing.T) {
 		t.Fatalf("expected error for length mismatch")
 	}
 }
+
+func TestSQEncoder_ProcessChunk_MatchesProcess(t *testing.T) {
+	t.Parallel()
+
+	const (
+		blockSize = 1024
+		overlap   = 512
+		n         = 9*overlap + 211
+	)
+
+	quad := make([][]float64, 4)
+	for ch := range quad {
+		quad[ch] = make([]float64, n)
+		for i := 0; i < n; i++ {
+			quad[ch][i] = 0.3 * math.Sin(2.0*math.Pi*float64(i)/float64(53+ch*29))
+		}
+	}
+
+	whole := encoder.NewSQEncoderWithParams(blockSize, overlap)
+	want, err := whole.Process(quad)
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+
+	stream := encoder.NewSQEncoderWithParams(blockSize, overlap)
+	// Feed a throwaway stream first to check Reset clears the FIFOs.
+	if _, err := stream.ProcessChunk([][]float64{{1, 1}, {1, 1}, {1, 1}, {1, 1}}); err != nil {
+		t.Fatalf("ProcessChunk() error = %v", err)
+	}
+	stream.Reset()
+
+	got := make([][]float64, 2)
+	chunkSizes := []int{5, 1500, 511, 1, 3000, 64}
+	for pos, c := 0, 0; pos < n; c++ {
+		end := min(pos+chunkSizes[c%len(chunkSizes)], n)
+		chunk := make([][]float64, 4)
+		for ch := range chunk {
+			chunk[ch] = quad[ch][pos:end]
+		}
+		out, err := stream.ProcessChunk(chunk)
+		if err != nil {
+			t.Fatalf("ProcessChunk() error = %v", err)
+		}
+		for ch := range got {
+			got[ch] = append(got[ch], out[ch]...)
+		}
+		pos = end
+	}
+	tail := stream.Flush()
+	for ch := range got {
+		got[ch] = append(got[ch], tail[ch]...)
+	}
+
+	for ch := 0; ch < 2; ch++ {
+		if len(got[ch]) != n {
+			t.Fatalf("len(out[%d]) = %d, want %d", ch, len(got[ch]), n)
+		}
+		for i := 0; i < n; i++ {
+			if got[ch][i] != want[ch][i] {
+				t.Fatalf("out[%d][%d] = %.15f, want %.15f", ch, i, got[ch][i], want[ch][i])
+			}
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package encoder
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/cwbudde/go-sq-tool/internal/matrix"
+)
+
+// AzimuthPoint is one breakpoint of an azimuth automation.
+type AzimuthPoint struct {
+	// Time is the breakpoint time in seconds.
+	Time float64
+	// Azimuth is the source direction in degrees (0 = front, positive
+	// towards the left).
+	Azimuth float64
+}
+
+// AzimuthAutomation is a time-varying source direction. Between
+// breakpoints the azimuth is interpolated linearly in the given values, so
+// a move from 170 to 190 passes through the back and a move from 170 to
+// -170 sweeps through the front; before the first and after the last
+// breakpoint it holds.
+type AzimuthAutomation []AzimuthPoint
+
+// ConstantAzimuth returns an automation that stays at azimuth degrees.
+func ConstantAzimuth(azimuth float64) AzimuthAutomation {
+	return AzimuthAutomation{{Time: 0, Azimuth: azimuth}}
+}
+
+// NewAzimuthAutomation sorts points by time and validates them.
+func NewAzimuthAutomation(points []AzimuthPoint) (AzimuthAutomation, error) {
+	if len(points) == 0 {
+		return nil, fmt.Errorf("azimuth automation has no points")
+	}
+	automation := append(AzimuthAutomation(nil), points...)
+	sort.SliceStable(automation, func(i, j int) bool { return automation[i].Time < automation[j].Time })
+	if automation[0].Time < 0 {
+		return nil, fmt.Errorf("azimuth automation time %.3f s is negative", automation[0].Time)
+	}
+	return automation, nil
+}
+
+// At returns the azimuth at time t seconds.
+func (a AzimuthAutomation) At(t float64) float64 {
+	if len(a) == 0 {
+		return 0
+	}
+	i := sort.Search(len(a), func(i int) bool { return a[i].Time > t })
+	if i == 0 {
+		return a[0].Azimuth
+	}
+	if i == len(a) {
+		return a[len(a)-1].Azimuth
+	}
+	p0, p1 := a[i-1], a[i]
+	frac := (t - p0.Time) / (p1.Time - p0.Time)
+	return p0.Azimuth + frac*(p1.Azimuth-p0.Azimuth)
+}
+
+// PositionEncoder pans mono sources to arbitrary azimuths and encodes them
+// to matrix stereo, like the CBS SQ position encoders: every source reaches
+// Lt/Rt with the amplitude and phase of its direction. The sources are
+// panned with constant-power pairwise gains onto a quad bus that feeds an
+// SQEncoder, so all sources share its four phase shifters and the output
+// has its latency and streaming behaviour.
+type PositionEncoder struct {
+	encoder    *SQEncoder
+	sampleRate float64
+	azimuths   []AzimuthAutomation
+	position   int
+}
+
+// NewPositionEncoder creates a position encoder for one source per
+// automation, encoding with sqEncoder at sampleRate Hz.
+func NewPositionEncoder(sqEncoder *SQEncoder, sampleRate int, azimuths []AzimuthAutomation) (*PositionEncoder, error) {
+	if sampleRate <= 0 {
+		return nil, fmt.Errorf("invalid sample rate %d", sampleRate)
+	}
+	if len(azimuths) == 0 {
+		return nil, fmt.Errorf("position encoder needs at least one source")
+	}
+	for i, a := range azimuths {
+		if len(a) == 0 {
+			return nil, fmt.Errorf("source %d has no azimuth", i)
+		}
+	}
+	return &PositionEncoder{
+		encoder:    sqEncoder,
+		sampleRate: float64(sampleRate),
+		azimuths:   azimuths,
+	}, nil
+}
+
+// Process encodes complete sources to stereo.
+// Input: [numSources][numSamples] - mono sources in automation order
+// Output: [2][numSamples] - LT, RT
+// Any streaming state is discarded.
+func (p *PositionEncoder) Process(input [][]float64) ([][]float64, error) {
+	p.Reset()
+	output, err := p.ProcessChunk(input)
+	if err != nil {
+		return nil, err
+	}
+
+	tail := p.Flush()
+	for ch := range output {
+		output[ch] = append(output[ch], tail[ch]...)
+	}
+	return output, nil
+}
+
+// ProcessChunk encodes an arbitrary-sized chunk of a continuous stream.
+// The concatenation of all ProcessChunk outputs followed by Flush is
+// identical to a single Process call on the whole stream.
+func (p *PositionEncoder) ProcessChunk(input [][]float64) ([][]float64, error) {
+	if len(input) != len(p.azimuths) {
+		return nil, fmt.Errorf("input must have %d sources, got %d", len(p.azimuths), len(input))
+	}
+	numSamples := len(input[0])
+	for i := 1; i < len(input); i++ {
+		if len(input[i]) != numSamples {
+			return nil, fmt.Errorf("input sources must have same length")
+		}
+	}
+
+	return p.encoder.ProcessChunk(p.panToQuad(input, numSamples))
+}
+
+// panToQuad mixes the sources onto the LF, RF, LB, RB bus.
+func (p *PositionEncoder) panToQuad(input [][]float64, numSamples int) [][]float64 {
+	bus := make([][]float64, 4)
+	for ch := range bus {
+		bus[ch] = make([]float64, numSamples)
+	}
+	for src, samples := range input {
+		automation := p.azimuths[src]
+		static := len(automation) == 1
+		gains := matrix.PanGains(automation[0].Azimuth)
+		for i, v := range samples {
+			if !static {
+				gains = matrix.PanGains(automation.At(float64(p.position+i) / p.sampleRate))
+			}
+			for ch, g := range gains {
+				bus[ch][i] += g * v
+			}
+		}
+	}
+	p.position += numSamples
+	return bus
+}
+
+// Flush encodes the samples still buffered by ProcessChunk.
+// The encoder is ready for a new stream afterwards.
+func (p *PositionEncoder) Flush() [][]float64 {
+	p.position = 0
+	return p.encoder.Flush()
+}
+
+// Reset discards any buffered input and restarts the automation.
+func (p *PositionEncoder) Reset() {
+	p.position = 0
+	p.encoder.Reset()
+}
+
+// GetLatency returns the encoder latency in samples.
+func (p *PositionEncoder) GetLatency() int {
+	return p.encoder.GetLatency()
+}
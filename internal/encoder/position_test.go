@@ -0,0 +1,142 @@
+package encoder_test
+
+import (
+	"math"
+	"math/cmplx"
+	"testing"
+
+	"github.com/cwbudde/go-sq-tool/internal/encoder"
+	"github.com/cwbudde/go-sq-tool/internal/matrix"
+)
+
+func TestAzimuthAutomation_At(t *testing.T) {
+	t.Parallel()
+
+	a, err := encoder.NewAzimuthAutomation([]encoder.AzimuthPoint{
+		{Time: 2, Azimuth: 190},
+		{Time: 1, Azimuth: 170},
+	})
+	if err != nil {
+		t.Fatalf("NewAzimuthAutomation() error = %v", err)
+	}
+	for _, tc := range []struct{ time, want float64 }{
+		{0, 170}, {1, 170}, {1.25, 175}, {1.5, 180}, {2, 190}, {10, 190},
+	} {
+		if got := a.At(tc.time); math.Abs(got-tc.want) > 1e-12 {
+			t.Errorf("At(%.2f) = %.3f, want %.3f", tc.time, got, tc.want)
+		}
+	}
+
+	if _, err := encoder.NewAzimuthAutomation(nil); err == nil {
+		t.Error("NewAzimuthAutomation(nil) succeeded")
+	}
+	if _, err := encoder.NewAzimuthAutomation([]encoder.AzimuthPoint{{Time: -1}}); err == nil {
+		t.Error("NewAzimuthAutomation accepted a negative time")
+	}
+}
+
+// checkEncodedDirection checks that a tone in source reaches stereo with
+// the Lt/Rt amplitudes and phase relationship of azimuth in m. The FFT
+// encoder shifts its output by a constant offset, which turns both channels
+// by the same phase, so that common phase is removed before comparing.
+func checkEncodedDirection(t *testing.T, m matrix.Matrix, azimuth float64, source []float64, stereo [][]float64, freq, sampleRate float64) {
+	t.Helper()
+
+	ref := toneResponse(source, freq, sampleRate)
+	want := m.EncodeDirection(azimuth)
+	var got [2]complex128
+	var common complex128
+	for c := range got {
+		got[c] = toneResponse(stereo[c], freq, sampleRate) / ref
+		common += got[c] * want[c]
+	}
+	rotation := cmplx.Rect(1, -cmplx.Phase(common))
+	for c := range got {
+		if cmplx.Abs(got[c]*rotation-cmplx.Conj(want[c])) > 0.01 {
+			t.Errorf("%s %.1f°: channel %d = %.3f, want %.3f", m.Name, azimuth, c, got[c]*rotation, cmplx.Conj(want[c]))
+		}
+	}
+}
+
+func TestPositionEncoder_EncodesDirection(t *testing.T) {
+	t.Parallel()
+
+	const (
+		sampleRate = 44100
+		n          = 1 << 14
+		skip       = 2048
+		freq       = 1000.0
+	)
+
+	source := make([]float64, n)
+	for i := range source {
+		source[i] = 0.5 * math.Sin(2.0*math.Pi*freq*float64(i)/sampleRate)
+	}
+
+	for azimuth := -180.0; azimuth < 180; azimuth += 22.5 {
+		p, err := encoder.NewPositionEncoder(encoder.NewSQEncoder(), sampleRate,
+			[]encoder.AzimuthAutomation{encoder.ConstantAzimuth(azimuth)})
+		if err != nil {
+			t.Fatalf("NewPositionEncoder() error = %v", err)
+		}
+		stereo, err := p.Process([][]float64{source})
+		if err != nil {
+			t.Fatalf("Process() error = %v", err)
+		}
+
+		checkEncodedDirection(t, matrix.SQ, azimuth, source[skip:n-skip], [][]float64{stereo[0][skip : n-skip], stereo[1][skip : n-skip]}, freq, sampleRate)
+	}
+}
+
+func TestPositionEncoder_ProcessChunk_MatchesProcess(t *testing.T) {
+	t.Parallel()
+
+	const (
+		sampleRate = 8000
+		n          = 9000
+	)
+
+	sweep, err := encoder.NewAzimuthAutomation([]encoder.AzimuthPoint{{Time: 0, Azimuth: -180}, {Time: 1, Azimuth: 180}})
+	if err != nil {
+		t.Fatalf("NewAzimuthAutomation() error = %v", err)
+	}
+	sources := [][]float64{make([]float64, n), make([]float64, n)}
+	for i := 0; i < n; i++ {
+		sources[0][i] = math.Sin(float64(i) * 0.31)
+		sources[1][i] = math.Cos(float64(i) * 0.07)
+	}
+
+	p, err := encoder.NewPositionEncoder(encoder.NewSQEncoder(), sampleRate,
+		[]encoder.AzimuthAutomation{sweep, encoder.ConstantAzimuth(90)})
+	if err != nil {
+		t.Fatalf("NewPositionEncoder() error = %v", err)
+	}
+	want, err := p.Process(sources)
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+
+	got := [][]float64{nil, nil}
+	for start, size := 0, 3; start < n; start, size = start+size, size*5%1409+1 {
+		end := min(start+size, n)
+		out, err := p.ProcessChunk([][]float64{sources[0][start:end], sources[1][start:end]})
+		if err != nil {
+			t.Fatalf("ProcessChunk() error = %v", err)
+		}
+		for c := range got {
+			got[c] = append(got[c], out[c]...)
+		}
+	}
+	tail := p.Flush()
+	for c := range got {
+		got[c] = append(got[c], tail[c]...)
+		if len(got[c]) != n {
+			t.Fatalf("channel %d: got %d samples, want %d", c, len(got[c]), n)
+		}
+		for i := range got[c] {
+			if math.Abs(got[c][i]-want[c][i]) > 1e-12 {
+				t.Fatalf("channel %d sample %d: chunked %.15f, whole %.15f", c, i, got[c][i], want[c][i])
+			}
+		}
+	}
+}
@@ -6,6 +6,8 @@ import (
 
 	"github.com/cwbudde/go-sq-tool/internal/decoder"
 	"github.com/cwbudde/go-sq-tool/internal/encoder"
+	"github.com/cwbudde/go-sq-tool/internal/matrix"
+	"github.com/cwbudde/go-sq-tool/pkg/sqmath"
 )
 
 func TestEncodeDecodeRoundTrip_FrontChannels(t *testing.T) {
@@ -66,3 +68,108 @@ func TestEncodeDecodeRoundTrip_FrontChannels(t *testing.T) {
 		}
 	}
 }
+
+func TestEncodeDecodeRoundTrip_IIRRearChannels(t *testing.T) {
+	t.Parallel()
+
+	const (
+		sampleRate = 44100
+		n          = sampleRate
+		skip       = sampleRate / 2
+	)
+
+	for _, ch := range []int{2, 3} {
+		quad := make([][]float64, 4)
+		for i := range quad {
+			quad[i] = make([]float64, n)
+		}
+		for i := 0; i < n; i++ {
+			quad[ch][i] = 0.5 * math.Sin(2.0*math.Pi*1000.0*float64(i)/sampleRate)
+		}
+
+		sqEnc := encoder.NewSQEncoderIIR(sqmath.NiemitaloCoefficients)
+		if got := sqEnc.GetLatency(); got != 0 {
+			t.Fatalf("encoder latency = %d, want 0", got)
+		}
+		sqStereo, err := sqEnc.Process(quad)
+		if err != nil {
+			t.Fatalf("encoder.Process() error = %v", err)
+		}
+
+		sqDec := decoder.NewSQDecoderIIR(sqmath.NiemitaloCoefficients)
+		if got := sqDec.GetLatency(); got != 0 {
+			t.Fatalf("decoder latency = %d, want 0", got)
+		}
+		decoded, err := sqDec.Process(sqStereo)
+		if err != nil {
+			t.Fatalf("decoder.Process() error = %v", err)
+		}
+
+		// Output starts with the first input sample.
+		if decoded[ch][1] == 0 {
+			t.Fatalf("channel %d: no output at sample 1", ch)
+		}
+
+		var inEnergy, targetEnergy, oppositeEnergy float64
+		opposite := 5 - ch
+		for i := skip; i < n; i++ {
+			inEnergy += quad[ch][i] * quad[ch][i]
+			targetEnergy += decoded[ch][i] * decoded[ch][i]
+			oppositeEnergy += decoded[opposite][i] * decoded[opposite][i]
+		}
+
+		if ratio := targetEnergy / inEnergy; math.Abs(ratio-1) > 0.01 {
+			t.Fatalf("channel %d: energy ratio %.4f, want 1", ch, ratio)
+		}
+		// A rear source does not leak into the opposite rear channel.
+		if sep := 10 * math.Log10(targetEnergy/oppositeEnergy); sep < 40 {
+			t.Fatalf("channel %d: rear separation %.1f dB, want >= 40", ch, sep)
+		}
+	}
+}
+
+func TestEncodeDecodeRoundTrip_AllMatrices(t *testing.T) {
+	t.Parallel()
+
+	const (
+		sampleRate = 44100
+		n          = 16384
+		skip       = 2048
+	)
+
+	for _, m := range matrix.All() {
+		for ch := 0; ch < 4; ch++ {
+			quad := make([][]float64, 4)
+			for i := range quad {
+				quad[i] = make([]float64, n)
+			}
+			for i := 0; i < n; i++ {
+				quad[ch][i] = 0.5 * math.Sin(2.0*math.Pi*1000.0*float64(i)/sampleRate)
+			}
+
+			sqEnc := encoder.NewSQEncoder()
+			sqEnc.SetMatrix(m)
+			stereo, err := sqEnc.Process(quad)
+			if err != nil {
+				t.Fatalf("%s: encoder.Process() error = %v", m.Name, err)
+			}
+
+			sqDec := decoder.NewSQDecoder()
+			sqDec.SetMatrix(m)
+			decoded, err := sqDec.Process(stereo)
+			if err != nil {
+				t.Fatalf("%s: decoder.Process() error = %v", m.Name, err)
+			}
+
+			// The passive decoders reproduce every channel at unity gain.
+			var inEnergy, outEnergy float64
+			for i := skip; i < n-skip; i++ {
+				inEnergy += quad[ch][i] * quad[ch][i]
+				outEnergy += decoded[ch][i] * decoded[ch][i]
+			}
+			if ratio := outEnergy / inEnergy; math.Abs(ratio-1) > 0.01 {
+				t.Fatalf("%s: channel %d energy ratio %.4f, want 1", m.Name, ch, ratio)
+			}
+		}
+	}
+}
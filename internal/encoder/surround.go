@@ -0,0 +1,140 @@
+package encoder
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/cwbudde/go-sq-tool/pkg/sqmath"
+)
+
+// SurroundConfig defines how a 5.1 signal is folded into the matrix.
+type SurroundConfig struct {
+	// FrontAzimuth places L and R at ±FrontAzimuth degrees. The default
+	// of 45 puts them on the LF/RF corners, which keeps the full front
+	// separation on two-channel playback; 30 is their ITU-R BS.775 angle.
+	FrontAzimuth float64
+	// SurroundAzimuth places Ls and Rs at ±SurroundAzimuth degrees
+	// (ITU-R BS.775: 110).
+	SurroundAzimuth float64
+	// LFEGain is the gain of the LFE channel in dB before it is folded into
+	// the front centre. +10 restores its playback calibration.
+	LFEGain float64
+	// LFECutoff is the LFE lowpass frequency in Hz (0 disables it).
+	LFECutoff float64
+}
+
+// DefaultSurroundConfig returns 5.1 fold-down defaults.
+func DefaultSurroundConfig() SurroundConfig {
+	return SurroundConfig{
+		FrontAzimuth:    45,
+		SurroundAzimuth: 110,
+		LFEGain:         10,
+		LFECutoff:       120,
+	}
+}
+
+// SurroundEncoder encodes 5.1 (L, R, C, LFE, Ls, Rs) to matrix stereo. Every
+// main channel is position-encoded at its own direction: C at the front
+// centre (-3 dB in phase on Lt and Rt for SQ), L/R and Ls/Rs at their
+// configured angles rather than on the quad corners. The LFE is lowpassed
+// and folded into the front centre with the C channel.
+type SurroundEncoder struct {
+	position *PositionEncoder
+	lfe      *sqmath.LinkwitzRiley4
+	lfeGain  float64
+}
+
+// NewSurroundEncoder creates a 5.1 encoder that encodes with sqEncoder at
+// sampleRate Hz.
+func NewSurroundEncoder(sqEncoder *SQEncoder, sampleRate int, config SurroundConfig) (*SurroundEncoder, error) {
+	if config.LFECutoff < 0 || config.LFECutoff >= float64(sampleRate)/2 {
+		return nil, fmt.Errorf("LFE cutoff must be in [0, %d) Hz", sampleRate/2)
+	}
+
+	// Sources in the order L, R, C+LFE, Ls, Rs.
+	azimuths := []AzimuthAutomation{
+		ConstantAzimuth(config.FrontAzimuth),
+		ConstantAzimuth(-config.FrontAzimuth),
+		ConstantAzimuth(0),
+		ConstantAzimuth(config.SurroundAzimuth),
+		ConstantAzimuth(-config.SurroundAzimuth),
+	}
+	position, err := NewPositionEncoder(sqEncoder, sampleRate, azimuths)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &SurroundEncoder{
+		position: position,
+		lfeGain:  math.Pow(10, config.LFEGain/20.0),
+	}
+	if config.LFECutoff > 0 {
+		e.lfe = sqmath.NewLinkwitzRiley4(config.LFECutoff, float64(sampleRate))
+	}
+	return e, nil
+}
+
+// Process encodes a complete 5.1 signal to stereo.
+// Input: [6][numSamples] - L, R, C, LFE, Ls, Rs
+// Output: [2][numSamples] - LT, RT
+// Any streaming state is discarded.
+func (e *SurroundEncoder) Process(input [][]float64) ([][]float64, error) {
+	e.Reset()
+	output, err := e.ProcessChunk(input)
+	if err != nil {
+		return nil, err
+	}
+
+	tail := e.Flush()
+	for ch := range output {
+		output[ch] = append(output[ch], tail[ch]...)
+	}
+	return output, nil
+}
+
+// ProcessChunk encodes an arbitrary-sized chunk of a continuous stream.
+// The concatenation of all ProcessChunk outputs followed by Flush is
+// identical to a single Process call on the whole stream.
+func (e *SurroundEncoder) ProcessChunk(input [][]float64) ([][]float64, error) {
+	if len(input) != 6 {
+		return nil, fmt.Errorf("input must have 6 channels, got %d", len(input))
+	}
+	numSamples := len(input[0])
+	for i := 1; i < 6; i++ {
+		if len(input[i]) != numSamples {
+			return nil, fmt.Errorf("input channels must have same length")
+		}
+	}
+
+	centre := make([]float64, numSamples)
+	for i := range centre {
+		lfe := input[3][i]
+		if e.lfe != nil {
+			lfe, _ = e.lfe.Process(lfe)
+		}
+		centre[i] = input[2][i] + e.lfeGain*lfe
+	}
+	return e.position.ProcessChunk([][]float64{input[0], input[1], centre, input[4], input[5]})
+}
+
+// Flush encodes the samples still buffered by ProcessChunk.
+// The encoder is ready for a new stream afterwards.
+func (e *SurroundEncoder) Flush() [][]float64 {
+	if e.lfe != nil {
+		e.lfe.Reset()
+	}
+	return e.position.Flush()
+}
+
+// Reset discards any buffered input and filter state.
+func (e *SurroundEncoder) Reset() {
+	if e.lfe != nil {
+		e.lfe.Reset()
+	}
+	e.position.Reset()
+}
+
+// GetLatency returns the encoder latency in samples.
+func (e *SurroundEncoder) GetLatency() int {
+	return e.position.GetLatency()
+}
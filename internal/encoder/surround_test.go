@@ -0,0 +1,79 @@
+package encoder_test
+
+import (
+	"math"
+	"math/cmplx"
+	"testing"
+
+	"github.com/cwbudde/go-sq-tool/internal/encoder"
+	"github.com/cwbudde/go-sq-tool/internal/matrix"
+)
+
+// encodeSurroundTone encodes a tone on one 5.1 channel and returns the
+// tone and the steady-state stereo output.
+func encodeSurroundTone(t *testing.T, ch int, freq float64) ([]float64, [][]float64) {
+	t.Helper()
+
+	const (
+		sampleRate = 44100
+		n          = 1 << 14
+		skip       = 4096
+	)
+	input := make([][]float64, 6)
+	for i := range input {
+		input[i] = make([]float64, n)
+	}
+	for i := range input[ch] {
+		input[ch][i] = 0.25 * math.Sin(2.0*math.Pi*freq*float64(i)/sampleRate)
+	}
+
+	e, err := encoder.NewSurroundEncoder(encoder.NewSQEncoder(), sampleRate, encoder.DefaultSurroundConfig())
+	if err != nil {
+		t.Fatalf("NewSurroundEncoder() error = %v", err)
+	}
+	stereo, err := e.Process(input)
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	return input[ch][skip : n-skip], [][]float64{stereo[0][skip : n-skip], stereo[1][skip : n-skip]}
+}
+
+func TestSurroundEncoder_PlacesChannelsAtTheirAngles(t *testing.T) {
+	t.Parallel()
+
+	config := encoder.DefaultSurroundConfig()
+	for _, tc := range []struct {
+		ch      int
+		azimuth float64
+	}{
+		{0, config.FrontAzimuth},
+		{1, -config.FrontAzimuth},
+		{2, 0},
+		{4, config.SurroundAzimuth},
+		{5, -config.SurroundAzimuth},
+	} {
+		source, stereo := encodeSurroundTone(t, tc.ch, 1000)
+		checkEncodedDirection(t, matrix.SQ, tc.azimuth, source, stereo, 1000, 44100)
+	}
+}
+
+func TestSurroundEncoder_FoldsLFEIntoCentre(t *testing.T) {
+	t.Parallel()
+
+	// Below the cutoff the LFE reaches Lt and Rt in phase at +10 dB - 3 dB.
+	source, stereo := encodeSurroundTone(t, 3, 40)
+	ref := toneResponse(source, 40, 44100)
+	lt := toneResponse(stereo[0], 40, 44100) / ref
+	rt := toneResponse(stereo[1], 40, 44100) / ref
+	want := math.Pow(10, 10.0/20) * math.Sqrt2 / 2
+	if math.Abs(cmplx.Abs(lt)-want) > 0.05*want || cmplx.Abs(lt-rt) > 1e-3 {
+		t.Errorf("40 Hz LFE: Lt %.3f, Rt %.3f, want %.3f in phase", lt, rt, want)
+	}
+
+	// Above it the LFE is filtered out.
+	source, stereo = encodeSurroundTone(t, 3, 2000)
+	ref = toneResponse(source, 2000, 44100)
+	if gain := cmplx.Abs(toneResponse(stereo[0], 2000, 44100) / ref); gain > 0.01 {
+		t.Errorf("2 kHz LFE reaches Lt with gain %.4f", gain)
+	}
+}
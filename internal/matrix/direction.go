@@ -0,0 +1,41 @@
+package matrix
+
+import "math"
+
+// SpeakerAzimuths are the azimuths of LF, RF, LB, RB in degrees
+// (0 = front centre, positive counterclockwise towards the left).
+var SpeakerAzimuths = [4]float64{45, -45, 135, -135}
+
+// PanGains returns constant-power pairwise panning gains for LF, RF, LB, RB
+// that place a source at azimuth degrees.
+func PanGains(azimuth float64) [4]float64 {
+	// Speakers counterclockwise from LF.
+	order := [4]int{0, 2, 3, 1}
+	pos := math.Mod(azimuth-SpeakerAzimuths[0], 360.0)
+	if pos < 0 {
+		pos += 360.0
+	}
+
+	segment := int(pos / 90.0)
+	if segment > 3 {
+		segment = 3
+	}
+	phi := (pos - float64(segment)*90.0) / 90.0 * math.Pi / 2.0
+
+	var gains [4]float64
+	gains[order[segment]] = math.Cos(phi)
+	gains[order[(segment+1)%4]] = math.Sin(phi)
+	return gains
+}
+
+// EncodeDirection returns the (Lt, Rt) coefficients of a source panned to
+// azimuth degrees and encoded with m.
+func (m Matrix) EncodeDirection(azimuth float64) [2]complex128 {
+	gains := PanGains(azimuth)
+	var vector [2]complex128
+	for ch := 0; ch < 4; ch++ {
+		vector[0] += complex(gains[ch], 0) * m.Encode[ch][0]
+		vector[1] += complex(gains[ch], 0) * m.Encode[ch][1]
+	}
+	return vector
+}
@@ -0,0 +1,38 @@
+package matrix_test
+
+import (
+	"math"
+	"math/cmplx"
+	"testing"
+
+	"github.com/cwbudde/go-sq-tool/internal/matrix"
+)
+
+func TestEncodeDirection_MatchesSpeakers(t *testing.T) {
+	t.Parallel()
+
+	for _, m := range matrix.All() {
+		for ch, azimuth := range matrix.SpeakerAzimuths {
+			got := m.EncodeDirection(azimuth)
+			for i := range got {
+				if cmplx.Abs(got[i]-m.Encode[ch][i]) > 1e-12 {
+					t.Fatalf("%s: direction %.0f° encodes as %v, want %v", m.Name, azimuth, got, m.Encode[ch])
+				}
+			}
+		}
+	}
+}
+
+func TestPanGains_ConstantPower(t *testing.T) {
+	t.Parallel()
+
+	for azimuth := -180.0; azimuth < 180; azimuth += 7.5 {
+		power := 0.0
+		for _, g := range matrix.PanGains(azimuth) {
+			power += g * g
+		}
+		if math.Abs(power-1) > 1e-12 {
+			t.Fatalf("PanGains(%.1f) power = %.6f, want 1", azimuth, power)
+		}
+	}
+}
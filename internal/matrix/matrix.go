@@ -0,0 +1,176 @@
+// Package matrix describes the 4-2-4 matrix systems supported by the
+// encoder and decoder.
+//
+// Coefficients are complex numbers acting on a signal x as
+// real(c)*x + imag(c)*H(x), where H is the 90° Hilbert transform used by
+// the encoder and decoder. Multiplying by i is therefore the "j" phase
+// shift of the published matrix equations.
+package matrix
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+	"sort"
+	"strings"
+)
+
+// Matrix describes the encoder and passive decoder of a matrix system.
+type Matrix struct {
+	// Name is the registry key (lower case, e.g. "sq").
+	Name string
+	// Description is a human-readable name of the system.
+	Description string
+	// Encode holds the (Lt, Rt) coefficients of LF, RF, LB, RB.
+	Encode [4][2]complex128
+	// Decode holds the passive decoder rows: the (Lt, Rt) coefficients of
+	// LF, RF, LB, RB.
+	Decode [4][2]complex128
+}
+
+// HasPhaseShift reports whether encoding channel ch needs its Hilbert transform.
+func (m Matrix) HasPhaseShift(ch int) bool {
+	return imag(m.Encode[ch][0]) != 0 || imag(m.Encode[ch][1]) != 0
+}
+
+var k = math.Sqrt(2.0) / 2.0
+
+// cos/sin of 22.5° used by the Regular Matrix family.
+var (
+	rm1 = math.Cos(math.Pi / 8.0)
+	rm2 = math.Sin(math.Pi / 8.0)
+)
+
+// SQ is the CBS SQ (Stereo Quadraphonic) matrix:
+// Lt = LF + k*RB - jk*LB, Rt = RF - k*LB + jk*RB with k = sqrt(2)/2.
+var SQ = Matrix{
+	Name:        "sq",
+	Description: "CBS SQ",
+	Encode: [4][2]complex128{
+		{1, 0},
+		{0, 1},
+		{complex(0, -k), complex(-k, 0)},
+		{complex(k, 0), complex(0, k)},
+	},
+	Decode: [4][2]complex128{
+		{1, 0},
+		{0, 1},
+		{complex(0, k), complex(-k, 0)},
+		{complex(k, 0), complex(0, -k)},
+	},
+}
+
+// QS is the Sansui QS Regular Matrix (RM):
+// Lt = 0.924*LF + 0.383*RF + j0.924*LB + j0.383*RB,
+// Rt = 0.383*LF + 0.924*RF - j0.383*LB - j0.924*RB.
+// The coefficients are cos/sin 22.5°, i.e. the 1 : 0.414 ratio of the QS
+// row in the 4:2:4 coefficient table of the Wikipedia article "Matrix
+// decoder", scaled to unity power per channel.
+var QS = withMatchedDecoder(Matrix{
+	Name:        "qs",
+	Description: "Sansui QS Regular Matrix",
+	Encode: [4][2]complex128{
+		{complex(rm1, 0), complex(rm2, 0)},
+		{complex(rm2, 0), complex(rm1, 0)},
+		{complex(0, rm1), complex(0, -rm2)},
+		{complex(0, rm2), complex(0, -rm1)},
+	},
+})
+
+// EV4 is the Electro-Voice Stereo-4 (EV-4) matrix:
+// Lt = LF + 0.3*RF + LB - 0.5*RB, Rt = 0.3*LF + RF - 0.5*LB + RB.
+// The coefficients are the EV-4 row of the 4:2:4 coefficient table of the
+// Wikipedia article "Matrix decoder"; no Electro-Voice document was
+// available to cross-check them.
+var EV4 = withMatchedDecoder(Matrix{
+	Name:        "ev4",
+	Description: "Electro-Voice Stereo-4",
+	Encode: [4][2]complex128{
+		{1, 0.3},
+		{0.3, 1},
+		{1, -0.5},
+		{-0.5, 1},
+	},
+})
+
+// UHJ is 2-channel Ambisonic UHJ with the four channels encoded as sources
+// at the quad speaker azimuths:
+// S = 0.9397*W + 0.1856*X, D = j(-0.3420*W + 0.5099*X) + 0.6555*Y,
+// Lt = (S + D)/2, Rt = (S - D)/2.
+// These are the 2-channel UHJ encoding equations of M. A. Gerzon,
+// "Ambisonics in Multichannel Broadcasting and Video", J. Audio Eng. Soc.
+// 33(11), 1985, with W at -3 dB.
+var UHJ = withMatchedDecoder(Matrix{
+	Name:        "uhj",
+	Description: "Ambisonic UHJ (2-channel)",
+	Encode: [4][2]complex128{
+		UHJEncode(45),
+		UHJEncode(-45),
+		UHJEncode(135),
+		UHJEncode(-135),
+	},
+})
+
+// UHJEncode returns the (Lt, Rt) coefficients of a source at azimuth
+// degrees (0 = front, positive towards the left) in 2-channel UHJ.
+func UHJEncode(azimuth float64) [2]complex128 {
+	theta := azimuth * math.Pi / 180.0
+	w := k
+	x := math.Cos(theta)
+	y := math.Sin(theta)
+
+	s := complex(0.9397*w+0.1856*x, 0)
+	d := complex(0.6555*y, -0.3420*w+0.5099*x)
+	return [2]complex128{0.5 * (s + d), 0.5 * (s - d)}
+}
+
+// withMatchedDecoder sets the passive decoder of m to the matched decoder
+// of its encoder: every row is the conjugate of the channel's encoding
+// vector scaled to unity gain for that channel. For SQ and QS this is the
+// published passive decoder.
+func withMatchedDecoder(m Matrix) Matrix {
+	for ch, e := range m.Encode {
+		norm := real(e[0]*cmplx.Conj(e[0]) + e[1]*cmplx.Conj(e[1]))
+		m.Decode[ch] = [2]complex128{
+			cmplx.Conj(e[0]) / complex(norm, 0),
+			cmplx.Conj(e[1]) / complex(norm, 0),
+		}
+	}
+	return m
+}
+
+var registry = map[string]Matrix{
+	SQ.Name:  SQ,
+	QS.Name:  QS,
+	EV4.Name: EV4,
+	UHJ.Name: UHJ,
+}
+
+// Lookup returns the registered matrix with the given name (case-insensitive).
+func Lookup(name string) (Matrix, error) {
+	m, ok := registry[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return Matrix{}, fmt.Errorf("unknown matrix %q (use %s)", name, strings.Join(Names(), ", "))
+	}
+	return m, nil
+}
+
+// Names returns the names of all registered matrices in sorted order.
+func Names() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// All returns all registered matrices sorted by name.
+func All() []Matrix {
+	names := Names()
+	matrices := make([]Matrix, len(names))
+	for i, name := range names {
+		matrices[i] = registry[name]
+	}
+	return matrices
+}
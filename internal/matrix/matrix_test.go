@@ -0,0 +1,115 @@
+package matrix_test
+
+import (
+	"math/cmplx"
+	"testing"
+
+	"github.com/cwbudde/go-sq-tool/internal/matrix"
+)
+
+func TestLookup(t *testing.T) {
+	t.Parallel()
+
+	for _, name := range []string{"sq", "QS", " ev4 ", "uhj"} {
+		m, err := matrix.Lookup(name)
+		if err != nil {
+			t.Fatalf("Lookup(%q) error = %v", name, err)
+		}
+		if m.Description == "" {
+			t.Fatalf("Lookup(%q) returned matrix without description", name)
+		}
+	}
+
+	if _, err := matrix.Lookup("cd4"); err == nil {
+		t.Fatalf("Lookup(cd4) succeeded, want error")
+	}
+	if got := len(matrix.All()); got != len(matrix.Names()) {
+		t.Fatalf("len(All()) = %d, want %d", got, len(matrix.Names()))
+	}
+}
+
+func TestPassiveDecoderHasUnityGain(t *testing.T) {
+	t.Parallel()
+
+	for _, m := range matrix.All() {
+		for ch := 0; ch < 4; ch++ {
+			row := m.Decode[ch]
+			e := m.Encode[ch]
+			if gain := row[0]*e[0] + row[1]*e[1]; cmplx.Abs(gain-1) > 1e-12 {
+				t.Fatalf("%s: channel %d decodes with gain %v, want 1", m.Name, ch, gain)
+			}
+		}
+	}
+}
+
+func TestSQ_MatchesPublishedEquations(t *testing.T) {
+	t.Parallel()
+
+	// SQ rear channels are orthogonal in (Lt, Rt): a left-back source does
+	// not reach the right-back output and vice versa.
+	lb := matrix.SQ.Encode[2]
+	rb := matrix.SQ.Encode[3]
+	if leak := matrix.SQ.Decode[3][0]*lb[0] + matrix.SQ.Decode[3][1]*lb[1]; cmplx.Abs(leak) > 1e-12 {
+		t.Fatalf("LB leaks into RB with %v", leak)
+	}
+	if leak := matrix.SQ.Decode[2][0]*rb[0] + matrix.SQ.Decode[2][1]*rb[1]; cmplx.Abs(leak) > 1e-12 {
+		t.Fatalf("RB leaks into LB with %v", leak)
+	}
+
+	if matrix.SQ.HasPhaseShift(0) || matrix.SQ.HasPhaseShift(1) {
+		t.Fatalf("SQ front channels should not need a phase shift")
+	}
+	if !matrix.SQ.HasPhaseShift(2) || !matrix.SQ.HasPhaseShift(3) {
+		t.Fatalf("SQ back channels should need a phase shift")
+	}
+}
+
+func TestMatrices_KnownAnswers(t *testing.T) {
+	t.Parallel()
+
+	// encode holds the published coefficients of LF, RF, LB, RB (see the
+	// matrix doc comments); decodedLF is the passive decoder output of LF,
+	// RF, LB, RB for a left-front source.
+	for _, tc := range []struct {
+		m         matrix.Matrix
+		encode    [4][2]complex128
+		decodedLF [4]complex128
+	}{
+		{
+			matrix.QS,
+			[4][2]complex128{{0.924, 0.383}, {0.383, 0.924}, {0.924i, -0.383i}, {0.383i, -0.924i}},
+			[4]complex128{1, 0.7071, -0.7071i, 0},
+		},
+		{
+			matrix.EV4,
+			[4][2]complex128{{1, 0.3}, {0.3, 1}, {1, -0.5}, {-0.5, 1}},
+			[4]complex128{1, 0.5505, 0.68, -0.16},
+		},
+		{
+			matrix.UHJ,
+			// Lt = (S + D)/2, Rt = (S - D)/2 with W = 0.7071, X = cos, Y = sin.
+			[4][2]complex128{
+				{0.6296 + 0.0594i, 0.1661 - 0.0594i},
+				{0.1661 + 0.0594i, 0.6296 - 0.0594i},
+				{0.4984 - 0.3012i, 0.0349 + 0.3012i},
+				{0.0349 - 0.3012i, 0.4984 + 0.3012i},
+			},
+			[4]complex128{1, 0.5016 - 0.1277i, 0.6585 + 0.3877i, 0.1600 + 0.2601i},
+		},
+	} {
+		for ch := range tc.encode {
+			for i := range tc.encode[ch] {
+				if got := tc.m.Encode[ch][i]; cmplx.Abs(got-tc.encode[ch][i]) > 5e-4 {
+					t.Errorf("%s: Encode[%d][%d] = %.4f, want %.4f", tc.m.Name, ch, i, got, tc.encode[ch][i])
+				}
+			}
+		}
+		lf := tc.m.Encode[0]
+		for ch, want := range tc.decodedLF {
+			row := tc.m.Decode[ch]
+			if got := row[0]*lf[0] + row[1]*lf[1]; cmplx.Abs(got-want) > 5e-4 {
+				t.Errorf("%s: left-front source decodes to %.4f in channel %d, want %.4f", tc.m.Name, got, ch, want)
+			}
+		}
+	}
+}
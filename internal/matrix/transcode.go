@@ -0,0 +1,51 @@
+package matrix
+
+import "math/cmplx"
+
+// RoundTrip returns the (Lt, Rt) matrix of decoding with the passive decoder
+// of m and encoding the four decoded channels with m again. Row i holds the
+// (Lt, Rt) coefficients of output i. The unity-gain passive decoders of all
+// registered systems make its trace 4: SQ and QS double the level.
+func (m Matrix) RoundTrip() [2][2]complex128 {
+	return m.bounce(m)
+}
+
+// Transcode returns the passive (Lt, Rt) matrix from m to target: the
+// passive decode of m encoded with target, normalized by the round trip of
+// m so that transcoding a system to itself is the identity. Row i holds the
+// (Lt, Rt) coefficients of output i.
+func (m Matrix) Transcode(target Matrix) [2][2]complex128 {
+	b := m.bounce(target)
+	rt := m.RoundTrip()
+
+	det := rt[0][0]*rt[1][1] - rt[0][1]*rt[1][0]
+	if cmplx.Abs(det) < 1e-12 {
+		return b
+	}
+	inv := [2][2]complex128{
+		{rt[1][1] / det, -rt[0][1] / det},
+		{-rt[1][0] / det, rt[0][0] / det},
+	}
+
+	var t [2][2]complex128
+	for i := 0; i < 2; i++ {
+		for j := 0; j < 2; j++ {
+			t[i][j] = b[i][0]*inv[0][j] + b[i][1]*inv[1][j]
+		}
+	}
+	return t
+}
+
+// bounce returns the (Lt, Rt) matrix of the passive decoder of m followed by
+// the encoder of target.
+func (m Matrix) bounce(target Matrix) [2][2]complex128 {
+	var b [2][2]complex128
+	for ch := 0; ch < 4; ch++ {
+		for i := 0; i < 2; i++ {
+			for j := 0; j < 2; j++ {
+				b[i][j] += target.Encode[ch][i] * m.Decode[ch][j]
+			}
+		}
+	}
+	return b
+}
@@ -0,0 +1,41 @@
+package matrix_test
+
+import (
+	"math/cmplx"
+	"testing"
+
+	"github.com/cwbudde/go-sq-tool/internal/matrix"
+)
+
+func TestRoundTrip_SQDoublesLevel(t *testing.T) {
+	t.Parallel()
+
+	want := [2][2]complex128{{2, 0}, {0, 2}}
+	got := matrix.SQ.RoundTrip()
+	for i := range got {
+		for j := range got[i] {
+			if cmplx.Abs(got[i][j]-want[i][j]) > 1e-12 {
+				t.Fatalf("SQ round trip = %v, want %v", got, want)
+			}
+		}
+	}
+}
+
+func TestTranscode_ToSelfIsIdentity(t *testing.T) {
+	t.Parallel()
+
+	for _, m := range matrix.All() {
+		got := m.Transcode(m)
+		for i := range got {
+			for j := range got[i] {
+				want := complex128(0)
+				if i == j {
+					want = 1
+				}
+				if cmplx.Abs(got[i][j]-want) > 1e-12 {
+					t.Fatalf("%s: transcode to itself = %v, want identity", m.Name, got)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package wav
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// ClipMode selects how Writer limits samples beyond full scale.
+type ClipMode int
+
+const (
+	// ClipNone writes samples as they are: float formats keep overs, integer
+	// formats saturate at full scale.
+	ClipNone ClipMode = iota
+	// ClipHard limits samples to [-1, 1].
+	ClipHard
+	// ClipSoft leaves samples below the knee untouched and bends the rest
+	// smoothly toward full scale with a tanh curve.
+	ClipSoft
+)
+
+var clipNames = map[ClipMode]string{
+	ClipNone: "none",
+	ClipHard: "hard",
+	ClipSoft: "soft",
+}
+
+// softClipKnee is the level (-1 dBFS) above which ClipSoft starts to bend
+// the signal.
+const softClipKnee = 0.891
+
+// LookupClip returns the clip mode with the given name (see ClipNames).
+func LookupClip(name string) (ClipMode, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for mode, modeName := range clipNames {
+		if modeName == name {
+			return mode, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown clip mode %q (use %s)", name, strings.Join(ClipNames(), ", "))
+}
+
+// ClipNames returns the names of all clip modes.
+func ClipNames() []string {
+	modes := make([]ClipMode, 0, len(clipNames))
+	for mode := range clipNames {
+		modes = append(modes, mode)
+	}
+	sort.Slice(modes, func(i, j int) bool { return modes[i] < modes[j] })
+	names := make([]string, len(modes))
+	for i, mode := range modes {
+		names[i] = clipNames[mode]
+	}
+	return names
+}
+
+// String returns the name of the clip mode.
+func (m ClipMode) String() string {
+	return clipNames[m]
+}
+
+// ClipSamples applies the clip mode in place to samples ([channel][sample]),
+// for callers that write whole buffers instead of going through a Writer.
+func ClipSamples(samples [][]float64, mode ClipMode) {
+	if mode == ClipNone {
+		return
+	}
+	for _, channel := range samples {
+		for i, v := range channel {
+			channel[i] = clipSample(v, mode)
+		}
+	}
+}
+
+// clipSample applies the clip mode to v.
+func clipSample(v float64, mode ClipMode) float64 {
+	switch mode {
+	case ClipHard:
+		return math.Max(-1.0, math.Min(1.0, v))
+	case ClipSoft:
+		a := math.Abs(v)
+		if a <= softClipKnee {
+			return v
+		}
+		// tanh has unit slope at 0, so the curve joins the linear part
+		// smoothly and approaches 1 asymptotically.
+		const headroom = 1.0 - softClipKnee
+		return math.Copysign(softClipKnee+headroom*math.Tanh((a-softClipKnee)/headroom), v)
+	default:
+		return v
+	}
+}
@@ -0,0 +1,154 @@
+package wav
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestClipSample(t *testing.T) {
+	t.Parallel()
+
+	for _, v := range []float64{0, 0.5, -0.8, softClipKnee, -softClipKnee} {
+		if got := clipSample(v, ClipSoft); got != v {
+			t.Errorf("soft clip(%g) = %g, want it untouched below the knee", v, got)
+		}
+	}
+	prev := softClipKnee
+	for v := softClipKnee + 0.01; v < 10; v += 0.01 {
+		got := clipSample(v, ClipSoft)
+		if got < prev || got > 1 {
+			t.Fatalf("soft clip(%g) = %g, want non-decreasing and at most 1", v, got)
+		}
+		if neg := clipSample(-v, ClipSoft); neg != -got {
+			t.Fatalf("soft clip(%g) = %g, want %g", -v, neg, -got)
+		}
+		prev = got
+	}
+	if got := clipSample(1.5, ClipHard); got != 1 {
+		t.Errorf("hard clip(1.5) = %g, want 1", got)
+	}
+	if got := clipSample(-1.5, ClipNone); got != -1.5 {
+		t.Errorf("no clip(-1.5) = %g, want -1.5", got)
+	}
+
+	for _, name := range ClipNames() {
+		if mode, err := LookupClip(name); err != nil || mode.String() != name {
+			t.Errorf("LookupClip(%q) = %v, %v", name, mode, err)
+		}
+	}
+	if _, err := LookupClip("brickwall"); err == nil {
+		t.Error("LookupClip(brickwall) error = nil")
+	}
+}
+
+// writeOvers writes samples with overs through a Writer and reads them back.
+func writeOvers(t *testing.T, format SampleFormat, clip ClipMode) ([]float64, *Writer, *Reader) {
+	t.Helper()
+
+	samples := []float64{0.5, 2, -1.5, 1}
+	filename := filepath.Join(t.TempDir(), "overs.wav")
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	w, err := NewWriter(file, 48000, 1, format)
+	if err != nil {
+		t.Fatalf("NewWriter() error = %v", err)
+	}
+	w.SetClip(clip)
+	if err := w.WriteFrames([][]float64{samples}); err != nil {
+		t.Fatalf("WriteFrames() error = %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatalf("file.Close() error = %v", err)
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	r, err := NewReader(bytes.NewReader(data), 1)
+	if err != nil {
+		t.Fatalf("NewReader() error = %v", err)
+	}
+	got := [][]float64{make([]float64, len(samples))}
+	if _, err := r.ReadFrames(got); err != nil && !errors.Is(err, io.EOF) {
+		t.Fatalf("ReadFrames() error = %v", err)
+	}
+	return got[0], w, r
+}
+
+func TestWriter_KeepsFloatOversUnlessClipped(t *testing.T) {
+	t.Parallel()
+
+	got, w, r := writeOvers(t, FormatFloat32, ClipNone)
+	for i, want := range []float64{0.5, 2, -1.5, 1} {
+		if got[i] != want {
+			t.Errorf("float32 sample %d = %g, want %g", i, got[i], want)
+		}
+	}
+	if w.Overs() != 2 || w.Peak() != 2 {
+		t.Errorf("Writer overs %d, peak %g, want 2, 2", w.Overs(), w.Peak())
+	}
+	if r.Overs() != 2 || r.Peak() != 2 {
+		t.Errorf("Reader overs %d, peak %g, want 2, 2", r.Overs(), r.Peak())
+	}
+
+	got, w, r = writeOvers(t, FormatFloat32, ClipHard)
+	if got[1] != 1 || got[2] != -1 || r.Overs() != 0 {
+		t.Errorf("hard clip: samples %v, %d overs read", got, r.Overs())
+	}
+	if w.Overs() != 2 {
+		t.Errorf("hard clip: Writer overs %d, want the 2 before clipping", w.Overs())
+	}
+
+	got, _, _ = writeOvers(t, FormatFloat32, ClipSoft)
+	if got[0] != 0.5 || got[1] > 1 || got[1] <= softClipKnee || got[2] < -1 || got[3] >= 1 {
+		t.Errorf("soft clip: samples %v", got)
+	}
+
+	// Integer formats saturate.
+	got, _, _ = writeOvers(t, FormatPCM24, ClipNone)
+	if math.Abs(got[1]-1) > 1e-6 || got[2] != -1 {
+		t.Errorf("pcm24: samples %v, want saturated at full scale", got)
+	}
+}
+
+func TestReadWAVBytes_CountsOvers(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	data := &AudioData{SampleRate: 48000, Samples: [][]float64{{0.5, 2}, {-1.5, 1}, {0, 0}, {0, 0}}, NumSamples: 2}
+	if err := WriteFloat32WAVToWriter(&buf, data); err != nil {
+		t.Fatalf("WriteFloat32WAVToWriter() error = %v", err)
+	}
+	got, err := ReadWAVBytes(buf.Bytes(), 4)
+	if err != nil {
+		t.Fatalf("ReadWAVBytes() error = %v", err)
+	}
+	if got.Overs() != 2 || got.Peak() != 2 {
+		t.Errorf("overs %d, peak %g, want 2, 2", got.Overs(), got.Peak())
+	}
+}
+
+func TestClipSamples(t *testing.T) {
+	t.Parallel()
+
+	samples := [][]float64{{0.5, 2}, {-1.5, 0.9}}
+	ClipSamples(samples, ClipNone)
+	if samples[0][1] != 2 || samples[1][0] != -1.5 {
+		t.Errorf("no clip changed the samples: %v", samples)
+	}
+	ClipSamples(samples, ClipHard)
+	if samples[0][0] != 0.5 || samples[0][1] != 1 || samples[1][0] != -1 || samples[1][1] != 0.9 {
+		t.Errorf("hard clip: samples %v", samples)
+	}
+}
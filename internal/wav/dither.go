@@ -0,0 +1,150 @@
+package wav
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"sort"
+	"strings"
+)
+
+// DitherMode selects how samples are requantized to integer PCM.
+type DitherMode int
+
+const (
+	// DitherNone rounds to the nearest integer; quiet signals get truncation
+	// distortion.
+	DitherNone DitherMode = iota
+	// DitherFlat adds triangular (TPDF) dither of ±1 LSB, which turns the
+	// requantization error into signal-independent white noise.
+	DitherFlat
+	// DitherFWeighted adds TPDF dither and shapes the noise with the 9th-order
+	// F-weighted error-feedback filter of Wannamaker: 22 dB less noise in
+	// 2-5 kHz, where the ear is most sensitive, for 18 dB more total noise,
+	// almost all of it above 15 kHz (26 dB more at 20 kHz).
+	DitherFWeighted
+	// DitherHighOrder adds TPDF dither and shapes the noise with the more
+	// aggressive 9th-order improved E-weighted filter: 24 dB less noise in
+	// 2-5 kHz for 23 dB more total noise (30 dB more at 20 kHz).
+	DitherHighOrder
+)
+
+// noiseShapingFilters holds the error-feedback coefficients of the shaped
+// dither modes, designed for 44.1 kHz. The noise transfer function is
+// 1 - sum(h[i] z^-(i+1)); at other sample rates the curve scales with the
+// rate.
+var noiseShapingFilters = map[DitherMode][]float64{
+	DitherFWeighted: {2.412, -3.370, 3.937, -4.174, 3.353, -2.205, 1.281, -0.569, 0.0847},
+	DitherHighOrder: {2.847, -4.685, 6.214, -7.184, 6.639, -5.032, 3.263, -1.632, 0.4191},
+}
+
+var ditherNames = map[DitherMode]string{
+	DitherNone:      "none",
+	DitherFlat:      "flat",
+	DitherFWeighted: "f-weighted",
+	DitherHighOrder: "high-order",
+}
+
+// LookupDither returns the dither mode with the given name (see DitherNames).
+func LookupDither(name string) (DitherMode, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for mode, modeName := range ditherNames {
+		if modeName == name {
+			return mode, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown dither %q (use %s)", name, strings.Join(DitherNames(), ", "))
+}
+
+// DitherNames returns the names of all dither modes from none to the most
+// aggressive noise shaping.
+func DitherNames() []string {
+	modes := make([]DitherMode, 0, len(ditherNames))
+	for mode := range ditherNames {
+		modes = append(modes, mode)
+	}
+	sort.Slice(modes, func(i, j int) bool { return modes[i] < modes[j] })
+	names := make([]string, len(modes))
+	for i, mode := range modes {
+		names[i] = ditherNames[mode]
+	}
+	return names
+}
+
+// String returns the name of the dither mode.
+func (m DitherMode) String() string {
+	return ditherNames[m]
+}
+
+// DitherConfig defines the dither applied by the integer sample formats.
+// Float formats are never dithered.
+type DitherConfig struct {
+	Mode DitherMode
+	// Seed selects the dither noise sequence; equal seeds give identical
+	// output, which keeps regression tests reproducible.
+	Seed uint64
+}
+
+// maxShapingError bounds the error fed back by the noise shaper in LSB. The
+// error stays within ±1.5 LSB unless the output clips; bounding it keeps a
+// clipped burst from driving the high-gain feedback filter unstable.
+const maxShapingError = 2.0
+
+// quantizer converts samples to integer PCM with dither and noise shaping.
+// Every channel has its own error history.
+type quantizer struct {
+	rng    *rand.Rand
+	filter []float64
+	errors [][]float64 // [channel][lag], most recent first
+}
+
+// newQuantizer creates a quantizer for channels channels, or returns nil if
+// the config or format needs none.
+func newQuantizer(config DitherConfig, format SampleFormat, channels int) *quantizer {
+	if config.Mode == DitherNone || format.IsFloat() {
+		return nil
+	}
+	q := &quantizer{
+		rng:    rand.New(rand.NewPCG(config.Seed, 0x646974686572)),
+		filter: noiseShapingFilters[config.Mode],
+		errors: make([][]float64, channels),
+	}
+	for ch := range q.errors {
+		q.errors[ch] = make([]float64, len(q.filter))
+	}
+	return q
+}
+
+// quantize converts sample v of channel ch to a signed integer of the given
+// bit depth, scaled like floatToPCM.
+func (q *quantizer) quantize(v float64, ch, bits int) int32 {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		v = 0
+	}
+	maxValue := float64(int64(1)<<(bits-1) - 1)
+
+	history := q.errors[ch]
+	u := v * maxValue
+	for i, h := range q.filter {
+		u -= h * history[i]
+	}
+
+	// TPDF: the sum of two uniform variables spans ±1 LSB.
+	dither := q.rng.Float64() - q.rng.Float64()
+	y := math.Max(-maxValue-1, math.Min(maxValue, math.Round(u+dither)))
+
+	if len(history) > 0 {
+		copy(history[1:], history)
+		history[0] = math.Max(-maxShapingError, math.Min(maxShapingError, y-u))
+	}
+	return int32(y)
+}
+
+// toPCM converts sample v of channel ch to a signed integer of the given bit
+// depth, with dither if q is not nil.
+func (q *quantizer) toPCM(v float64, ch, bits int) int32 {
+	if q == nil {
+		return floatToPCM(v, bits)
+	}
+	return q.quantize(v, ch, bits)
+}
@@ -0,0 +1,178 @@
+package wav
+
+import (
+	"bytes"
+	"math"
+	"math/cmplx"
+	"testing"
+)
+
+// quantizeTone requantizes a tone of amplitude LSBs at freq Hz to 16 bits and
+// returns the requantization error in LSB.
+func quantizeTone(mode DitherMode, amplitude, freq float64, n int) []float64 {
+	const sampleRate = 44100
+	q := newQuantizer(DitherConfig{Mode: mode, Seed: 7}, FormatPCM16, 1)
+	errors := make([]float64, n)
+	for i := range errors {
+		x := amplitude / 32767.0 * math.Sin(2.0*math.Pi*freq*float64(i)/sampleRate)
+		errors[i] = float64(q.toPCM(x, 0, 16)) - x*32767.0
+	}
+	return errors
+}
+
+// bandPower returns the mean power of x between lo and hi Hz at 44.1 kHz.
+func bandPower(x []float64, lo, hi float64) float64 {
+	const sampleRate = 44100
+	n := len(x)
+	power, bins := 0.0, 0
+	for k := int(lo * float64(n) / sampleRate); k <= int(hi*float64(n)/sampleRate); k++ {
+		var sum complex128
+		for i, v := range x {
+			sum += complex(v, 0) * cmplx.Exp(complex(0, -2.0*math.Pi*float64(k*i)/float64(n)))
+		}
+		power += real(sum)*real(sum) + imag(sum)*imag(sum)
+		bins++
+	}
+	return power / float64(bins) / float64(n)
+}
+
+func TestDither_RemovesTruncationDistortion(t *testing.T) {
+	t.Parallel()
+
+	// A 1.5 LSB tone at 1 kHz: plain rounding turns it into a stepped wave
+	// with strong odd harmonics, TPDF dither into the tone plus white noise.
+	// 3 kHz is an exact bin of the 4410-sample window.
+	const n = 4410
+	harmonic := func(mode DitherMode) float64 {
+		return 10.0 * math.Log10(bandPower(quantizeTone(mode, 1.5, 1000, n), 3000, 3000))
+	}
+	floor := 10.0 * math.Log10(bandPower(quantizeTone(DitherFlat, 1.5, 1000, n), 5000, 6000))
+
+	rounded, dithered := harmonic(DitherNone), harmonic(DitherFlat)
+	if rounded < floor+20 {
+		t.Errorf("rounding: 3rd harmonic %.1f dB, only %.1f dB above the dither floor", rounded, rounded-floor)
+	}
+	if dithered > floor+10 {
+		t.Errorf("TPDF dither: 3rd harmonic %.1f dB, %.1f dB above the noise floor", dithered, dithered-floor)
+	}
+}
+
+func TestDither_NoiseShapingMovesNoiseOutOfMidband(t *testing.T) {
+	t.Parallel()
+
+	const n = 4096
+	flat := quantizeTone(DitherFlat, 0, 0, n)
+	midFlat := bandPower(flat, 2000, 5000)
+	for _, tc := range []struct {
+		mode DitherMode
+		want float64 // minimum midband reduction in dB
+	}{
+		{DitherFWeighted, 15},
+		{DitherHighOrder, 15},
+	} {
+		shaped := quantizeTone(tc.mode, 0, 0, n)
+		if reduction := 10.0 * math.Log10(midFlat/bandPower(shaped, 2000, 5000)); reduction < tc.want {
+			t.Errorf("%s: 2-5 kHz noise %.1f dB below flat, want at least %.0f dB", tc.mode, reduction, tc.want)
+		}
+		if boost := 10.0 * math.Log10(bandPower(shaped, 18000, 20000)/bandPower(flat, 18000, 20000)); boost < 10 {
+			t.Errorf("%s: 18-20 kHz noise only %.1f dB above flat", tc.mode, boost)
+		}
+	}
+}
+
+// noiseShapingGain returns the mean power gain in dB of the noise transfer
+// function of mode between lo and hi Hz at 44.1 kHz.
+func noiseShapingGain(mode DitherMode, lo, hi float64) float64 {
+	const sampleRate = 44100
+	sum, n := 0.0, 0
+	for f := lo; f <= hi; f++ {
+		w := 2.0 * math.Pi * f / sampleRate
+		ntf := complex(1, 0)
+		for i, h := range noiseShapingFilters[mode] {
+			ntf -= complex(h, 0) * cmplx.Exp(complex(0, -w*float64(i+1)))
+		}
+		sum += real(ntf)*real(ntf) + imag(ntf)*imag(ntf)
+		n++
+	}
+	return 10.0 * math.Log10(sum/float64(n))
+}
+
+func TestDither_NoiseShapingResponse(t *testing.T) {
+	t.Parallel()
+
+	// The figures quoted in the DitherMode docs and the README.
+	for _, tc := range []struct {
+		mode                  DitherMode
+		midband, total, at20k float64
+	}{
+		{DitherFWeighted, -22, 18, 26},
+		{DitherHighOrder, -24, 23, 30},
+	} {
+		for _, m := range []struct {
+			name   string
+			lo, hi float64
+			want   float64
+		}{
+			{"2-5 kHz", 2000, 5000, tc.midband},
+			{"total", 0, 22050, tc.total},
+			{"20 kHz", 20000, 20000, tc.at20k},
+		} {
+			if got := noiseShapingGain(tc.mode, m.lo, m.hi); math.Abs(got-m.want) > 0.5 {
+				t.Errorf("%s: %s noise gain %+.1f dB, documented %+.0f dB", tc.mode, m.name, got, m.want)
+			}
+		}
+	}
+}
+
+func TestDither_SeedIsReproducible(t *testing.T) {
+	t.Parallel()
+
+	data := &AudioData{SampleRate: 44100, Samples: [][]float64{make([]float64, 256), make([]float64, 256)}, NumSamples: 256}
+	for i := range data.Samples[0] {
+		data.Samples[0][i] = 1e-4 * math.Sin(float64(i)/5.0)
+		data.Samples[1][i] = -data.Samples[0][i]
+	}
+	write := func(dither DitherConfig) []byte {
+		var buf bytes.Buffer
+		if err := writeWAVToWriter(&buf, data, 2, 0, FormatPCM16, dither); err != nil {
+			t.Fatalf("writeWAVToWriter() error = %v", err)
+		}
+		return buf.Bytes()
+	}
+
+	for _, mode := range []DitherMode{DitherFlat, DitherFWeighted, DitherHighOrder} {
+		a := write(DitherConfig{Mode: mode, Seed: 1})
+		if !bytes.Equal(a, write(DitherConfig{Mode: mode, Seed: 1})) {
+			t.Errorf("%s: equal seeds give different output", mode)
+		}
+		if bytes.Equal(a, write(DitherConfig{Mode: mode, Seed: 2})) {
+			t.Errorf("%s: different seeds give identical output", mode)
+		}
+	}
+
+	// Float output is never dithered.
+	var plain, dithered bytes.Buffer
+	if err := writeWAVToWriter(&plain, data, 2, 0, FormatFloat32, DitherConfig{}); err != nil {
+		t.Fatalf("writeWAVToWriter() error = %v", err)
+	}
+	if err := writeWAVToWriter(&dithered, data, 2, 0, FormatFloat32, DitherConfig{Mode: DitherHighOrder, Seed: 1}); err != nil {
+		t.Fatalf("writeWAVToWriter() error = %v", err)
+	}
+	if !bytes.Equal(plain.Bytes(), dithered.Bytes()) {
+		t.Error("float32 output was dithered")
+	}
+}
+
+func TestLookupDither(t *testing.T) {
+	t.Parallel()
+
+	for _, name := range DitherNames() {
+		mode, err := LookupDither(name)
+		if err != nil || mode.String() != name {
+			t.Errorf("LookupDither(%q) = %v, %v", name, mode, err)
+		}
+	}
+	if _, err := LookupDither("shaped"); err == nil {
+		t.Error("LookupDither(shaped) error = nil")
+	}
+}
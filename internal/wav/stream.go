@@ -0,0 +1,533 @@
+package wav
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Reader decodes the frames of a WAV stream incrementally.
+type Reader struct {
+	br         *bufio.Reader
+	format     wavFormat
+	channels   int
+	numFrames  int
+	framesRead int
+	frame      []byte
+	overs      int
+	peak       float64
+}
+
+// NewReader parses the WAV header up to the data chunk and returns a Reader
+// positioned at the first frame. RIFF, RF64 and BW64 streams are accepted.
+// The stream must have exactly channels channels; channels = 0 accepts any
+// channel count (see NumChannels).
+func NewReader(r io.Reader, channels int) (*Reader, error) {
+	br := bufio.NewReader(r)
+
+	var riff [4]byte
+	if _, err := io.ReadFull(br, riff[:]); err != nil {
+		return nil, fmt.Errorf("read RIFF header: %w", err)
+	}
+	// RF64 (EBU Tech 3306) and BW64 (ITU-R BS.2088) carry the sizes beyond
+	// 4 GB in a ds64 chunk.
+	large := string(riff[:]) == "RF64" || string(riff[:]) == "BW64"
+	if string(riff[:]) != "RIFF" && !large {
+		return nil, fmt.Errorf("not a RIFF file")
+	}
+
+	// The RIFF size is not needed: chunks are read until the end of the
+	// stream, and RF64 files carry the real size in ds64.
+	if _, err := io.CopyN(io.Discard, br, 4); err != nil {
+		return nil, fmt.Errorf("read RIFF size: %w", err)
+	}
+
+	var wave [4]byte
+	if _, err := io.ReadFull(br, wave[:]); err != nil {
+		return nil, fmt.Errorf("read WAVE header: %w", err)
+	}
+	if string(wave[:]) != "WAVE" {
+		return nil, fmt.Errorf("not a WAVE file")
+	}
+
+	var fmtChunk *wavFormat
+	var ds64DataSize uint64
+	haveDS64 := false
+	for {
+		var chunkID [4]byte
+		if _, err := io.ReadFull(br, chunkID[:]); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("read chunk id: %w", err)
+		}
+		var chunkSize uint32
+		if err := binary.Read(br, binary.LittleEndian, &chunkSize); err != nil {
+			return nil, fmt.Errorf("read chunk size: %w", err)
+		}
+
+		switch string(chunkID[:]) {
+		case "fmt ":
+			f, err := readFormatChunk(br, chunkSize)
+			if err != nil {
+				return nil, err
+			}
+			fmtChunk = f
+
+		case "ds64":
+			if !large {
+				return nil, fmt.Errorf("ds64 chunk in a RIFF file")
+			}
+			size, err := readDS64Chunk(br, chunkSize)
+			if err != nil {
+				return nil, err
+			}
+			ds64DataSize, haveDS64 = size, true
+
+		case "data":
+			if fmtChunk == nil {
+				return nil, fmt.Errorf("data chunk before fmt chunk")
+			}
+			dataSize := uint64(chunkSize)
+			if large && chunkSize == riffLimit {
+				if !haveDS64 {
+					return nil, fmt.Errorf("%s data chunk without ds64 chunk", riff[:])
+				}
+				dataSize = ds64DataSize
+			}
+			if channels == 0 {
+				channels = int(fmtChunk.numChannels)
+			}
+			if int(fmtChunk.numChannels) != channels {
+				return nil, fmt.Errorf("input must have %d channels, got %d channels", channels, fmtChunk.numChannels)
+			}
+			if fmtChunk.blockAlign == 0 {
+				return nil, fmt.Errorf("invalid blockAlign=0")
+			}
+			if dataSize%uint64(fmtChunk.blockAlign) != 0 {
+				return nil, fmt.Errorf("data chunk not aligned to block size")
+			}
+			if err := fmtChunk.validate(); err != nil {
+				return nil, err
+			}
+
+			return &Reader{
+				br:        br,
+				format:    *fmtChunk,
+				channels:  channels,
+				numFrames: int(dataSize / uint64(fmtChunk.blockAlign)),
+				frame:     make([]byte, fmtChunk.blockAlign),
+			}, nil
+
+		default:
+			// Skip unknown chunk (plus pad byte if needed)
+			if _, err := io.CopyN(io.Discard, br, int64(chunkSize)); err != nil {
+				return nil, fmt.Errorf("skip chunk %q: %w", string(chunkID[:]), err)
+			}
+			if chunkSize%2 == 1 {
+				if _, err := br.ReadByte(); err != nil {
+					return nil, fmt.Errorf("read pad byte: %w", err)
+				}
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("no data chunk found")
+}
+
+// readDS64Chunk reads a ds64 chunk and returns the 64-bit data chunk size.
+func readDS64Chunk(r io.Reader, chunkSize uint32) (uint64, error) {
+	if chunkSize < 24 {
+		return 0, fmt.Errorf("invalid ds64 chunk size %d", chunkSize)
+	}
+	var sizes struct {
+		RIFFSize    uint64
+		DataSize    uint64
+		SampleCount uint64
+	}
+	if err := binary.Read(r, binary.LittleEndian, &sizes); err != nil {
+		return 0, fmt.Errorf("read ds64 chunk: %w", err)
+	}
+	// Skip the table of other chunk sizes (plus pad byte if needed).
+	skip := int64(chunkSize) - 24 + int64(chunkSize%2)
+	if _, err := io.CopyN(io.Discard, r, skip); err != nil {
+		return 0, fmt.Errorf("skip ds64 table: %w", err)
+	}
+	return sizes.DataSize, nil
+}
+
+func readFormatChunk(r io.Reader, chunkSize uint32) (*wavFormat, error) {
+	if chunkSize < 16 {
+		return nil, fmt.Errorf("invalid fmt chunk size %d", chunkSize)
+	}
+	f := &wavFormat{}
+	if err := binary.Read(r, binary.LittleEndian, &f.audioFormat); err != nil {
+		return nil, fmt.Errorf("read audio format: %w", err)
+	}
+	if err := binary.Read(r, binary.LittleEndian, &f.numChannels); err != nil {
+		return nil, fmt.Errorf("read num channels: %w", err)
+	}
+	if err := binary.Read(r, binary.LittleEndian, &f.sampleRate); err != nil {
+		return nil, fmt.Errorf("read sample rate: %w", err)
+	}
+	if err := binary.Read(r, binary.LittleEndian, &f.byteRate); err != nil {
+		return nil, fmt.Errorf("read byte rate: %w", err)
+	}
+	if err := binary.Read(r, binary.LittleEndian, &f.blockAlign); err != nil {
+		return nil, fmt.Errorf("read block align: %w", err)
+	}
+	if err := binary.Read(r, binary.LittleEndian, &f.bitsPerSample); err != nil {
+		return nil, fmt.Errorf("read bits per sample: %w", err)
+	}
+
+	f.validBits = f.bitsPerSample
+
+	remaining := int64(chunkSize) - 16
+	if f.audioFormat == waveFormatExtensible {
+		if err := readFormatExtension(r, f, chunkSize); err != nil {
+			return nil, err
+		}
+		remaining = int64(chunkSize) - 40
+	}
+	if remaining > 0 {
+		if _, err := io.CopyN(io.Discard, r, remaining); err != nil {
+			return nil, fmt.Errorf("skip fmt extension: %w", err)
+		}
+	}
+	if chunkSize%2 == 1 {
+		if _, err := io.CopyN(io.Discard, r, 1); err != nil {
+			return nil, fmt.Errorf("read fmt pad byte: %w", err)
+		}
+	}
+
+	return f, nil
+}
+
+// readFormatExtension reads the WAVE_FORMAT_EXTENSIBLE part of a fmt chunk
+// into f and replaces its format tag with the one of the sub-format GUID.
+func readFormatExtension(r io.Reader, f *wavFormat, chunkSize uint32) error {
+	if chunkSize < 40 {
+		return fmt.Errorf("invalid WAVE_FORMAT_EXTENSIBLE fmt chunk size %d", chunkSize)
+	}
+	var ext struct {
+		CbSize      uint16
+		ValidBits   uint16
+		ChannelMask uint32
+		SubFormat   uint16
+		GUIDTail    [14]byte
+	}
+	if err := binary.Read(r, binary.LittleEndian, &ext); err != nil {
+		return fmt.Errorf("read fmt extension: %w", err)
+	}
+	if ext.CbSize < 22 {
+		return fmt.Errorf("invalid WAVE_FORMAT_EXTENSIBLE extension size %d", ext.CbSize)
+	}
+	if ext.GUIDTail != extensibleGUIDTail {
+		return fmt.Errorf("unsupported WAVE_FORMAT_EXTENSIBLE sub-format GUID")
+	}
+
+	f.audioFormat = ext.SubFormat
+	f.channelMask = ChannelMask(ext.ChannelMask)
+	// Some writers leave the valid bits at 0; the container size applies.
+	if ext.ValidBits != 0 {
+		f.validBits = ext.ValidBits
+	}
+	return nil
+}
+
+// validate reports whether the sample encoding is supported by Reader.
+func (f *wavFormat) validate() error {
+	switch f.audioFormat {
+	case 1: // PCM
+		switch f.bitsPerSample {
+		case 8, 16, 24, 32:
+		default:
+			return fmt.Errorf("unsupported PCM bit depth %d", f.bitsPerSample)
+		}
+	case 3: // IEEE float
+		switch f.bitsPerSample {
+		case 32, 64:
+		default:
+			return fmt.Errorf("unsupported IEEE float bit depth %d", f.bitsPerSample)
+		}
+	default:
+		return fmt.Errorf("unsupported WAV audio format %d", f.audioFormat)
+	}
+	if f.validBits > f.bitsPerSample {
+		return fmt.Errorf("invalid valid bits %d for %d-bit samples", f.validBits, f.bitsPerSample)
+	}
+	if int(f.blockAlign) < int(f.numChannels)*int(f.bitsPerSample/8) {
+		return fmt.Errorf("invalid blockAlign=%d for %d channels of %d bits", f.blockAlign, f.numChannels, f.bitsPerSample)
+	}
+	return nil
+}
+
+// SampleRate returns the sample rate of the stream in Hz.
+func (r *Reader) SampleRate() uint32 {
+	return r.format.sampleRate
+}
+
+// NumChannels returns the number of channels per frame.
+func (r *Reader) NumChannels() int {
+	return r.channels
+}
+
+// ChannelMask returns the speaker positions of a WAVE_FORMAT_EXTENSIBLE
+// stream, or 0 if the header carries none.
+func (r *Reader) ChannelMask() ChannelMask {
+	return r.format.channelMask
+}
+
+// ValidBits returns the number of significant bits per sample. Samples with
+// fewer valid bits than their container are left-justified, so they decode
+// at full scale either way.
+func (r *Reader) ValidBits() int {
+	return int(r.format.validBits)
+}
+
+// NumSamples returns the total number of frames in the data chunk.
+func (r *Reader) NumSamples() int {
+	return r.numFrames
+}
+
+// ReadFrames decodes up to len(dst[0]) frames into dst, shaped [channel][sample].
+// It returns the number of frames read and io.EOF once the data chunk is exhausted.
+func (r *Reader) ReadFrames(dst [][]float64) (int, error) {
+	if len(dst) != r.channels {
+		return 0, fmt.Errorf("destination must have %d channels, got %d", r.channels, len(dst))
+	}
+
+	want := len(dst[0])
+	for ch := 1; ch < r.channels; ch++ {
+		want = min(want, len(dst[ch]))
+	}
+	if remaining := r.numFrames - r.framesRead; want > remaining {
+		want = remaining
+	}
+	if want == 0 && r.framesRead >= r.numFrames {
+		return 0, io.EOF
+	}
+
+	bytesPerSample := int(r.format.bitsPerSample / 8)
+	for i := 0; i < want; i++ {
+		if _, err := io.ReadFull(r.br, r.frame); err != nil {
+			return i, fmt.Errorf("read sample data: %w", err)
+		}
+		for ch := 0; ch < r.channels; ch++ {
+			v := r.decodeSample(r.frame[ch*bytesPerSample:])
+			r.countOver(v)
+			dst[ch][i] = v
+		}
+		r.framesRead++
+	}
+
+	if r.framesRead >= r.numFrames {
+		return want, io.EOF
+	}
+	return want, nil
+}
+
+// countOver updates the peak and over statistics with sample v.
+func (r *Reader) countOver(v float64) {
+	a := math.Abs(v)
+	r.peak = max(r.peak, a)
+	if a > 1.0 {
+		r.overs++
+	}
+}
+
+// Overs returns the number of samples read so far that exceed full scale
+// (|v| > 1). Only float streams can contain them; they are passed on
+// unclipped.
+func (r *Reader) Overs() int {
+	return r.overs
+}
+
+// Peak returns the largest absolute sample value read so far.
+func (r *Reader) Peak() float64 {
+	return r.peak
+}
+
+func (r *Reader) decodeSample(b []byte) float64 {
+	switch r.format.audioFormat {
+	case 3: // IEEE float
+		var fv float64
+		if r.format.bitsPerSample == 64 {
+			fv = math.Float64frombits(binary.LittleEndian.Uint64(b))
+		} else {
+			fv = float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+		}
+		// Float samples keep their full range; only non-finite values are
+		// replaced.
+		if math.IsNaN(fv) || math.IsInf(fv, 0) {
+			fv = 0
+		}
+		return fv
+	default: // PCM
+		switch r.format.bitsPerSample {
+		case 8:
+			// 8-bit PCM is unsigned with its zero at 128.
+			return float64(int(b[0])-128) / 128.0
+		case 24:
+			return float64(pcm24FromBytes(b)) / 8388608.0
+		case 32:
+			return float64(int32(binary.LittleEndian.Uint32(b))) / 2147483648.0
+		}
+		return float64(int16(binary.LittleEndian.Uint16(b))) / 32768.0
+	}
+}
+
+// Writer encodes frames to a WAV stream incrementally. The header is
+// rewritten with the final sizes when the Writer is closed; a stream whose
+// size exceeds the 4 GB RIFF limit is promoted to RF64.
+type Writer struct {
+	ws         io.WriteSeeker
+	bw         *bufio.Writer
+	format     SampleFormat
+	channels   int
+	mask       ChannelMask
+	sampleRate uint32
+	// sizeLimit is the RIFF size above which Close promotes to RF64.
+	sizeLimit uint64
+	quantizer *quantizer
+	clip      ClipMode
+	overs     int
+	peak      float64
+	numFrames int64
+	frame     []byte
+	closed    bool
+}
+
+// NewWriter writes a WAV header with a placeholder size and returns a Writer
+// for frames of the given channel count and sample format.
+func NewWriter(w io.WriteSeeker, sampleRate uint32, channels int, format SampleFormat) (*Writer, error) {
+	return newWriter(w, sampleRate, channels, 0, format)
+}
+
+// NewWriterWithChannelMask is like NewWriter but writes a
+// WAVE_FORMAT_EXTENSIBLE header with the speaker positions in mask, one per
+// channel.
+func NewWriterWithChannelMask(w io.WriteSeeker, sampleRate uint32, mask ChannelMask, format SampleFormat) (*Writer, error) {
+	if mask == 0 {
+		return nil, fmt.Errorf("channel mask must not be empty")
+	}
+	return newWriter(w, sampleRate, mask.NumChannels(), mask, format)
+}
+
+func newWriter(w io.WriteSeeker, sampleRate uint32, channels int, mask ChannelMask, format SampleFormat) (*Writer, error) {
+	if channels <= 0 {
+		return nil, fmt.Errorf("channels must be > 0, got %d", channels)
+	}
+
+	bw := bufio.NewWriter(w)
+	if err := writeHeader(bw, format, channels, mask, sampleRate, 0, riffLimit); err != nil {
+		return nil, err
+	}
+
+	return &Writer{
+		ws:         w,
+		bw:         bw,
+		format:     format,
+		channels:   channels,
+		mask:       mask,
+		sampleRate: sampleRate,
+		sizeLimit:  riffLimit,
+		frame:      make([]byte, 0, channels*format.bytesPerSample()),
+	}, nil
+}
+
+// SetDither sets the dither of integer sample formats and restarts its noise
+// sequence. The default is DitherNone.
+func (w *Writer) SetDither(config DitherConfig) {
+	w.quantizer = newQuantizer(config, w.format, w.channels)
+}
+
+// SetClip sets the clip stage applied to every sample before it is encoded.
+// The default is ClipNone.
+func (w *Writer) SetClip(mode ClipMode) {
+	w.clip = mode
+}
+
+// Overs returns the number of samples written so far that exceed full scale
+// (|v| > 1) before the clip stage.
+func (w *Writer) Overs() int {
+	return w.overs
+}
+
+// Peak returns the largest absolute sample value written so far, before the
+// clip stage.
+func (w *Writer) Peak() float64 {
+	return w.peak
+}
+
+// WriteFrames appends the frames in samples, shaped [channel][sample].
+func (w *Writer) WriteFrames(samples [][]float64) error {
+	if w.closed {
+		return fmt.Errorf("write to closed WAV writer")
+	}
+	if len(samples) != w.channels {
+		return fmt.Errorf("output must have %d channels, got %d", w.channels, len(samples))
+	}
+
+	numSamples := len(samples[0])
+	for ch := 1; ch < w.channels; ch++ {
+		if len(samples[ch]) != numSamples {
+			return fmt.Errorf("channel %d has %d samples, want %d", ch, len(samples[ch]), numSamples)
+		}
+	}
+
+	for i := 0; i < numSamples; i++ {
+		w.frame = w.frame[:0]
+		for ch := 0; ch < w.channels; ch++ {
+			v := samples[ch][i]
+			a := math.Abs(v)
+			w.peak = max(w.peak, a)
+			if a > 1.0 {
+				w.overs++
+			}
+			w.frame = appendSample(w.frame, clipSample(v, w.clip), w.format, w.quantizer, ch)
+		}
+		if _, err := w.bw.Write(w.frame); err != nil {
+			return fmt.Errorf("failed to write sample data: %w", err)
+		}
+	}
+	w.numFrames += int64(numSamples)
+
+	return nil
+}
+
+// Close flushes buffered frames, pads an odd-sized data chunk and rewrites
+// the header with the final RIFF and data chunk sizes, as RF64 if they exceed
+// the RIFF limit. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	dataSize := uint64(w.numFrames) * uint64(w.channels*w.format.bytesPerSample())
+	if err := writePadByte(w.bw, dataSize); err != nil {
+		return err
+	}
+	if err := w.bw.Flush(); err != nil {
+		return fmt.Errorf("failed to flush WAV data: %w", err)
+	}
+
+	if _, err := w.ws.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek to header: %w", err)
+	}
+	bw := bufio.NewWriter(w.ws)
+	if err := writeHeader(bw, w.format, w.channels, w.mask, w.sampleRate, dataSize, w.sizeLimit); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed to rewrite WAV header: %w", err)
+	}
+	if _, err := w.ws.Seek(0, io.SeekEnd); err != nil {
+		return fmt.Errorf("failed to seek to end: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,493 @@
+package wav
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriter_MatchesWholeBufferWriter(t *testing.T) {
+	t.Parallel()
+
+	const n = 1000
+
+	data := &AudioData{
+		SampleRate: 48000,
+		Samples:    [][]float64{make([]float64, n), make([]float64, n)},
+		NumSamples: n,
+	}
+	for i := 0; i < n; i++ {
+		data.Samples[0][i] = 0.8 * math.Sin(2.0*math.Pi*float64(i)/37.0)
+		data.Samples[1][i] = 0.5 * math.Cos(2.0*math.Pi*float64(i)/91.0)
+	}
+
+	for _, format := range []SampleFormat{FormatPCM16, FormatFloat32} {
+		var want bytes.Buffer
+		if err := writeWAVToWriter(&want, data, 2, 0, format, DitherConfig{}); err != nil {
+			t.Fatalf("writeWAVToWriter() error = %v", err)
+		}
+
+		filename := filepath.Join(t.TempDir(), "stream.wav")
+		file, err := os.Create(filename)
+		if err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+
+		w, err := NewWriter(file, data.SampleRate, 2, format)
+		if err != nil {
+			t.Fatalf("NewWriter() error = %v", err)
+		}
+		for pos := 0; pos < n; pos += 333 {
+			end := min(pos+333, n)
+			if err := w.WriteFrames([][]float64{data.Samples[0][pos:end], data.Samples[1][pos:end]}); err != nil {
+				t.Fatalf("WriteFrames() error = %v", err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close() error = %v", err)
+		}
+		if err := file.Close(); err != nil {
+			t.Fatalf("file.Close() error = %v", err)
+		}
+
+		got, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatalf("ReadFile() error = %v", err)
+		}
+		if !bytes.Equal(got, want.Bytes()) {
+			t.Fatalf("format %d: streamed WAV differs from whole-buffer WAV", format)
+		}
+	}
+}
+
+func TestReader_ReadFramesInChunks(t *testing.T) {
+	t.Parallel()
+
+	const n = 777
+
+	data := &AudioData{
+		SampleRate: 44100,
+		Samples:    [][]float64{make([]float64, n), make([]float64, n), make([]float64, n), make([]float64, n)},
+		NumSamples: n,
+	}
+	for ch := 0; ch < 4; ch++ {
+		for i := 0; i < n; i++ {
+			data.Samples[ch][i] = 0.25 * float64(ch+1) * math.Sin(float64(i)/10.0)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := WriteFloat32WAVToWriter(&buf, data); err != nil {
+		t.Fatalf("WriteFloat32WAVToWriter() error = %v", err)
+	}
+
+	r, err := NewReader(&buf, 4)
+	if err != nil {
+		t.Fatalf("NewReader() error = %v", err)
+	}
+	if r.NumSamples() != n {
+		t.Fatalf("NumSamples() = %d, want %d", r.NumSamples(), n)
+	}
+	if r.SampleRate() != data.SampleRate {
+		t.Fatalf("SampleRate() = %d, want %d", r.SampleRate(), data.SampleRate)
+	}
+
+	chunk := make([][]float64, 4)
+	for ch := range chunk {
+		chunk[ch] = make([]float64, 100)
+	}
+
+	pos := 0
+	for {
+		got, err := r.ReadFrames(chunk)
+		for ch := 0; ch < 4; ch++ {
+			for i := 0; i < got; i++ {
+				want := float64(float32(data.Samples[ch][pos+i]))
+				if chunk[ch][i] != want {
+					t.Fatalf("sample[%d][%d] = %.8f, want %.8f", ch, pos+i, chunk[ch][i], want)
+				}
+			}
+		}
+		pos += got
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("ReadFrames() error = %v", err)
+		}
+	}
+	if pos != n {
+		t.Fatalf("read %d frames, want %d", pos, n)
+	}
+}
+
+func TestNewWriterWithChannelMask_WritesExtensibleHeader(t *testing.T) {
+	t.Parallel()
+
+	const n = 10
+
+	filename := filepath.Join(t.TempDir(), "surround.wav")
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	w, err := NewWriterWithChannelMask(file, 48000, Mask5_1, FormatFloat32)
+	if err != nil {
+		t.Fatalf("NewWriterWithChannelMask() error = %v", err)
+	}
+	frames := make([][]float64, 6)
+	for ch := range frames {
+		frames[ch] = make([]float64, n)
+	}
+	if err := w.WriteFrames(frames); err != nil {
+		t.Fatalf("WriteFrames() error = %v", err)
+	}
+	if err := w.WriteFrames(frames[:4]); err == nil {
+		t.Fatal("WriteFrames() accepted 4 channels for a 5.1 mask")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatalf("file.Close() error = %v", err)
+	}
+
+	got, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+
+	le := binary.LittleEndian
+	checks := []struct {
+		name      string
+		got, want uint32
+	}{
+		{"RIFF size", le.Uint32(got[4:]), uint32(len(got) - 8)},
+		{"JUNK size", le.Uint32(got[16:]), ds64Size},
+		{"fmt size", le.Uint32(got[52:]), 40},
+		{"format tag", uint32(le.Uint16(got[56:])), waveFormatExtensible},
+		{"channels", uint32(le.Uint16(got[58:])), 6},
+		{"cbSize", uint32(le.Uint16(got[72:])), 22},
+		{"valid bits", uint32(le.Uint16(got[74:])), 32},
+		{"channel mask", le.Uint32(got[76:]), uint32(Mask5_1)},
+		{"sub-format", uint32(le.Uint16(got[80:])), 3},
+		{"data size", le.Uint32(got[100:]), n * 6 * 4},
+	}
+	for _, c := range checks {
+		if c.got != c.want {
+			t.Errorf("%s = %#x, want %#x", c.name, c.got, c.want)
+		}
+	}
+	if string(got[12:16]) != "JUNK" {
+		t.Errorf("first chunk = %q, want JUNK", got[12:16])
+	}
+	if !bytes.Equal(got[82:96], extensibleGUIDTail[:]) {
+		t.Errorf("sub-format GUID tail = % x", got[82:96])
+	}
+	if string(got[96:100]) != "data" {
+		t.Errorf("chunk after fmt = %q, want data", got[96:100])
+	}
+}
+
+func TestNewReader_ReadsExtensibleHeader(t *testing.T) {
+	t.Parallel()
+
+	data := &AudioData{
+		SampleRate: 48000,
+		Samples:    [][]float64{{0.5, -0.25}, {0.125, 0}, {-0.5, 0.75}, {0.25, -1}},
+		NumSamples: 2,
+	}
+	var buf bytes.Buffer
+	if err := WriteFloat32WAVToWriter(&buf, data); err != nil {
+		t.Fatalf("WriteFloat32WAVToWriter() error = %v", err)
+	}
+
+	r, err := NewReader(&buf, 4)
+	if err != nil {
+		t.Fatalf("NewReader() error = %v", err)
+	}
+	if r.ChannelMask() != MaskQuad {
+		t.Errorf("ChannelMask() = %#x, want %#x", r.ChannelMask(), MaskQuad)
+	}
+	if r.ValidBits() != 32 {
+		t.Errorf("ValidBits() = %d, want 32", r.ValidBits())
+	}
+	got := [][]float64{make([]float64, 2), make([]float64, 2), make([]float64, 2), make([]float64, 2)}
+	if _, err := r.ReadFrames(got); err != nil && !errors.Is(err, io.EOF) {
+		t.Fatalf("ReadFrames() error = %v", err)
+	}
+	for ch := range got {
+		for i := range got[ch] {
+			if got[ch][i] != data.Samples[ch][i] {
+				t.Errorf("sample[%d][%d] = %g, want %g", ch, i, got[ch][i], data.Samples[ch][i])
+			}
+		}
+	}
+}
+
+// extensibleHeader builds a WAVE_FORMAT_EXTENSIBLE stereo header for 32-bit
+// containers with the given valid bits and sub-format GUID tail.
+func extensibleHeader(validBits uint16, guidTail [14]byte, frames int) []byte {
+	le := binary.LittleEndian
+	dataSize := uint32(frames * 8)
+	var b []byte
+	b = append(b, "RIFF"...)
+	b = le.AppendUint32(b, 60+dataSize)
+	b = append(b, "WAVEfmt "...)
+	b = le.AppendUint32(b, 40)
+	b = le.AppendUint16(b, waveFormatExtensible)
+	b = le.AppendUint16(b, 2)
+	b = le.AppendUint32(b, 44100)
+	b = le.AppendUint32(b, 44100*8)
+	b = le.AppendUint16(b, 8)
+	b = le.AppendUint16(b, 32)
+	b = le.AppendUint16(b, 22)
+	b = le.AppendUint16(b, validBits)
+	b = le.AppendUint32(b, uint32(SpeakerFrontLeft|SpeakerFrontRight))
+	b = le.AppendUint16(b, 1)
+	b = append(b, guidTail[:]...)
+	b = append(b, "data"...)
+	return le.AppendUint32(b, dataSize)
+}
+
+func TestNewReader_Extensible24In32(t *testing.T) {
+	t.Parallel()
+
+	// 24 valid bits left-justified in a 32-bit container.
+	b := extensibleHeader(24, extensibleGUIDTail, 1)
+	b = binary.LittleEndian.AppendUint32(b, 0x40000000)
+	b = binary.LittleEndian.AppendUint32(b, 0xe0000000)
+
+	r, err := NewReader(bytes.NewReader(b), 2)
+	if err != nil {
+		t.Fatalf("NewReader() error = %v", err)
+	}
+	if r.ValidBits() != 24 {
+		t.Errorf("ValidBits() = %d, want 24", r.ValidBits())
+	}
+	got := [][]float64{make([]float64, 1), make([]float64, 1)}
+	if _, err := r.ReadFrames(got); err != nil && !errors.Is(err, io.EOF) {
+		t.Fatalf("ReadFrames() error = %v", err)
+	}
+	if got[0][0] != 0.5 || got[1][0] != -0.25 {
+		t.Errorf("samples = %g, %g, want 0.5, -0.25", got[0][0], got[1][0])
+	}
+}
+
+func TestNewReader_ExtensibleErrors(t *testing.T) {
+	t.Parallel()
+
+	otherGUID := extensibleGUIDTail
+	otherGUID[13] = 0x72
+	for name, b := range map[string][]byte{
+		"sub-format": extensibleHeader(32, otherGUID, 0),
+		"valid bits": extensibleHeader(33, extensibleGUIDTail, 0),
+	} {
+		if _, err := NewReader(bytes.NewReader(b), 2); err == nil {
+			t.Errorf("%s: NewReader() accepted an invalid header", name)
+		}
+	}
+}
+
+func TestNewReader_AnyChannelCount(t *testing.T) {
+	t.Parallel()
+
+	for _, channels := range []int{1, 2, 6} {
+		data := &AudioData{SampleRate: 48000, Samples: make([][]float64, channels), NumSamples: 10}
+		for ch := range data.Samples {
+			data.Samples[ch] = make([]float64, 10)
+		}
+		var buf bytes.Buffer
+		if err := writeWAVToWriter(&buf, data, channels, 0, FormatPCM16, DitherConfig{}); err != nil {
+			t.Fatalf("writeWAVToWriter() error = %v", err)
+		}
+
+		r, err := NewReader(&buf, 0)
+		if err != nil {
+			t.Fatalf("NewReader(0) error = %v", err)
+		}
+		if r.NumChannels() != channels {
+			t.Fatalf("NumChannels() = %d, want %d", r.NumChannels(), channels)
+		}
+	}
+}
+
+func TestWriter_PromotesToRF64(t *testing.T) {
+	t.Parallel()
+
+	const n = 100
+
+	filename := filepath.Join(t.TempDir(), "large.wav")
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	w, err := NewWriterWithChannelMask(file, 96000, MaskQuad, FormatFloat32)
+	if err != nil {
+		t.Fatalf("NewWriterWithChannelMask() error = %v", err)
+	}
+	// Promote above 1 kB instead of 4 GB.
+	w.sizeLimit = 1024
+
+	frames := make([][]float64, 4)
+	for ch := range frames {
+		frames[ch] = make([]float64, n)
+		for i := range frames[ch] {
+			frames[ch][i] = float64(float32(0.002 * float64(ch*n+i)))
+		}
+	}
+	if err := w.WriteFrames(frames); err != nil {
+		t.Fatalf("WriteFrames() error = %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatalf("file.Close() error = %v", err)
+	}
+
+	got, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	le := binary.LittleEndian
+	if string(got[:4]) != "RF64" || string(got[12:16]) != "ds64" {
+		t.Fatalf("header starts %q ... %q, want RF64 ... ds64", got[:4], got[12:16])
+	}
+	checks := []struct {
+		name      string
+		got, want uint64
+	}{
+		{"RIFF size", uint64(le.Uint32(got[4:])), riffLimit},
+		{"ds64 RIFF size", le.Uint64(got[20:]), uint64(len(got) - 8)},
+		{"ds64 data size", le.Uint64(got[28:]), n * 4 * 4},
+		{"ds64 sample count", le.Uint64(got[36:]), n},
+		{"data size", uint64(le.Uint32(got[len(got)-n*4*4-4:])), riffLimit},
+	}
+	for _, c := range checks {
+		if c.got != c.want {
+			t.Errorf("%s = %#x, want %#x", c.name, c.got, c.want)
+		}
+	}
+
+	// RF64 and BW64 share the layout.
+	for _, id := range []string{"RF64", "BW64"} {
+		copy(got, id)
+		r, err := NewReader(bytes.NewReader(got), 4)
+		if err != nil {
+			t.Fatalf("%s: NewReader() error = %v", id, err)
+		}
+		if r.NumSamples() != n {
+			t.Fatalf("%s: NumSamples() = %d, want %d", id, r.NumSamples(), n)
+		}
+		read := [][]float64{make([]float64, n), make([]float64, n), make([]float64, n), make([]float64, n)}
+		if _, err := r.ReadFrames(read); err != nil && !errors.Is(err, io.EOF) {
+			t.Fatalf("%s: ReadFrames() error = %v", id, err)
+		}
+		for ch := range read {
+			for i := range read[ch] {
+				if read[ch][i] != frames[ch][i] {
+					t.Fatalf("%s: sample[%d][%d] = %g, want %g", id, ch, i, read[ch][i], frames[ch][i])
+				}
+			}
+		}
+	}
+}
+
+func TestWriter_PadsOddDataChunk(t *testing.T) {
+	t.Parallel()
+
+	// Mono 8-bit with an odd frame count gives an odd-sized data chunk.
+	const n = 101
+
+	data := &AudioData{SampleRate: 8000, Samples: [][]float64{make([]float64, n)}, NumSamples: n}
+	for i := range data.Samples[0] {
+		data.Samples[0][i] = 0.5 * math.Sin(float64(i)/7.0)
+	}
+
+	var whole bytes.Buffer
+	if err := writeWAVToWriter(&whole, data, 1, 0, FormatPCM8, DitherConfig{}); err != nil {
+		t.Fatalf("writeWAVToWriter() error = %v", err)
+	}
+
+	stream := func(sizeLimit uint64) []byte {
+		filename := filepath.Join(t.TempDir(), "odd.wav")
+		file, err := os.Create(filename)
+		if err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+		w, err := NewWriter(file, data.SampleRate, 1, FormatPCM8)
+		if err != nil {
+			t.Fatalf("NewWriter() error = %v", err)
+		}
+		w.sizeLimit = sizeLimit
+		for pos := 0; pos < n; pos += 40 {
+			if err := w.WriteFrames([][]float64{data.Samples[0][pos:min(pos+40, n)]}); err != nil {
+				t.Fatalf("WriteFrames() error = %v", err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close() error = %v", err)
+		}
+		if err := file.Close(); err != nil {
+			t.Fatalf("file.Close() error = %v", err)
+		}
+		got, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatalf("ReadFile() error = %v", err)
+		}
+		return got
+	}
+
+	le := binary.LittleEndian
+	for _, tc := range []struct {
+		name string
+		got  []byte
+	}{
+		{"writeWAVToWriter", whole.Bytes()},
+		{"Writer", stream(riffLimit)},
+	} {
+		got := tc.got
+		if len(got)%2 != 0 {
+			t.Errorf("%s: file length %d is odd", tc.name, len(got))
+		}
+		if got[len(got)-1] != 0 {
+			t.Errorf("%s: pad byte = %#x, want 0", tc.name, got[len(got)-1])
+		}
+		if riffSize := le.Uint32(got[4:]); int(riffSize) != len(got)-8 {
+			t.Errorf("%s: RIFF size = %d, want %d", tc.name, riffSize, len(got)-8)
+		}
+		if dataSize := le.Uint32(got[len(got)-n-1-4:]); dataSize != n {
+			t.Errorf("%s: data size = %d, want %d without the pad byte", tc.name, dataSize, n)
+		}
+
+		r, err := NewReader(bytes.NewReader(got), 1)
+		if err != nil {
+			t.Fatalf("%s: NewReader() error = %v", tc.name, err)
+		}
+		if r.NumSamples() != n {
+			t.Errorf("%s: NumSamples() = %d, want %d", tc.name, r.NumSamples(), n)
+		}
+	}
+	if !bytes.Equal(stream(riffLimit), whole.Bytes()) {
+		t.Error("streamed WAV differs from whole-buffer WAV")
+	}
+
+	// In RF64 the pad byte counts toward the ds64 RIFF size only.
+	got := stream(64)
+	if string(got[:4]) != "RF64" {
+		t.Fatalf("header starts %q, want RF64", got[:4])
+	}
+	if riffSize := le.Uint64(got[20:]); int(riffSize) != len(got)-8 {
+		t.Errorf("ds64 RIFF size = %d, want %d", riffSize, len(got)-8)
+	}
+	if dataSize := le.Uint64(got[28:]); dataSize != n {
+		t.Errorf("ds64 data size = %d, want %d", dataSize, n)
+	}
+}
@@ -7,7 +7,10 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"math/bits"
 	"os"
+	"sort"
+	"strings"
 )
 
 // AudioData represents multi-channel audio data
@@ -15,6 +18,22 @@ type AudioData struct {
 	SampleRate uint32
 	Samples    [][]float64 // [channel][sample]
 	NumSamples int
+
+	overs int
+	peak  float64
+}
+
+// Overs returns the number of samples beyond full scale (|v| > 1) in the file
+// the data was read from. Only float files can contain them; they are kept
+// unclipped.
+func (a *AudioData) Overs() int {
+	return a.overs
+}
+
+// Peak returns the largest absolute sample value in the file the data was
+// read from.
+func (a *AudioData) Peak() float64 {
+	return a.peak
 }
 
 // ReadWAV reads a stereo WAV file and returns the audio data
@@ -47,150 +66,184 @@ func ReadWAVChannels(filename string, channels int) (*AudioData, error) {
 	return ReadWAVFromReader(file, channels)
 }
 
-// WriteWAV writes 4-channel audio data to a WAV file
-func WriteWAV(filename string, data *AudioData) error {
-	return writeWAVPCM16(filename, data, 4)
+// SampleFormat selects the sample encoding of a WAV data chunk.
+type SampleFormat int
+
+const (
+	// FormatPCM16 is 16-bit signed integer PCM.
+	FormatPCM16 SampleFormat = iota
+	// FormatFloat32 is 32-bit IEEE float.
+	FormatFloat32
+	// FormatPCM8 is 8-bit unsigned integer PCM.
+	FormatPCM8
+	// FormatPCM20 is 20-bit signed integer PCM in 24-bit containers. It is
+	// written with a WAVE_FORMAT_EXTENSIBLE header carrying the valid bits.
+	FormatPCM20
+	// FormatPCM24 is 24-bit signed integer PCM.
+	FormatPCM24
+	// FormatPCM32 is 32-bit signed integer PCM.
+	FormatPCM32
+	// FormatFloat64 is 64-bit IEEE float.
+	FormatFloat64
+)
+
+// sampleFormatInfo describes the encoding of a SampleFormat.
+type sampleFormatInfo struct {
+	name        string
+	description string
+	audioFormat uint16
+	bytes       int
+	validBits   int
 }
 
-// WriteStereoWAV writes 2-channel audio data to a WAV file
-func WriteStereoWAV(filename string, data *AudioData) error {
-	return writeWAVPCM16(filename, data, 2)
+var sampleFormats = map[SampleFormat]sampleFormatInfo{
+	FormatPCM8:    {"pcm8", "8-bit PCM", 1, 1, 8},
+	FormatPCM16:   {"pcm16", "16-bit PCM", 1, 2, 16},
+	FormatPCM20:   {"pcm20", "20-bit PCM", 1, 3, 20},
+	FormatPCM24:   {"pcm24", "24-bit PCM", 1, 3, 24},
+	FormatPCM32:   {"pcm32", "32-bit PCM", 1, 4, 32},
+	FormatFloat32: {"float32", "32-bit IEEE float", 3, 4, 32},
+	FormatFloat64: {"float64", "64-bit IEEE float", 3, 8, 64},
 }
 
-func writeWAVPCM16(filename string, data *AudioData, channels int) error {
-	file, err := os.Create(filename)
-	if err != nil {
-		return fmt.Errorf("failed to create WAV file: %w", err)
+// LookupFormat returns the sample format with the given name (see
+// FormatNames).
+func LookupFormat(name string) (SampleFormat, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for format, info := range sampleFormats {
+		if info.name == name {
+			return format, nil
+		}
 	}
-	defer file.Close()
-
-	return writeWAVPCM16ToWriter(file, data, channels)
+	return 0, fmt.Errorf("unknown sample format %q (use %s)", name, strings.Join(FormatNames(), ", "))
 }
 
-// WriteWAVToWriter writes 4-channel audio data to a WAV stream in 16-bit PCM.
-func WriteWAVToWriter(w io.Writer, data *AudioData) error {
-	return writeWAVPCM16ToWriter(w, data, 4)
+// FormatNames returns the names of all sample formats, integer formats by
+// bit depth first.
+func FormatNames() []string {
+	formats := make([]SampleFormat, 0, len(sampleFormats))
+	for format := range sampleFormats {
+		formats = append(formats, format)
+	}
+	sort.Slice(formats, func(i, j int) bool {
+		a, b := sampleFormats[formats[i]], sampleFormats[formats[j]]
+		if a.audioFormat != b.audioFormat {
+			return a.audioFormat < b.audioFormat
+		}
+		return a.validBits < b.validBits
+	})
+	names := make([]string, len(formats))
+	for i, format := range formats {
+		names[i] = sampleFormats[format].name
+	}
+	return names
 }
 
-// WriteStereoWAVToWriter writes 2-channel audio data to a WAV stream in 16-bit PCM.
-func WriteStereoWAVToWriter(w io.Writer, data *AudioData) error {
-	return writeWAVPCM16ToWriter(w, data, 2)
+// String returns the name of the format.
+func (f SampleFormat) String() string {
+	return sampleFormats[f].name
 }
 
-func writeWAVPCM16ToWriter(w io.Writer, data *AudioData, channels int) error {
-	if len(data.Samples) != channels {
-		return fmt.Errorf("output must have %d channels, got %d", channels, len(data.Samples))
-	}
-	if data.NumSamples < 0 {
-		return fmt.Errorf("NumSamples must be >= 0")
-	}
-	for ch := 0; ch < channels; ch++ {
-		if len(data.Samples[ch]) < data.NumSamples {
-			return fmt.Errorf("channel %d has %d samples, want at least %d", ch, len(data.Samples[ch]), data.NumSamples)
-		}
-	}
+// Description returns a human-readable description of the format, e.g.
+// "24-bit PCM".
+func (f SampleFormat) Description() string {
+	return sampleFormats[f].description
+}
 
-	bw := bufio.NewWriter(w)
+// ChannelMask is the speaker position bit field (dwChannelMask) of a
+// WAVE_FORMAT_EXTENSIBLE header. Channels are stored in ascending bit order.
+type ChannelMask uint32
+
+// Speaker positions of the channel mask.
+const (
+	SpeakerFrontLeft ChannelMask = 1 << iota
+	SpeakerFrontRight
+	SpeakerFrontCenter
+	SpeakerLowFrequency
+	SpeakerBackLeft
+	SpeakerBackRight
+	SpeakerFrontLeftOfCenter
+	SpeakerFrontRightOfCenter
+	SpeakerBackCenter
+	SpeakerSideLeft
+	SpeakerSideRight
+)
 
-	numChannels := uint16(channels)
-	bitsPerSample := uint16(16)
-	blockAlign := numChannels * (bitsPerSample / 8)
-	byteRate := data.SampleRate * uint32(blockAlign)
-	audioFormat := uint16(1) // PCM
-	dataSize := uint32(data.NumSamples) * uint32(blockAlign)
+// Channel masks of common layouts.
+const (
+	MaskQuad = SpeakerFrontLeft | SpeakerFrontRight | SpeakerBackLeft | SpeakerBackRight
+	Mask5_1  = SpeakerFrontLeft | SpeakerFrontRight | SpeakerFrontCenter | SpeakerLowFrequency | SpeakerBackLeft | SpeakerBackRight
+	Mask6_1  = Mask5_1 | SpeakerBackCenter
+	Mask7_1  = Mask5_1 | SpeakerSideLeft | SpeakerSideRight
+)
 
-	// RIFF header
-	if err := writeString(bw, "RIFF"); err != nil {
-		return fmt.Errorf("failed to write RIFF header: %w", err)
-	}
-	if err := binary.Write(bw, binary.LittleEndian, uint32(36+dataSize)); err != nil {
-		return fmt.Errorf("failed to write file size: %w", err)
-	}
-	if err := writeString(bw, "WAVE"); err != nil {
-		return fmt.Errorf("failed to write WAVE header: %w", err)
-	}
+// NumChannels returns the number of speaker positions in the mask.
+func (m ChannelMask) NumChannels() int {
+	return bits.OnesCount32(uint32(m))
+}
 
-	// fmt chunk
-	if err := writeString(bw, "fmt "); err != nil {
-		return fmt.Errorf("failed to write fmt chunk ID: %w", err)
-	}
-	if err := binary.Write(bw, binary.LittleEndian, uint32(16)); err != nil {
-		return fmt.Errorf("failed to write fmt chunk size: %w", err)
-	}
-	if err := binary.Write(bw, binary.LittleEndian, audioFormat); err != nil {
-		return fmt.Errorf("failed to write audio format: %w", err)
-	}
-	if err := binary.Write(bw, binary.LittleEndian, numChannels); err != nil {
-		return fmt.Errorf("failed to write num channels: %w", err)
-	}
-	if err := binary.Write(bw, binary.LittleEndian, data.SampleRate); err != nil {
-		return fmt.Errorf("failed to write sample rate: %w", err)
-	}
-	if err := binary.Write(bw, binary.LittleEndian, byteRate); err != nil {
-		return fmt.Errorf("failed to write byte rate: %w", err)
-	}
-	if err := binary.Write(bw, binary.LittleEndian, blockAlign); err != nil {
-		return fmt.Errorf("failed to write block align: %w", err)
-	}
-	if err := binary.Write(bw, binary.LittleEndian, bitsPerSample); err != nil {
-		return fmt.Errorf("failed to write bits per sample: %w", err)
-	}
+// WriteWAV writes 4-channel audio data to a WAV file with a quad channel mask
+func WriteWAV(filename string, data *AudioData) error {
+	return writeWAVFile(filename, data, 4, MaskQuad, FormatPCM16, DitherConfig{})
+}
 
-	// data chunk
-	if err := writeString(bw, "data"); err != nil {
-		return fmt.Errorf("failed to write data chunk ID: %w", err)
-	}
-	if err := binary.Write(bw, binary.LittleEndian, dataSize); err != nil {
-		return fmt.Errorf("failed to write data size: %w", err)
-	}
+// WriteStereoWAV writes 2-channel audio data to a WAV file
+func WriteStereoWAV(filename string, data *AudioData) error {
+	return writeWAVFile(filename, data, 2, 0, FormatPCM16, DitherConfig{})
+}
 
-	// Interleaved PCM16 samples
-	for i := 0; i < data.NumSamples; i++ {
-		for ch := 0; ch < channels; ch++ {
-			sample := floatToPCM16(data.Samples[ch][i])
-			if err := binary.Write(bw, binary.LittleEndian, sample); err != nil {
-				return fmt.Errorf("failed to write sample data: %w", err)
-			}
-		}
-	}
-	if err := bw.Flush(); err != nil {
-		return fmt.Errorf("failed to flush WAV data: %w", err)
-	}
+// WriteWAVToWriter writes 4-channel audio data to a WAV stream in 16-bit PCM
+// with a quad channel mask.
+func WriteWAVToWriter(w io.Writer, data *AudioData) error {
+	return writeWAVToWriter(w, data, 4, MaskQuad, FormatPCM16, DitherConfig{})
+}
 
-	return nil
+// WriteStereoWAVToWriter writes 2-channel audio data to a WAV stream in 16-bit PCM.
+func WriteStereoWAVToWriter(w io.Writer, data *AudioData) error {
+	return writeWAVToWriter(w, data, 2, 0, FormatPCM16, DitherConfig{})
 }
 
 // WriteFloat32WAV writes 4-channel audio data to a WAV file in 32-bit IEEE float format
+// with a quad channel mask
 func WriteFloat32WAV(filename string, data *AudioData) error {
-	return writeWAVFloat32(filename, data, 4)
+	return writeWAVFile(filename, data, 4, MaskQuad, FormatFloat32, DitherConfig{})
 }
 
 // WriteStereoFloat32WAV writes 2-channel audio data to a WAV file in 32-bit IEEE float format
 func WriteStereoFloat32WAV(filename string, data *AudioData) error {
-	return writeWAVFloat32(filename, data, 2)
+	return writeWAVFile(filename, data, 2, 0, FormatFloat32, DitherConfig{})
+}
+
+// WriteFloat32WAVToWriter writes 4-channel audio data to a WAV stream in 32-bit IEEE float format
+// with a quad channel mask.
+func WriteFloat32WAVToWriter(w io.Writer, data *AudioData) error {
+	return writeWAVToWriter(w, data, 4, MaskQuad, FormatFloat32, DitherConfig{})
+}
+
+// WriteStereoFloat32WAVToWriter writes 2-channel audio data to a WAV stream in 32-bit IEEE float format.
+func WriteStereoFloat32WAVToWriter(w io.Writer, data *AudioData) error {
+	return writeWAVToWriter(w, data, 2, 0, FormatFloat32, DitherConfig{})
+}
+
+// WriteWAVWithFormat writes 4-channel audio data to a WAV file in the given
+// sample format and dither with a quad channel mask.
+func WriteWAVWithFormat(filename string, data *AudioData, format SampleFormat, dither DitherConfig) error {
+	return writeWAVFile(filename, data, 4, MaskQuad, format, dither)
 }
 
-func writeWAVFloat32(filename string, data *AudioData, channels int) error {
+func writeWAVFile(filename string, data *AudioData, channels int, mask ChannelMask, format SampleFormat, dither DitherConfig) error {
 	file, err := os.Create(filename)
 	if err != nil {
 		return fmt.Errorf("failed to create WAV file: %w", err)
 	}
 	defer file.Close()
 
-	return writeWAVFloat32ToWriter(file, data, channels)
+	return writeWAVToWriter(file, data, channels, mask, format, dither)
 }
 
-// WriteFloat32WAVToWriter writes 4-channel audio data to a WAV stream in 32-bit IEEE float format.
-func WriteFloat32WAVToWriter(w io.Writer, data *AudioData) error {
-	return writeWAVFloat32ToWriter(w, data, 4)
-}
-
-// WriteStereoFloat32WAVToWriter writes 2-channel audio data to a WAV stream in 32-bit IEEE float format.
-func WriteStereoFloat32WAVToWriter(w io.Writer, data *AudioData) error {
-	return writeWAVFloat32ToWriter(w, data, 2)
-}
-
-func writeWAVFloat32ToWriter(w io.Writer, data *AudioData, channels int) error {
+// writeWAVToWriter writes data as a complete WAV stream. A non-zero mask
+// selects a WAVE_FORMAT_EXTENSIBLE header.
+func writeWAVToWriter(w io.Writer, data *AudioData, channels int, mask ChannelMask, format SampleFormat, dither DitherConfig) error {
 	if len(data.Samples) != channels {
 		return fmt.Errorf("output must have %d channels, got %d", channels, len(data.Samples))
 	}
@@ -205,82 +258,211 @@ func writeWAVFloat32ToWriter(w io.Writer, data *AudioData, channels int) error {
 
 	bw := bufio.NewWriter(w)
 
+	blockAlign := channels * format.bytesPerSample()
+	dataSize := uint64(data.NumSamples) * uint64(blockAlign)
+	if err := writeHeader(bw, format, channels, mask, data.SampleRate, dataSize, riffLimit); err != nil {
+		return err
+	}
+
+	// Interleaved samples
+	q := newQuantizer(dither, format, channels)
+	frame := make([]byte, 0, blockAlign)
+	for i := 0; i < data.NumSamples; i++ {
+		frame = frame[:0]
+		for ch := 0; ch < channels; ch++ {
+			frame = appendSample(frame, data.Samples[ch][i], format, q, ch)
+		}
+		if _, err := bw.Write(frame); err != nil {
+			return fmt.Errorf("failed to write sample data: %w", err)
+		}
+	}
+	if err := writePadByte(bw, dataSize); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed to flush WAV data: %w", err)
+	}
+
+	return nil
+}
+
+// IsFloat reports whether f is an IEEE float format.
+func (f SampleFormat) IsFloat() bool {
+	return f.audioFormat() == 3
+}
+
+func (f SampleFormat) bytesPerSample() int {
+	return sampleFormats[f].bytes
+}
+
+// audioFormat returns the format tag: 1 for PCM, 3 for IEEE float.
+func (f SampleFormat) audioFormat() uint16 {
+	return sampleFormats[f].audioFormat
+}
+
+func (f SampleFormat) validBits() int {
+	return sampleFormats[f].validBits
+}
+
+// riffLimit is the largest RIFF or data chunk size a 32-bit size field holds.
+const riffLimit = math.MaxUint32
+
+// ds64Size is the payload size of the RF64 ds64 chunk without a table: the
+// 64-bit RIFF size, data size and sample count and the table length.
+const ds64Size = 28
+
+// writeHeader writes the RIFF, fmt and data chunk headers for dataSize bytes
+// of samples. A non-zero mask or a format with fewer valid bits than its
+// container selects a WAVE_FORMAT_EXTENSIBLE fmt chunk.
+//
+// The header always reserves room for a ds64 chunk in a JUNK chunk. If the
+// RIFF size exceeds sizeLimit, the header is written as RF64 instead: the
+// JUNK chunk becomes the ds64 chunk with the 64-bit sizes and the 32-bit
+// size fields are set to 0xFFFFFFFF. Both forms have the same length, so a
+// header can be rewritten in place once the final size is known.
+//
+// An odd dataSize is followed by a pad byte (see writePadByte), which counts
+// toward the RIFF size but not the data chunk size.
+func writeHeader(w io.Writer, format SampleFormat, channels int, mask ChannelMask, sampleRate uint32, dataSize, sizeLimit uint64) error {
 	numChannels := uint16(channels)
-	bitsPerSample := uint16(32)
-	byteRate := data.SampleRate * uint32(numChannels) * uint32(bitsPerSample/8)
+	bitsPerSample := uint16(format.bytesPerSample() * 8)
 	blockAlign := numChannels * (bitsPerSample / 8)
-	audioFormat := uint16(3) // IEEE float
-	dataSize := uint32(data.NumSamples) * uint32(numChannels) * uint32(bitsPerSample/8)
+	byteRate := sampleRate * uint32(blockAlign)
+	audioFormat := format.audioFormat()
 
-	// Write RIFF header
-	if err := writeString(bw, "RIFF"); err != nil {
+	extensible := mask != 0 || format.validBits() != int(bitsPerSample)
+	fmtSize := uint32(16)
+	if extensible {
+		fmtSize = 40
+	}
+	riffSize := 4 + (8 + ds64Size) + (8 + uint64(fmtSize)) + 8 + dataSize + dataSize%2
+	large := riffSize > sizeLimit
+
+	// RIFF header
+	riffID, riffSize32, dataSize32 := "RIFF", uint32(riffSize), uint32(dataSize)
+	if large {
+		riffID, riffSize32, dataSize32 = "RF64", riffLimit, riffLimit
+	}
+	if err := writeString(w, riffID); err != nil {
 		return fmt.Errorf("failed to write RIFF header: %w", err)
 	}
-	// File size - 8 (will be updated at the end if needed)
-	if err := binary.Write(bw, binary.LittleEndian, uint32(36+dataSize)); err != nil {
+	if err := binary.Write(w, binary.LittleEndian, riffSize32); err != nil {
 		return fmt.Errorf("failed to write file size: %w", err)
 	}
-	if err := writeString(bw, "WAVE"); err != nil {
+	if err := writeString(w, "WAVE"); err != nil {
 		return fmt.Errorf("failed to write WAVE header: %w", err)
 	}
 
-	// Write fmt chunk
-	if err := writeString(bw, "fmt "); err != nil {
+	// ds64 chunk, or the JUNK chunk reserving its space
+	ds64 := make([]byte, 0, 8+ds64Size)
+	if large {
+		ds64 = append(ds64, "ds64"...)
+		ds64 = binary.LittleEndian.AppendUint32(ds64, ds64Size)
+		ds64 = binary.LittleEndian.AppendUint64(ds64, riffSize)
+		ds64 = binary.LittleEndian.AppendUint64(ds64, dataSize)
+		ds64 = binary.LittleEndian.AppendUint64(ds64, dataSize/uint64(blockAlign))
+		ds64 = binary.LittleEndian.AppendUint32(ds64, 0)
+	} else {
+		ds64 = append(ds64, "JUNK"...)
+		ds64 = binary.LittleEndian.AppendUint32(ds64, ds64Size)
+		ds64 = append(ds64, make([]byte, ds64Size)...)
+	}
+	if _, err := w.Write(ds64); err != nil {
+		return fmt.Errorf("failed to write ds64 chunk: %w", err)
+	}
+
+	// fmt chunk
+	if err := writeString(w, "fmt "); err != nil {
 		return fmt.Errorf("failed to write fmt chunk ID: %w", err)
 	}
-	if err := binary.Write(bw, binary.LittleEndian, uint32(16)); err != nil { // fmt chunk size
+	if err := binary.Write(w, binary.LittleEndian, fmtSize); err != nil {
 		return fmt.Errorf("failed to write fmt chunk size: %w", err)
 	}
-	if err := binary.Write(bw, binary.LittleEndian, audioFormat); err != nil {
+	formatTag := audioFormat
+	if extensible {
+		formatTag = waveFormatExtensible
+	}
+	if err := binary.Write(w, binary.LittleEndian, formatTag); err != nil {
 		return fmt.Errorf("failed to write audio format: %w", err)
 	}
-	if err := binary.Write(bw, binary.LittleEndian, numChannels); err != nil {
+	if err := binary.Write(w, binary.LittleEndian, numChannels); err != nil {
 		return fmt.Errorf("failed to write num channels: %w", err)
 	}
-	if err := binary.Write(bw, binary.LittleEndian, data.SampleRate); err != nil {
+	if err := binary.Write(w, binary.LittleEndian, sampleRate); err != nil {
 		return fmt.Errorf("failed to write sample rate: %w", err)
 	}
-	if err := binary.Write(bw, binary.LittleEndian, byteRate); err != nil {
+	if err := binary.Write(w, binary.LittleEndian, byteRate); err != nil {
 		return fmt.Errorf("failed to write byte rate: %w", err)
 	}
-	if err := binary.Write(bw, binary.LittleEndian, blockAlign); err != nil {
+	if err := binary.Write(w, binary.LittleEndian, blockAlign); err != nil {
 		return fmt.Errorf("failed to write block align: %w", err)
 	}
-	if err := binary.Write(bw, binary.LittleEndian, bitsPerSample); err != nil {
+	if err := binary.Write(w, binary.LittleEndian, bitsPerSample); err != nil {
 		return fmt.Errorf("failed to write bits per sample: %w", err)
 	}
 
-	// Write data chunk
-	if err := writeString(bw, "data"); err != nil {
+	if extensible {
+		// cbSize, valid bits, channel mask and the sub-format GUID, whose
+		// first two bytes are the plain audio format tag.
+		extension := []any{uint16(22), uint16(format.validBits()), uint32(mask), audioFormat, extensibleGUIDTail}
+		for _, v := range extension {
+			if err := binary.Write(w, binary.LittleEndian, v); err != nil {
+				return fmt.Errorf("failed to write fmt extension: %w", err)
+			}
+		}
+	}
+
+	// data chunk
+	if err := writeString(w, "data"); err != nil {
 		return fmt.Errorf("failed to write data chunk ID: %w", err)
 	}
-	if err := binary.Write(bw, binary.LittleEndian, dataSize); err != nil {
+	if err := binary.Write(w, binary.LittleEndian, dataSize32); err != nil {
 		return fmt.Errorf("failed to write data size: %w", err)
 	}
 
-	// Write interleaved float32 samples
-	for i := 0; i < data.NumSamples; i++ {
-		for ch := 0; ch < channels; ch++ {
-			val := data.Samples[ch][i]
-			// Clamp to [-1.0, 1.0] to prevent invalid float values
-			if val > 1.0 {
-				val = 1.0
-			} else if val < -1.0 {
-				val = -1.0
-			} else if math.IsNaN(val) || math.IsInf(val, 0) {
-				val = 0.0
-			}
+	return nil
+}
 
-			if err := binary.Write(bw, binary.LittleEndian, float32(val)); err != nil {
-				return fmt.Errorf("failed to write sample data: %w", err)
-			}
+// appendSample encodes one little-endian sample of channel ch in the given
+// format. Integer formats are dithered by q, if not nil.
+func appendSample(buf []byte, v float64, format SampleFormat, q *quantizer, ch int) []byte {
+	switch format {
+	case FormatFloat32, FormatFloat64:
+		// Float samples keep their full range (see Writer.SetClip); only
+		// non-finite values are replaced.
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			v = 0.0
+		}
+		if format == FormatFloat64 {
+			return binary.LittleEndian.AppendUint64(buf, math.Float64bits(v))
 		}
+		return binary.LittleEndian.AppendUint32(buf, math.Float32bits(float32(v)))
+	case FormatPCM8:
+		// 8-bit PCM is unsigned with its zero at 128.
+		return append(buf, byte(q.toPCM(v, ch, 8)+128))
+	case FormatPCM20:
+		// Valid bits are left-justified in the container.
+		s := q.toPCM(v, ch, 20) << 4
+		return append(buf, byte(s), byte(s>>8), byte(s>>16))
+	case FormatPCM24:
+		s := q.toPCM(v, ch, 24)
+		return append(buf, byte(s), byte(s>>8), byte(s>>16))
+	case FormatPCM32:
+		return binary.LittleEndian.AppendUint32(buf, uint32(q.toPCM(v, ch, 32)))
+	default:
+		return binary.LittleEndian.AppendUint16(buf, uint16(q.toPCM(v, ch, 16)))
 	}
+}
 
-	if err := bw.Flush(); err != nil {
-		return fmt.Errorf("failed to flush WAV data: %w", err)
+// writePadByte writes the zero byte that keeps the chunk after an odd-sized
+// data chunk word-aligned.
+func writePadByte(w io.Writer, dataSize uint64) error {
+	if dataSize%2 == 0 {
+		return nil
+	}
+	if _, err := w.Write([]byte{0}); err != nil {
+		return fmt.Errorf("failed to write pad byte: %w", err)
 	}
-
 	return nil
 }
 
@@ -290,211 +472,73 @@ func writeString(w io.Writer, s string) error {
 	return err
 }
 
+// waveFormatExtensible is the WAVE_FORMAT_EXTENSIBLE format tag.
+const waveFormatExtensible = 0xFFFE
+
+// extensibleGUIDTail is the part of the KSDATAFORMAT_SUBTYPE GUIDs after the
+// format tag: xxxx0000-0000-0010-8000-00aa00389b71.
+var extensibleGUIDTail = [14]byte{0x00, 0x00, 0x00, 0x00, 0x10, 0x00, 0x80, 0x00, 0x00, 0xaa, 0x00, 0x38, 0x9b, 0x71}
+
 type wavFormat struct {
+	// audioFormat is the plain format tag; for WAVE_FORMAT_EXTENSIBLE it is
+	// taken from the sub-format GUID.
 	audioFormat   uint16
 	numChannels   uint16
 	sampleRate    uint32
 	byteRate      uint32
 	blockAlign    uint16
 	bitsPerSample uint16
+	// validBits is the number of significant bits of each sample; it equals
+	// bitsPerSample unless the header is extensible.
+	validBits   uint16
+	channelMask ChannelMask
 }
 
 func readWAV(r io.Reader, expectedChannels int) (*AudioData, error) {
-	br := bufio.NewReader(r)
-
-	var riff [4]byte
-	if _, err := io.ReadFull(br, riff[:]); err != nil {
-		return nil, fmt.Errorf("read RIFF header: %w", err)
-	}
-	if string(riff[:]) != "RIFF" {
-		return nil, fmt.Errorf("not a RIFF file")
+	reader, err := NewReader(r, expectedChannels)
+	if err != nil {
+		return nil, err
 	}
 
-	var _riffSize uint32
-	if err := binary.Read(br, binary.LittleEndian, &_riffSize); err != nil {
-		return nil, fmt.Errorf("read RIFF size: %w", err)
+	numFrames := reader.NumSamples()
+	samplesByChannel := make([][]float64, expectedChannels)
+	for ch := 0; ch < expectedChannels; ch++ {
+		samplesByChannel[ch] = make([]float64, numFrames)
 	}
 
-	var wave [4]byte
-	if _, err := io.ReadFull(br, wave[:]); err != nil {
-		return nil, fmt.Errorf("read WAVE header: %w", err)
-	}
-	if string(wave[:]) != "WAVE" {
-		return nil, fmt.Errorf("not a WAVE file")
+	if _, err := reader.ReadFrames(samplesByChannel); err != nil && err != io.EOF {
+		return nil, err
 	}
 
-	var fmtChunk *wavFormat
-	for {
-		var chunkID [4]byte
-		if _, err := io.ReadFull(br, chunkID[:]); err != nil {
-			if err == io.EOF {
-				break
-			}
-			return nil, fmt.Errorf("read chunk id: %w", err)
-		}
-		var chunkSize uint32
-		if err := binary.Read(br, binary.LittleEndian, &chunkSize); err != nil {
-			return nil, fmt.Errorf("read chunk size: %w", err)
-		}
-
-		switch string(chunkID[:]) {
-		case "fmt ":
-			if chunkSize < 16 {
-				return nil, fmt.Errorf("invalid fmt chunk size %d", chunkSize)
-			}
-			f := &wavFormat{}
-			if err := binary.Read(br, binary.LittleEndian, &f.audioFormat); err != nil {
-				return nil, fmt.Errorf("read audio format: %w", err)
-			}
-			if err := binary.Read(br, binary.LittleEndian, &f.numChannels); err != nil {
-				return nil, fmt.Errorf("read num channels: %w", err)
-			}
-			if err := binary.Read(br, binary.LittleEndian, &f.sampleRate); err != nil {
-				return nil, fmt.Errorf("read sample rate: %w", err)
-			}
-			if err := binary.Read(br, binary.LittleEndian, &f.byteRate); err != nil {
-				return nil, fmt.Errorf("read byte rate: %w", err)
-			}
-			if err := binary.Read(br, binary.LittleEndian, &f.blockAlign); err != nil {
-				return nil, fmt.Errorf("read block align: %w", err)
-			}
-			if err := binary.Read(br, binary.LittleEndian, &f.bitsPerSample); err != nil {
-				return nil, fmt.Errorf("read bits per sample: %w", err)
-			}
-
-			remaining := int64(chunkSize) - 16
-			if remaining > 0 {
-				if _, err := io.CopyN(io.Discard, br, remaining); err != nil {
-					return nil, fmt.Errorf("skip fmt extension: %w", err)
-				}
-			}
-
-			fmtChunk = f
-
-		case "data":
-			if fmtChunk == nil {
-				return nil, fmt.Errorf("data chunk before fmt chunk")
-			}
-			if int(fmtChunk.numChannels) != expectedChannels {
-				return nil, fmt.Errorf("input must have %d channels, got %d channels", expectedChannels, fmtChunk.numChannels)
-			}
-			if fmtChunk.blockAlign == 0 {
-				return nil, fmt.Errorf("invalid blockAlign=0")
-			}
-			if chunkSize%uint32(fmtChunk.blockAlign) != 0 {
-				return nil, fmt.Errorf("data chunk not aligned to block size")
-			}
-
-			numFrames := int(chunkSize / uint32(fmtChunk.blockAlign))
-			samplesByChannel := make([][]float64, expectedChannels)
-			for ch := 0; ch < expectedChannels; ch++ {
-				samplesByChannel[ch] = make([]float64, numFrames)
-			}
-
-			switch fmtChunk.audioFormat {
-			case 1: // PCM
-				switch fmtChunk.bitsPerSample {
-				case 16:
-					for i := range numFrames {
-						for ch := range expectedChannels {
-							var v int16
-							if err := binary.Read(br, binary.LittleEndian, &v); err != nil {
-								return nil, fmt.Errorf("read PCM16 sample: %w", err)
-							}
-							samplesByChannel[ch][i] = float64(v) / 32768.0
-						}
-					}
-				case 24:
-					for i := range numFrames {
-						for ch := range expectedChannels {
-							v, err := readPCM24Sample(br)
-							if err != nil {
-								return nil, fmt.Errorf("read PCM24 sample: %w", err)
-							}
-							samplesByChannel[ch][i] = float64(v) / 8388608.0
-						}
-					}
-				default:
-					return nil, fmt.Errorf("unsupported PCM bit depth %d", fmtChunk.bitsPerSample)
-				}
-
-			case 3: // IEEE float
-				if fmtChunk.bitsPerSample != 32 {
-					return nil, fmt.Errorf("unsupported IEEE float bit depth %d", fmtChunk.bitsPerSample)
-				}
-				for i := 0; i < numFrames; i++ {
-					for ch := 0; ch < expectedChannels; ch++ {
-						var v float32
-						if err := binary.Read(br, binary.LittleEndian, &v); err != nil {
-							return nil, fmt.Errorf("read float32 sample: %w", err)
-						}
-						fv := float64(v)
-						if math.IsNaN(fv) || math.IsInf(fv, 0) {
-							fv = 0
-						}
-						if fv > 1.0 {
-							fv = 1.0
-						} else if fv < -1.0 {
-							fv = -1.0
-						}
-						samplesByChannel[ch][i] = fv
-					}
-				}
-
-			default:
-				return nil, fmt.Errorf("unsupported WAV audio format %d", fmtChunk.audioFormat)
-			}
-
-			// Chunks are word-aligned; if size is odd, a pad byte follows.
-			if chunkSize%2 == 1 {
-				if _, err := br.ReadByte(); err != nil {
-					return nil, fmt.Errorf("read data pad byte: %w", err)
-				}
-			}
-
-			return &AudioData{
-				SampleRate: fmtChunk.sampleRate,
-				Samples:    samplesByChannel,
-				NumSamples: numFrames,
-			}, nil
-
-		default:
-			// Skip unknown chunk (plus pad byte if needed)
-			if _, err := io.CopyN(io.Discard, br, int64(chunkSize)); err != nil {
-				return nil, fmt.Errorf("skip chunk %q: %w", string(chunkID[:]), err)
-			}
-			if chunkSize%2 == 1 {
-				if _, err := br.ReadByte(); err != nil {
-					return nil, fmt.Errorf("read pad byte: %w", err)
-				}
-			}
-		}
-	}
-
-	return nil, fmt.Errorf("no data chunk found")
+	return &AudioData{
+		SampleRate: reader.SampleRate(),
+		Samples:    samplesByChannel,
+		NumSamples: numFrames,
+		overs:      reader.Overs(),
+		peak:       reader.Peak(),
+	}, nil
 }
 
-func floatToPCM16(v float64) int16 {
+// floatToPCM converts v to a signed integer sample of the given bit depth,
+// scaled by 2^(bits-1)-1 and clamped to the full integer range.
+func floatToPCM(v float64, bits int) int32 {
 	if math.IsNaN(v) || math.IsInf(v, 0) {
 		v = 0
 	}
+	maxValue := float64(int64(1)<<(bits-1) - 1)
 	if v >= 1.0 {
-		return 32767
+		return int32(maxValue)
 	}
 	if v <= -1.0 {
-		return -32768
+		return int32(-maxValue - 1)
 	}
-	return int16(math.Round(v * 32767.0))
+	return int32(math.Round(v * maxValue))
 }
 
-func readPCM24Sample(r io.Reader) (int32, error) {
-	var b [3]byte
-	if _, err := io.ReadFull(r, b[:]); err != nil {
-		return 0, err
-	}
+func pcm24FromBytes(b []byte) int32 {
 	v := int32(b[0]) | int32(b[1])<<8 | int32(b[2])<<16
 	if v&0x800000 != 0 {
 		v |= ^0xffffff
 	}
-	return v, nil
+	return v
 }
@@ -1,6 +1,9 @@
 package wav
 
 import (
+	"bytes"
+	"errors"
+	"io"
 	"math"
 	"path/filepath"
 	"testing"
@@ -75,3 +78,60 @@ func TestReadWAVChannels_ChannelMismatch(t *testing.T) {
 		t.Fatalf("ReadWAVChannels() expected error, got nil")
 	}
 }
+
+func TestSampleFormats_RoundTrip(t *testing.T) {
+	t.Parallel()
+
+	in := &AudioData{
+		SampleRate: 48000,
+		Samples: [][]float64{
+			{0, 0.5, -0.5, 0.999, -1, 0.123456789},
+			{0.25, -0.25, 0.75, -0.75, 0.001, -0.987654321},
+		},
+		NumSamples: 6,
+	}
+
+	for _, name := range FormatNames() {
+		format, err := LookupFormat(name)
+		if err != nil {
+			t.Fatalf("LookupFormat(%q) error = %v", name, err)
+		}
+		if format.String() != name {
+			t.Errorf("%s: String() = %q", name, format.String())
+		}
+
+		var buf bytes.Buffer
+		if err := writeWAVToWriter(&buf, in, 2, 0, format, DitherConfig{}); err != nil {
+			t.Fatalf("%s: writeWAVToWriter() error = %v", name, err)
+		}
+		r, err := NewReader(&buf, 2)
+		if err != nil {
+			t.Fatalf("%s: NewReader() error = %v", name, err)
+		}
+		if r.ValidBits() != format.validBits() {
+			t.Errorf("%s: ValidBits() = %d, want %d", name, r.ValidBits(), format.validBits())
+		}
+		out := [][]float64{make([]float64, in.NumSamples), make([]float64, in.NumSamples)}
+		if _, err := r.ReadFrames(out); err != nil && !errors.Is(err, io.EOF) {
+			t.Fatalf("%s: ReadFrames() error = %v", name, err)
+		}
+
+		// Two LSBs of the valid bits (writing scales by 2^(bits-1)-1, reading
+		// by 2^(bits-1)); float32 keeps 24 bits of mantissa.
+		tol := math.Ldexp(2, 1-min(format.validBits(), 24))
+		if format == FormatFloat64 {
+			tol = 0
+		}
+		for ch := range out {
+			for i, got := range out[ch] {
+				if want := in.Samples[ch][i]; math.Abs(got-want) > tol {
+					t.Errorf("%s: sample[%d][%d] = %.10f, want %.10f", name, ch, i, got, want)
+				}
+			}
+		}
+	}
+
+	if _, err := LookupFormat("pcm12"); err == nil {
+		t.Error("LookupFormat(pcm12) error = nil")
+	}
+}
@@ -10,14 +10,18 @@ import (
 	"syscall/js"
 
 	"github.com/cwbudde/go-sq-tool/internal/decoder"
+	"github.com/cwbudde/go-sq-tool/internal/matrix"
 	"github.com/cwbudde/go-sq-tool/internal/wav"
 )
 
 type decodeOptions struct {
-	BlockSize int
-	Overlap   int
-	Logic     bool
-	Float32   bool
+	BlockSize    int
+	Overlap      int
+	Logic        bool
+	WaveMatching bool
+	Float32      bool
+	Matrix       string
+	Clip         string
 }
 
 var decodeFunc js.Func
@@ -53,6 +57,7 @@ func parseOptions(args []js.Value) decodeOptions {
 	opts := decodeOptions{
 		BlockSize: decoder.DefaultBlockSize,
 		Overlap:   decoder.DefaultOverlap,
+		Clip:      wav.ClipHard.String(),
 	}
 	if len(args) < 2 {
 		return opts
@@ -70,9 +75,18 @@ func parseOptions(args []js.Value) decodeOptions {
 	if v := raw.Get("logic"); v.Type() == js.TypeBoolean {
 		opts.Logic = v.Bool()
 	}
+	if v := raw.Get("waveMatching"); v.Type() == js.TypeBoolean {
+		opts.WaveMatching = v.Bool()
+	}
+	if v := raw.Get("matrix"); v.Type() == js.TypeString {
+		opts.Matrix = v.String()
+	}
 	if v := raw.Get("float32"); v.Type() == js.TypeBoolean {
 		opts.Float32 = v.Bool()
 	}
+	if v := raw.Get("clip"); v.Type() == js.TypeString {
+		opts.Clip = v.String()
+	}
 	return opts
 }
 
@@ -99,6 +113,19 @@ func decodeWavBytes(input []byte, opts decodeOptions) ([]byte, error) {
 	if len(input) == 0 {
 		return nil, errors.New("empty input")
 	}
+	if opts.Logic && opts.WaveMatching {
+		return nil, errors.New("use either logic steering or wave matching, not both")
+	}
+	if opts.BlockSize <= 0 || opts.BlockSize&(opts.BlockSize-1) != 0 {
+		return nil, fmt.Errorf("block size must be a power of 2, got %d", opts.BlockSize)
+	}
+	if opts.Overlap <= 0 || opts.Overlap > opts.BlockSize/2 {
+		return nil, fmt.Errorf("overlap must be in (0, %d] for block size %d, got %d", opts.BlockSize/2, opts.BlockSize, opts.Overlap)
+	}
+	clip, err := wav.LookupClip(opts.Clip)
+	if err != nil {
+		return nil, err
+	}
 
 	audioData, err := wav.ReadWAVBytes(input, 2)
 	if err != nil {
@@ -107,9 +134,19 @@ func decodeWavBytes(input []byte, opts decodeOptions) ([]byte, error) {
 
 	sqDecoder := decoder.NewSQDecoderWithParams(opts.BlockSize, opts.Overlap)
 	sqDecoder.SetSampleRate(int(audioData.SampleRate))
+	if opts.Matrix != "" {
+		m, err := matrix.Lookup(opts.Matrix)
+		if err != nil {
+			return nil, err
+		}
+		sqDecoder.SetMatrix(m)
+	}
 	if opts.Logic {
 		sqDecoder.EnableLogicSteering(true)
 	}
+	if opts.WaveMatching {
+		sqDecoder.EnableWaveMatching(true)
+	}
 
 	output, err := sqDecoder.Process(audioData.Samples)
 	if err != nil {
@@ -122,6 +159,10 @@ func decodeWavBytes(input []byte, opts decodeOptions) ([]byte, error) {
 		NumSamples: audioData.NumSamples,
 	}
 
+	// The page plays the result back directly, so the default hard clip
+	// keeps the float output within full scale.
+	wav.ClipSamples(outputData.Samples, clip)
+
 	var buf bytes.Buffer
 	if opts.Float32 {
 		if err := wav.WriteFloat32WAVToWriter(&buf, outputData); err != nil {
@@ -0,0 +1,196 @@
+package sqmath
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// PhaseNetworkCoefficients holds the section coefficients of a 90° phase
+// difference network. The Direct path yields the reference signal and the
+// Shifted path (which runs one sample behind) the same signal shifted by
+// -90°, i.e. the Hilbert transform of the Direct output.
+type PhaseNetworkCoefficients struct {
+	Direct  []float64
+	Shifted []float64
+}
+
+// NiemitaloCoefficients is the 4+4 section network published by Olli
+// Niemitalo ("Hilbert transform", yehar.com). The published values are the
+// square roots of the section coefficients. At 44.1 kHz the phase difference
+// stays within 0.7° of 90° from 20 Hz to 22 kHz.
+var NiemitaloCoefficients = PhaseNetworkCoefficients{
+	Direct:  squared(0.4021921162426, 0.8561710882420, 0.9722909545651, 0.9952884791278),
+	Shifted: squared(0.6923878, 0.9360654322959, 0.9882295226860, 0.9987488452737),
+}
+
+func squared(values ...float64) []float64 {
+	out := make([]float64, len(values))
+	for i, v := range values {
+		out[i] = v * v
+	}
+	return out
+}
+
+// allpassSection is a second-order all-pass H(z) = (c - z^-2) / (1 - c·z^-2).
+type allpassSection struct {
+	c      float64
+	x1, x2 float64
+	y1, y2 float64
+}
+
+func (s *allpassSection) process(x float64) float64 {
+	y := s.c*(x+s.y2) - s.x2
+	s.x2 = s.x1
+	s.x1 = x
+	s.y2 = s.y1
+	s.y1 = y
+	return y
+}
+
+// PhaseDifferenceNetwork is a pair of cascaded IIR all-pass chains whose
+// outputs differ by 90° over the design band. Both outputs have unity gain
+// at all frequencies and the network adds no block latency.
+type PhaseDifferenceNetwork struct {
+	direct  []allpassSection
+	shifted []allpassSection
+	prev    float64
+}
+
+// NewPhaseDifferenceNetwork creates a network from a coefficient set.
+func NewPhaseDifferenceNetwork(coefs PhaseNetworkCoefficients) *PhaseDifferenceNetwork {
+	p := &PhaseDifferenceNetwork{
+		direct:  make([]allpassSection, len(coefs.Direct)),
+		shifted: make([]allpassSection, len(coefs.Shifted)),
+	}
+	for i, c := range coefs.Direct {
+		p.direct[i].c = c
+	}
+	for i, c := range coefs.Shifted {
+		p.shifted[i].c = c
+	}
+	return p
+}
+
+// Process filters one sample and returns the direct and 90° shifted outputs.
+func (p *PhaseDifferenceNetwork) Process(x float64) (float64, float64) {
+	direct := x
+	for i := range p.direct {
+		direct = p.direct[i].process(direct)
+	}
+
+	shifted := p.prev
+	p.prev = x
+	for i := range p.shifted {
+		shifted = p.shifted[i].process(shifted)
+	}
+
+	return direct, shifted
+}
+
+// Reset clears the filter state.
+func (p *PhaseDifferenceNetwork) Reset() {
+	for i := range p.direct {
+		p.direct[i] = allpassSection{c: p.direct[i].c}
+	}
+	for i := range p.shifted {
+		p.shifted[i] = allpassSection{c: p.shifted[i].c}
+	}
+	p.prev = 0
+}
+
+// maxPhaseNetworkSections bounds the number of sections DesignPhaseNetwork
+// may return per path.
+const maxPhaseNetworkSections = 32
+
+// DesignPhaseNetwork designs a phase difference network whose outputs stay
+// within rippleDeg degrees of 90° from lowFreq to sampleRate/2 - lowFreq,
+// using the fewest sections that meet the specification. The design follows
+// the elliptic half-band method of Laurent de Soras' HIIR library.
+func DesignPhaseNetwork(lowFreq, sampleRate, rippleDeg float64) (PhaseNetworkCoefficients, error) {
+	if sampleRate <= 0 {
+		return PhaseNetworkCoefficients{}, errors.New("sample rate must be > 0")
+	}
+	if lowFreq <= 0 || lowFreq >= sampleRate/4 {
+		return PhaseNetworkCoefficients{}, fmt.Errorf("low frequency must be in (0, %g) Hz, got %g", sampleRate/4, lowFreq)
+	}
+	if rippleDeg <= 0 || rippleDeg >= 90 {
+		return PhaseNetworkCoefficients{}, fmt.Errorf("phase ripple must be in (0, 90) degrees, got %g", rippleDeg)
+	}
+
+	transition := lowFreq / sampleRate
+	k, q := halfBandTransitionParams(transition)
+
+	// A phase error e between the paths leaves sin(e/2) of the stopband
+	// in the equivalent half-band filter.
+	stopband := math.Sin(rippleDeg * math.Pi / 180.0 / 2.0)
+	a := stopband * stopband / (1.0 - stopband*stopband)
+	order := int(math.Ceil(math.Log(a*a/16.0) / math.Log(q)))
+	if order%2 == 0 {
+		order++
+	}
+	order = max(order, 3)
+
+	numCoefs := (order - 1) / 2
+	if sections := (numCoefs + 1) / 2; sections > maxPhaseNetworkSections {
+		return PhaseNetworkCoefficients{}, fmt.Errorf("design needs %d sections per path, more than %d", sections, maxPhaseNetworkSections)
+	}
+
+	// Coefficients ascend and alternate between the direct and shifted paths.
+	var coefs PhaseNetworkCoefficients
+	for i := 0; i < numCoefs; i++ {
+		c := halfBandCoefficient(i, k, q, order)
+		if i%2 == 0 {
+			coefs.Direct = append(coefs.Direct, c)
+		} else {
+			coefs.Shifted = append(coefs.Shifted, c)
+		}
+	}
+	return coefs, nil
+}
+
+// halfBandTransitionParams returns the elliptic modulus k and nome q for a
+// half-band filter with the given normalized transition bandwidth.
+func halfBandTransitionParams(transition float64) (float64, float64) {
+	k := math.Tan((1.0 - transition*2.0) * math.Pi / 4.0)
+	k *= k
+	kksqrt := math.Pow(1.0-k*k, 0.25)
+	e := 0.5 * (1.0 - kksqrt) / (1.0 + kksqrt)
+	e2 := e * e
+	e4 := e2 * e2
+	q := e * (1.0 + e4*(2.0+e4*(15.0+150.0*e4)))
+	return k, q
+}
+
+// halfBandCoefficient returns the all-pass coefficient of section index.
+func halfBandCoefficient(index int, k, q float64, order int) float64 {
+	c := float64(index + 1)
+
+	num := 0.0
+	sign := 1.0
+	for i := 0; ; i++ {
+		term := math.Pow(q, float64(i*(i+1))) * math.Sin(float64(2*i+1)*c*math.Pi/float64(order)) * sign
+		num += term
+		sign = -sign
+		if math.Abs(term) <= 1e-100 {
+			break
+		}
+	}
+	num *= math.Pow(q, 0.25)
+
+	den := 0.5
+	sign = -1.0
+	for i := 1; ; i++ {
+		term := math.Pow(q, float64(i*i)) * math.Cos(float64(2*i)*c*math.Pi/float64(order)) * sign
+		den += term
+		sign = -sign
+		if math.Abs(term) <= 1e-100 {
+			break
+		}
+	}
+
+	ww := num / den
+	wwsq := ww * ww
+	x := math.Sqrt((1.0-wwsq*k)*(1.0-wwsq/k)) / (1.0 + wwsq)
+	return (1.0 - x) / (1.0 + x)
+}
@@ -0,0 +1,105 @@
+package sqmath_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/cwbudde/go-sq-tool/pkg/sqmath"
+)
+
+// measurePhaseNetwork feeds a sine at freq Hz through a network and returns
+// the gains of both outputs and their phase difference in degrees.
+func measurePhaseNetwork(coefs sqmath.PhaseNetworkCoefficients, freq, sampleRate float64) (float64, float64, float64) {
+	network := sqmath.NewPhaseDifferenceNetwork(coefs)
+
+	// Settle for one second, then fit over one second (an integer number of
+	// cycles for integer frequencies).
+	n := int(sampleRate)
+	var directCos, directSin, shiftedCos, shiftedSin float64
+	for i := 0; i < 2*n; i++ {
+		phase := 2.0 * math.Pi * freq * float64(i) / sampleRate
+		direct, shifted := network.Process(math.Sin(phase))
+		if i < n {
+			continue
+		}
+		directCos += direct * math.Cos(phase)
+		directSin += direct * math.Sin(phase)
+		shiftedCos += shifted * math.Cos(phase)
+		shiftedSin += shifted * math.Sin(phase)
+	}
+
+	scale := 2.0 / float64(n)
+	directGain := math.Hypot(directCos, directSin) * scale
+	shiftedGain := math.Hypot(shiftedCos, shiftedSin) * scale
+	diff := math.Atan2(shiftedCos, shiftedSin) - math.Atan2(directCos, directSin)
+	diff = math.Remainder(diff, 2.0*math.Pi) * 180.0 / math.Pi
+	return directGain, shiftedGain, diff
+}
+
+func TestPhaseDifferenceNetwork_Niemitalo(t *testing.T) {
+	t.Parallel()
+
+	const sampleRate = 44100.0
+
+	for _, freq := range []float64{20, 50, 200, 1000, 5000, 15000, 22000} {
+		directGain, shiftedGain, diff := measurePhaseNetwork(sqmath.NiemitaloCoefficients, freq, sampleRate)
+		if math.Abs(directGain-1) > 1e-3 || math.Abs(shiftedGain-1) > 1e-3 {
+			t.Fatalf("%.0f Hz: gains %.4f/%.4f, want 1", freq, directGain, shiftedGain)
+		}
+		// The shifted output lags the direct output by 90°.
+		if math.Abs(diff+90) > 0.75 {
+			t.Fatalf("%.0f Hz: phase difference %.3f°, want -90 ± 0.75", freq, diff)
+		}
+	}
+}
+
+func TestDesignPhaseNetwork_MeetsSpecification(t *testing.T) {
+	t.Parallel()
+
+	const sampleRate = 48000.0
+
+	tests := []struct {
+		lowFreq float64
+		ripple  float64
+	}{
+		{lowFreq: 20, ripple: 1},
+		{lowFreq: 20, ripple: 0.1},
+		{lowFreq: 100, ripple: 0.5},
+	}
+
+	sections := make([]int, len(tests))
+	for i, tc := range tests {
+		coefs, err := sqmath.DesignPhaseNetwork(tc.lowFreq, sampleRate, tc.ripple)
+		if err != nil {
+			t.Fatalf("DesignPhaseNetwork(%g, %g): %v", tc.lowFreq, tc.ripple, err)
+		}
+		sections[i] = len(coefs.Direct) + len(coefs.Shifted)
+
+		for _, freq := range []float64{tc.lowFreq, 1000, sampleRate/2 - tc.lowFreq} {
+			_, _, diff := measurePhaseNetwork(coefs, freq, sampleRate)
+			if math.Abs(diff+90) > tc.ripple {
+				t.Fatalf("low %g Hz, ripple %g°: phase difference at %.0f Hz is %.3f°", tc.lowFreq, tc.ripple, freq, diff)
+			}
+		}
+	}
+
+	if sections[1] <= sections[0] {
+		t.Fatalf("tighter ripple should need more sections, got %d vs %d", sections[1], sections[0])
+	}
+}
+
+func TestDesignPhaseNetwork_RejectsInvalidSpecification(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range [][3]float64{
+		{0, 44100, 1},
+		{20000, 44100, 1},
+		{20, 0, 1},
+		{20, 44100, 0},
+		{20, 44100, 90},
+	} {
+		if _, err := sqmath.DesignPhaseNetwork(tc[0], tc[1], tc[2]); err == nil {
+			t.Fatalf("DesignPhaseNetwork(%g, %g, %g) succeeded, want error", tc[0], tc[1], tc[2])
+		}
+	}
+}
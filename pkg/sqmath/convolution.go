@@ -0,0 +1,120 @@
+package sqmath
+
+import (
+	"fmt"
+
+	algofft "github.com/MeKo-Christian/algo-fft"
+)
+
+// Convolver convolves a signal with an impulse response using uniformly
+// partitioned overlap-save FFT convolution. Each call to ProcessBlock
+// consumes and produces exactly one block, so the output has no delay with
+// respect to the input; the impulse response may be much longer than the
+// block.
+type Convolver struct {
+	blockSize  int
+	plan       *algofft.Plan[complex128]
+	partitions [][]complex128
+	// history holds the input spectra of the most recent blocks, newest
+	// first (frequency-domain delay line).
+	history [][]complex128
+	input   []float64
+	frame   []complex128
+	accum   []complex128
+}
+
+// NewConvolver creates a convolver for ir that processes blocks of
+// blockSize samples (a power of 2).
+func NewConvolver(ir []float64, blockSize int) (*Convolver, error) {
+	if blockSize <= 0 || blockSize&(blockSize-1) != 0 {
+		return nil, fmt.Errorf("block size must be a power of 2, got %d", blockSize)
+	}
+	fftSize := 2 * blockSize
+	plan, err := algofft.NewPlan64(fftSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create FFT plan: %w", err)
+	}
+
+	numPartitions := max(1, (len(ir)+blockSize-1)/blockSize)
+	c := &Convolver{
+		blockSize:  blockSize,
+		plan:       plan,
+		partitions: make([][]complex128, numPartitions),
+		history:    make([][]complex128, numPartitions),
+		input:      make([]float64, fftSize),
+		frame:      make([]complex128, fftSize),
+		accum:      make([]complex128, fftSize),
+	}
+
+	for p := range c.partitions {
+		for i := range c.frame {
+			c.frame[i] = 0
+		}
+		for i := 0; i < blockSize && p*blockSize+i < len(ir); i++ {
+			c.frame[i] = complex(ir[p*blockSize+i], 0)
+		}
+		c.partitions[p] = make([]complex128, fftSize)
+		if err := plan.Forward(c.partitions[p], c.frame); err != nil {
+			return nil, fmt.Errorf("FFT failed: %w", err)
+		}
+		c.history[p] = make([]complex128, fftSize)
+	}
+
+	return c, nil
+}
+
+// BlockSize returns the number of samples consumed and produced per block.
+func (c *Convolver) BlockSize() int {
+	return c.blockSize
+}
+
+// ProcessBlock convolves one block of input (BlockSize samples) and writes
+// the matching block of output to out. in and out may be the same slice.
+func (c *Convolver) ProcessBlock(in, out []float64) {
+	n := c.blockSize
+
+	// Slide the input window: the previous block followed by the new one.
+	copy(c.input, c.input[n:])
+	copy(c.input[n:], in[:n])
+	for i, v := range c.input {
+		c.frame[i] = complex(v, 0)
+	}
+
+	// Rotate the delay line so the oldest spectrum is overwritten.
+	last := c.history[len(c.history)-1]
+	copy(c.history[1:], c.history[:len(c.history)-1])
+	c.history[0] = last
+	if err := c.plan.Forward(c.history[0], c.frame); err != nil {
+		panic(err)
+	}
+
+	for i := range c.accum {
+		c.accum[i] = 0
+	}
+	for p, spectrum := range c.history {
+		h := c.partitions[p]
+		for i := range c.accum {
+			c.accum[i] += spectrum[i] * h[i]
+		}
+	}
+	if err := c.plan.Inverse(c.frame, c.accum); err != nil {
+		panic(err)
+	}
+
+	// The first half is circular wrap-around; the second half is valid.
+	for i := 0; i < n; i++ {
+		out[i] = real(c.frame[n+i])
+	}
+}
+
+// Reset clears the input history.
+func (c *Convolver) Reset() {
+	for i := range c.input {
+		c.input[i] = 0
+	}
+	for _, spectrum := range c.history {
+		for i := range spectrum {
+			spectrum[i] = 0
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package sqmath_test
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/cwbudde/go-sq-tool/pkg/sqmath"
+)
+
+func TestConvolver_MatchesDirectConvolution(t *testing.T) {
+	t.Parallel()
+
+	rng := rand.New(rand.NewSource(1))
+	for _, irLen := range []int{1, 37, 64, 300} {
+		const blockSize = 64
+		const n = 1000
+
+		ir := make([]float64, irLen)
+		for i := range ir {
+			ir[i] = rng.NormFloat64()
+		}
+		x := make([]float64, n)
+		for i := range x {
+			x[i] = rng.NormFloat64()
+		}
+
+		c, err := sqmath.NewConvolver(ir, blockSize)
+		if err != nil {
+			t.Fatalf("NewConvolver() error = %v", err)
+		}
+
+		// Zero-padded to whole blocks.
+		numBlocks := (n + blockSize - 1) / blockSize
+		in := make([]float64, numBlocks*blockSize)
+		copy(in, x)
+		out := make([]float64, len(in))
+		for b := 0; b < numBlocks; b++ {
+			c.ProcessBlock(in[b*blockSize:], out[b*blockSize:])
+		}
+
+		for i := 0; i < n; i++ {
+			want := 0.0
+			for j := 0; j < irLen && j <= i; j++ {
+				want += ir[j] * x[i-j]
+			}
+			if math.Abs(out[i]-want) > 1e-9 {
+				t.Fatalf("ir length %d: out[%d] = %.12f, want %.12f", irLen, i, out[i], want)
+			}
+		}
+	}
+}
+
+func TestNewConvolver_RejectsInvalidBlockSize(t *testing.T) {
+	t.Parallel()
+
+	for _, blockSize := range []int{0, -64, 100} {
+		if _, err := sqmath.NewConvolver([]float64{1}, blockSize); err == nil {
+			t.Errorf("NewConvolver(block size %d) succeeded", blockSize)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package sqmath
+
+import "math"
+
+// Biquad is a second-order IIR section in transposed direct form II.
+type Biquad struct {
+	b0, b1, b2 float64
+	a1, a2     float64
+	z1, z2     float64
+}
+
+// NewButterworthLowpass creates a 2nd-order Butterworth lowpass at freq Hz.
+func NewButterworthLowpass(freq, sampleRate float64) *Biquad {
+	w0, alpha := biquadParams(freq, sampleRate, math.Sqrt2/2.0)
+	cosW0 := math.Cos(w0)
+	a0 := 1.0 + alpha
+	return &Biquad{
+		b0: (1.0 - cosW0) / 2.0 / a0,
+		b1: (1.0 - cosW0) / a0,
+		b2: (1.0 - cosW0) / 2.0 / a0,
+		a1: -2.0 * cosW0 / a0,
+		a2: (1.0 - alpha) / a0,
+	}
+}
+
+// NewButterworthHighpass creates a 2nd-order Butterworth highpass at freq Hz.
+func NewButterworthHighpass(freq, sampleRate float64) *Biquad {
+	w0, alpha := biquadParams(freq, sampleRate, math.Sqrt2/2.0)
+	cosW0 := math.Cos(w0)
+	a0 := 1.0 + alpha
+	return &Biquad{
+		b0: (1.0 + cosW0) / 2.0 / a0,
+		b1: -(1.0 + cosW0) / a0,
+		b2: (1.0 + cosW0) / 2.0 / a0,
+		a1: -2.0 * cosW0 / a0,
+		a2: (1.0 - alpha) / a0,
+	}
+}
+
+// biquadParams returns the normalized angular frequency and bandwidth term
+// (RBJ cookbook). The frequency is kept safely below Nyquist.
+func biquadParams(freq, sampleRate, q float64) (float64, float64) {
+	freq = math.Min(math.Max(freq, 1.0), 0.49*sampleRate)
+	w0 := 2.0 * math.Pi * freq / sampleRate
+	return w0, math.Sin(w0) / (2.0 * q)
+}
+
+// Process filters one sample.
+func (b *Biquad) Process(x float64) float64 {
+	y := b.b0*x + b.z1
+	b.z1 = b.b1*x - b.a1*y + b.z2
+	b.z2 = b.b2*x - b.a2*y
+	return y
+}
+
+// Reset clears the filter state.
+func (b *Biquad) Reset() {
+	b.z1 = 0
+	b.z2 = 0
+}
+
+// LinkwitzRiley4 is a 4th-order Linkwitz-Riley crossover. Its low and high
+// outputs are in phase and sum to an all-pass response.
+type LinkwitzRiley4 struct {
+	lowpass  [2]*Biquad
+	highpass [2]*Biquad
+}
+
+// NewLinkwitzRiley4 creates a crossover at freq Hz.
+func NewLinkwitzRiley4(freq, sampleRate float64) *LinkwitzRiley4 {
+	return &LinkwitzRiley4{
+		lowpass: [2]*Biquad{
+			NewButterworthLowpass(freq, sampleRate),
+			NewButterworthLowpass(freq, sampleRate),
+		},
+		highpass: [2]*Biquad{
+			NewButterworthHighpass(freq, sampleRate),
+			NewButterworthHighpass(freq, sampleRate),
+		},
+	}
+}
+
+// Process splits one sample into its low and high band parts.
+func (c *LinkwitzRiley4) Process(x float64) (float64, float64) {
+	low := c.lowpass[1].Process(c.lowpass[0].Process(x))
+	high := c.highpass[1].Process(c.highpass[0].Process(x))
+	return low, high
+}
+
+// Reset clears the crossover state.
+func (c *LinkwitzRiley4) Reset() {
+	for i := range 2 {
+		c.lowpass[i].Reset()
+		c.highpass[i].Reset()
+	}
+}
+
+// CrossoverBank splits a signal into len(freqs)+1 bands using cascaded
+// Linkwitz-Riley crossovers. Lower bands are passed through the all-pass
+// response of the higher crossovers so that all bands stay phase aligned
+// and their sum is a single all-pass response.
+type CrossoverBank struct {
+	splits       []*LinkwitzRiley4
+	compensators [][]*LinkwitzRiley4
+}
+
+// NewCrossoverBank creates a band splitter with crossovers at freqs Hz (ascending).
+func NewCrossoverBank(freqs []float64, sampleRate float64) *CrossoverBank {
+	bank := &CrossoverBank{
+		splits:       make([]*LinkwitzRiley4, len(freqs)),
+		compensators: make([][]*LinkwitzRiley4, len(freqs)),
+	}
+	for i, f := range freqs {
+		bank.splits[i] = NewLinkwitzRiley4(f, sampleRate)
+		for _, higher := range freqs[i+1:] {
+			bank.compensators[i] = append(bank.compensators[i], NewLinkwitzRiley4(higher, sampleRate))
+		}
+	}
+	return bank
+}
+
+// NumBands returns the number of output bands.
+func (b *CrossoverBank) NumBands() int {
+	return len(b.splits) + 1
+}
+
+// Process splits one sample into bands (low to high). bands must have
+// NumBands entries.
+func (b *CrossoverBank) Process(x float64, bands []float64) {
+	rest := x
+	for i, split := range b.splits {
+		low, high := split.Process(rest)
+		for _, ap := range b.compensators[i] {
+			l, h := ap.Process(low)
+			low = l + h
+		}
+		bands[i] = low
+		rest = high
+	}
+	bands[len(b.splits)] = rest
+}
+
+// Reset clears the state of all crossovers.
+func (b *CrossoverBank) Reset() {
+	for i, split := range b.splits {
+		split.Reset()
+		for _, ap := range b.compensators[i] {
+			ap.Reset()
+		}
+	}
+}
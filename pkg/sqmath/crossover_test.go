@@ -0,0 +1,79 @@
+package sqmath_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/cwbudde/go-sq-tool/pkg/sqmath"
+)
+
+func TestCrossoverBank_SumIsAllPass(t *testing.T) {
+	t.Parallel()
+
+	const (
+		sampleRate = 44100.0
+		n          = 1 << 14
+	)
+
+	bank := sqmath.NewCrossoverBank([]float64{250, 2500}, sampleRate)
+	if got := bank.NumBands(); got != 3 {
+		t.Fatalf("NumBands() = %d, want 3", got)
+	}
+
+	// An all-pass keeps the energy of an impulse.
+	bands := make([]float64, bank.NumBands())
+	energy := 0.0
+	for i := 0; i < n; i++ {
+		x := 0.0
+		if i == 0 {
+			x = 1.0
+		}
+		bank.Process(x, bands)
+		sum := bands[0] + bands[1] + bands[2]
+		energy += sum * sum
+	}
+
+	if math.Abs(energy-1.0) > 1e-3 {
+		t.Fatalf("impulse energy of band sum = %.6f, want 1.0", energy)
+	}
+}
+
+func TestCrossoverBank_RoutesToneToBand(t *testing.T) {
+	t.Parallel()
+
+	const (
+		sampleRate = 44100.0
+		n          = 1 << 14
+		skip       = 4096
+	)
+
+	tests := []struct {
+		freq float64
+		band int
+	}{
+		{freq: 60, band: 0},
+		{freq: 800, band: 1},
+		{freq: 8000, band: 2},
+	}
+
+	for _, tc := range tests {
+		bank := sqmath.NewCrossoverBank([]float64{250, 2500}, sampleRate)
+		bands := make([]float64, bank.NumBands())
+		energies := make([]float64, bank.NumBands())
+		for i := 0; i < n; i++ {
+			bank.Process(math.Sin(2.0*math.Pi*tc.freq*float64(i)/sampleRate), bands)
+			if i < skip {
+				continue
+			}
+			for b, v := range bands {
+				energies[b] += v * v
+			}
+		}
+
+		for b, e := range energies {
+			if b != tc.band && e > 0.1*energies[tc.band] {
+				t.Fatalf("%.0f Hz: band %d energy %.3f too close to band %d energy %.3f", tc.freq, b, e, tc.band, energies[tc.band])
+			}
+		}
+	}
+}
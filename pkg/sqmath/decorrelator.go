@@ -0,0 +1,192 @@
+package sqmath
+
+import (
+	"math"
+	"math/rand/v2"
+)
+
+// Decorrelator is a filter whose output has the spectrum of its input but is
+// largely uncorrelated with it.
+type Decorrelator interface {
+	// Process filters one sample.
+	Process(x float64) float64
+	// Reset clears the filter state.
+	Reset()
+}
+
+// allpassDecorrelatorDelays are the nominal delays of the all-pass sections
+// in milliseconds: mutually prime-ish so that the echoes do not align.
+var allpassDecorrelatorDelays = []float64{1.3, 2.3, 3.7, 5.3, 7.1}
+
+// allpassDecorrelatorGain is the feedback gain of every all-pass section.
+const allpassDecorrelatorGain = 0.5
+
+// schroederAllpass is H(z) = (-g + z^-M) / (1 - g·z^-M).
+type schroederAllpass struct {
+	gain  float64
+	delay []float64
+	pos   int
+}
+
+func (s *schroederAllpass) process(x float64) float64 {
+	delayed := s.delay[s.pos]
+	v := x + s.gain*delayed
+	s.delay[s.pos] = v
+	s.pos = (s.pos + 1) % len(s.delay)
+	return delayed - s.gain*v
+}
+
+// AllpassDecorrelator is a cascade of Schroeder all-pass sections. Its
+// magnitude response is exactly flat; the decorrelation comes from the
+// rapidly varying phase.
+type AllpassDecorrelator struct {
+	sections []schroederAllpass
+}
+
+// NewAllpassDecorrelator creates an all-pass decorrelator. Decorrelators with
+// different seeds are decorrelated from each other as well.
+func NewAllpassDecorrelator(sampleRate float64, seed uint64) *AllpassDecorrelator {
+	rng := rand.New(rand.NewPCG(seed, 0x616c6c70617373))
+	d := &AllpassDecorrelator{sections: make([]schroederAllpass, len(allpassDecorrelatorDelays))}
+	for i, ms := range allpassDecorrelatorDelays {
+		// ±20% jitter per seed.
+		jitter := 0.8 + 0.4*rng.Float64()
+		delay := max(1, int(math.Round(ms*jitter*sampleRate/1000.0)))
+		d.sections[i] = schroederAllpass{gain: allpassDecorrelatorGain, delay: make([]float64, delay)}
+	}
+	return d
+}
+
+// Process filters one sample.
+func (d *AllpassDecorrelator) Process(x float64) float64 {
+	for i := range d.sections {
+		x = d.sections[i].process(x)
+	}
+	return x
+}
+
+// Reset clears the filter state.
+func (d *AllpassDecorrelator) Reset() {
+	for i := range d.sections {
+		clear(d.sections[i].delay)
+		d.sections[i].pos = 0
+	}
+}
+
+// Velvet noise parameters: the impulse response length in seconds, the
+// impulse density in impulses per second and the decay over the length in dB.
+const (
+	velvetLength  = 0.03
+	velvetDensity = 1500.0
+	velvetDecayDB = 30.0
+)
+
+// VelvetDecorrelator is a sparse FIR filter of exponentially decaying ±1
+// impulses (velvet noise), normalized to unity energy. Its magnitude
+// response is only approximately flat, but it has no recursive ringing.
+type VelvetDecorrelator struct {
+	taps    []int
+	weights []float64
+	history []float64
+	pos     int
+}
+
+// NewVelvetDecorrelator creates a velvet-noise decorrelator. Decorrelators
+// with different seeds are decorrelated from each other as well.
+func NewVelvetDecorrelator(sampleRate float64, seed uint64) *VelvetDecorrelator {
+	rng := rand.New(rand.NewPCG(seed, 0x76656c766574))
+	length := max(1, int(math.Round(velvetLength*sampleRate)))
+	grid := max(1.0, sampleRate/velvetDensity)
+
+	d := &VelvetDecorrelator{history: make([]float64, length)}
+	energy := 0.0
+	for start := 0.0; int(start) < length; start += grid {
+		tap := min(length-1, int(start+rng.Float64()*grid))
+		sign := 1.0
+		if rng.IntN(2) == 0 {
+			sign = -1.0
+		}
+		w := sign * math.Pow(10, -velvetDecayDB/20.0*float64(tap)/float64(length))
+		d.taps = append(d.taps, tap)
+		d.weights = append(d.weights, w)
+		energy += w * w
+	}
+	for i := range d.weights {
+		d.weights[i] /= math.Sqrt(energy)
+	}
+	return d
+}
+
+// Process filters one sample.
+func (d *VelvetDecorrelator) Process(x float64) float64 {
+	n := len(d.history)
+	d.history[d.pos] = x
+	y := 0.0
+	for i, tap := range d.taps {
+		y += d.weights[i] * d.history[(d.pos-tap+n)%n]
+	}
+	d.pos = (d.pos + 1) % n
+	return y
+}
+
+// Reset clears the filter state.
+func (d *VelvetDecorrelator) Reset() {
+	clear(d.history)
+	d.pos = 0
+}
+
+// BandDecorrelator blends a signal with its decorrelated version at a
+// separate strength per band. The dry and decorrelated signals are split by
+// identical crossover banks and every band is mixed with the energy
+// preserving gains cos(πs/2) and sin(πs/2) for strength s.
+type BandDecorrelator struct {
+	decorrelator Decorrelator
+	dry          *CrossoverBank
+	wet          *CrossoverBank
+	dryGains     []float64
+	wetGains     []float64
+	dryBands     []float64
+	wetBands     []float64
+}
+
+// NewBandDecorrelator creates a band decorrelator around d with crossovers at
+// freqs Hz (ascending) and one strength in [0, 1] per band, low to high.
+// Missing strengths are 1.
+func NewBandDecorrelator(d Decorrelator, freqs []float64, strengths []float64, sampleRate float64) *BandDecorrelator {
+	b := &BandDecorrelator{
+		decorrelator: d,
+		dry:          NewCrossoverBank(freqs, sampleRate),
+		wet:          NewCrossoverBank(freqs, sampleRate),
+		dryGains:     make([]float64, len(freqs)+1),
+		wetGains:     make([]float64, len(freqs)+1),
+		dryBands:     make([]float64, len(freqs)+1),
+		wetBands:     make([]float64, len(freqs)+1),
+	}
+	for i := range b.dryGains {
+		s := 1.0
+		if i < len(strengths) {
+			s = max(0, min(1, strengths[i]))
+		}
+		b.dryGains[i] = math.Cos(s * math.Pi / 2.0)
+		b.wetGains[i] = math.Sin(s * math.Pi / 2.0)
+	}
+	return b
+}
+
+// Process filters one sample.
+func (b *BandDecorrelator) Process(x float64) float64 {
+	b.dry.Process(x, b.dryBands)
+	b.wet.Process(b.decorrelator.Process(x), b.wetBands)
+	y := 0.0
+	for i := range b.dryBands {
+		y += b.dryGains[i]*b.dryBands[i] + b.wetGains[i]*b.wetBands[i]
+	}
+	return y
+}
+
+// Reset clears the filter state.
+func (b *BandDecorrelator) Reset() {
+	b.decorrelator.Reset()
+	b.dry.Reset()
+	b.wet.Reset()
+}
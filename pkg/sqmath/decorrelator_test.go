@@ -0,0 +1,118 @@
+package sqmath_test
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/cwbudde/go-sq-tool/pkg/sqmath"
+)
+
+// correlation returns the normalized zero-lag correlation of x and y.
+func correlation(x, y []float64) float64 {
+	var xy, xx, yy float64
+	for i := range x {
+		xy += x[i] * y[i]
+		xx += x[i] * x[i]
+		yy += y[i] * y[i]
+	}
+	return xy / math.Sqrt(xx*yy)
+}
+
+func TestDecorrelators_KeepEnergyAndDecorrelate(t *testing.T) {
+	t.Parallel()
+
+	const (
+		sampleRate = 44100.0
+		n          = 1 << 16
+	)
+	rng := rand.New(rand.NewPCG(1, 2))
+	noise := make([]float64, n)
+	for i := range noise {
+		noise[i] = rng.NormFloat64()
+	}
+
+	for _, tc := range []struct {
+		name string
+		new  func(seed uint64) sqmath.Decorrelator
+	}{
+		{"allpass", func(seed uint64) sqmath.Decorrelator { return sqmath.NewAllpassDecorrelator(sampleRate, seed) }},
+		{"velvet", func(seed uint64) sqmath.Decorrelator { return sqmath.NewVelvetDecorrelator(sampleRate, seed) }},
+	} {
+		// Unity energy of the impulse response.
+		d := tc.new(1)
+		energy := 0.0
+		for i := 0; i < n; i++ {
+			x := 0.0
+			if i == 0 {
+				x = 1
+			}
+			y := d.Process(x)
+			energy += y * y
+		}
+		if math.Abs(energy-1) > 1e-3 {
+			t.Errorf("%s: impulse energy = %.6f, want 1", tc.name, energy)
+		}
+
+		outputs := make([][]float64, 2)
+		for seed := range outputs {
+			d := tc.new(uint64(seed + 1))
+			outputs[seed] = make([]float64, n)
+			for i, x := range noise {
+				outputs[seed][i] = d.Process(x)
+			}
+		}
+		if c := correlation(noise, outputs[0]); math.Abs(c) > 0.1 {
+			t.Errorf("%s: correlation with the input = %.3f", tc.name, c)
+		}
+		if c := correlation(outputs[0], outputs[1]); math.Abs(c) > 0.1 {
+			t.Errorf("%s: correlation between seeds = %.3f", tc.name, c)
+		}
+	}
+}
+
+func TestBandDecorrelator_StrengthPerBand(t *testing.T) {
+	t.Parallel()
+
+	const (
+		sampleRate = 44100.0
+		n          = 1 << 16
+	)
+	rng := rand.New(rand.NewPCG(3, 4))
+	noise := make([]float64, n)
+	for i := range noise {
+		noise[i] = rng.NormFloat64()
+	}
+
+	// Zero strength leaves only the all-pass of the crossover bank.
+	bank := sqmath.NewCrossoverBank([]float64{1000}, sampleRate)
+	bands := make([]float64, bank.NumBands())
+	d := sqmath.NewBandDecorrelator(sqmath.NewAllpassDecorrelator(sampleRate, 1), []float64{1000}, []float64{0, 0}, sampleRate)
+	for i, x := range noise {
+		bank.Process(x, bands)
+		if got, want := d.Process(x), bands[0]+bands[1]; math.Abs(got-want) > 1e-12 {
+			t.Fatalf("sample %d: zero strength = %.9f, want %.9f", i, got, want)
+		}
+	}
+
+	// Full strength above the crossover only: the lows stay correlated with
+	// the zero-strength output, the highs do not.
+	dry := sqmath.NewBandDecorrelator(sqmath.NewAllpassDecorrelator(sampleRate, 1), []float64{1000}, []float64{0, 0}, sampleRate)
+	wet := sqmath.NewBandDecorrelator(sqmath.NewAllpassDecorrelator(sampleRate, 1), []float64{1000}, []float64{0, 1}, sampleRate)
+	drySplit := sqmath.NewCrossoverBank([]float64{1000}, sampleRate)
+	wetSplit := sqmath.NewCrossoverBank([]float64{1000}, sampleRate)
+	dryBands := [][]float64{make([]float64, n), make([]float64, n)}
+	wetBands := [][]float64{make([]float64, n), make([]float64, n)}
+	for i, x := range noise {
+		drySplit.Process(dry.Process(x), bands)
+		dryBands[0][i], dryBands[1][i] = bands[0], bands[1]
+		wetSplit.Process(wet.Process(x), bands)
+		wetBands[0][i], wetBands[1][i] = bands[0], bands[1]
+	}
+	if c := correlation(dryBands[0], wetBands[0]); c < 0.9 {
+		t.Errorf("low band correlation = %.3f, want > 0.9", c)
+	}
+	if c := correlation(dryBands[1], wetBands[1]); math.Abs(c) > 0.1 {
+		t.Errorf("high band correlation = %.3f, want ~0", c)
+	}
+}
@@ -32,10 +32,29 @@ type HilbertTransformer struct {
 // NewHilbertTransformer creates a new Hilbert transformer
 // blockSize: FFT block size (should be power of 2)
 // overlap: overlap in samples (typically blockSize/2)
+// The filter has unity passband gain and a group delay of overlap/2 samples.
 func NewHilbertTransformer(blockSize, overlap int) *HilbertTransformer {
 	return NewHilbertTransformerWithWindow(blockSize, overlap, WindowHann)
 }
 
+// HilbertLookbehind returns the number of past samples at the start of every
+// block fed to a transformer with the given overlap.
+func HilbertLookbehind(overlap int) int {
+	return overlap / 4
+}
+
+// HilbertOffsets returns the block indices at which the direct and the
+// 90°-shifted path of the first output sample are read. Blocks start
+// HilbertLookbehind samples before their first output sample. The direct path
+// is read at the SQ² input offset of overlap/4 after that, and the shifted
+// path a further overlap/2 later to compensate the group delay of the filter,
+// so both paths are time aligned and the filter has full support inside the
+// block.
+func HilbertOffsets(overlap int) (direct, shifted int) {
+	direct = HilbertLookbehind(overlap) + overlap/4
+	return direct, direct + overlap/2
+}
+
 // NewHilbertTransformerWithWindow creates a new Hilbert transformer with a selectable window.
 // windowType: one of WindowHann/WindowHamming/WindowBlackman/WindowRectangular.
 func NewHilbertTransformerWithWindow(blockSize, overlap int, windowType WindowType) *HilbertTransformer {
@@ -78,11 +97,6 @@ func (ht *HilbertTransformer) makeFilter() {
 		impulse[i] *= ht.window[i]
 	}
 
-	// Scale by 1.8 (from original implementation)
-	for i := 0; i < ht.overlap; i++ {
-		impulse[i] *= 1.8
-	}
-
 	// Convert to complex for FFT
 	impulseComplex := make([]complex128, ht.fftSize)
 	for i := range impulse {
@@ -193,11 +207,10 @@ func (ht *HilbertTransformer) ProcessBlock(input []float64) []float64 {
 		panic(err)
 	}
 
-	// Extract real part and rescale
+	// Extract real part (the inverse FFT is already normalized by 1/N)
 	output := make([]float64, ht.blockSize)
-	scale := 1.0 / float64(ht.fftSize)
 	for i := 0; i < ht.blockSize; i++ {
-		output[i] = real(timeDomain[i]) * scale
+		output[i] = real(timeDomain[i])
 	}
 
 	return output
@@ -0,0 +1,91 @@
+package sqmath
+
+import (
+	"math"
+	"math/cmplx"
+
+	algofft "github.com/MeKo-Christian/algo-fft"
+)
+
+// Spherical head model parameters (Brown & Duda, "A Structural Model for
+// Binaural Sound Synthesis", 1998).
+const (
+	headRadius   = 0.0875 // m
+	speedOfSound = 343.0  // m/s
+	// shadowMinAlpha and shadowMinAngle place the deepest head shadow
+	// (alpha = 0.1) at 150° from the ear.
+	shadowMinAlpha = 0.1
+	shadowMinAngle = 150.0 * math.Pi / 180.0
+	// sphericalHeadPreDelay leaves room for the pre-ringing of the
+	// fractional interaural delays.
+	sphericalHeadPreDelay = 0.0005 // s
+	// sphericalHeadLength is the minimum impulse response length.
+	sphericalHeadLength = 0.004 // s
+)
+
+// SphericalHeadHRIR returns the left- and right-ear impulse responses of a
+// rigid spherical head for a source in the horizontal plane at azimuth
+// degrees (0 = front, positive towards the left). Each ear gets the head
+// shadow filter and the interaural delay of its angle to the source; both
+// responses share a pre-delay of 0.5 ms and have unity gain at DC.
+func SphericalHeadHRIR(azimuth, sampleRate float64) ([]float64, []float64) {
+	n := 1
+	for float64(n) < sphericalHeadLength*sampleRate {
+		n <<= 1
+	}
+	n = max(n, 64)
+
+	return sphericalHeadEar(azimuth-90.0, sampleRate, n), sphericalHeadEar(azimuth+90.0, sampleRate, n)
+}
+
+// sphericalHeadEar returns the n-tap response of an ear at angle degrees
+// between the ear axis and the source.
+func sphericalHeadEar(angle, sampleRate float64, n int) []float64 {
+	theta := math.Abs(math.Remainder(angle*math.Pi/180.0, 2.0*math.Pi))
+
+	// Head shadow: a one-pole, one-zero filter whose zero moves with the
+	// angle (boost towards the ear, shadow behind the head).
+	w0 := speedOfSound / headRadius
+	alpha := (1.0 + shadowMinAlpha/2.0) + (1.0-shadowMinAlpha/2.0)*math.Cos(theta/shadowMinAngle*math.Pi)
+
+	// Path length difference relative to the head centre, shifted to be
+	// non-negative: straight for the lit side, around the sphere otherwise.
+	delay := headRadius / speedOfSound
+	if theta < math.Pi/2.0 {
+		delay *= 1.0 - math.Cos(theta)
+	} else {
+		delay *= 1.0 + theta - math.Pi/2.0
+	}
+	delay += sphericalHeadPreDelay
+
+	spectrum := make([]complex128, n)
+	for k := 0; k <= n/2; k++ {
+		w := 2.0 * math.Pi * float64(k) / float64(n) * sampleRate
+		shadow := complex(1.0, alpha*w/(2.0*w0)) / complex(1.0, w/(2.0*w0))
+		spectrum[k] = shadow * cmplx.Exp(complex(0, -w*delay))
+		if k > 0 && k < n/2 {
+			spectrum[n-k] = cmplx.Conj(spectrum[k])
+		}
+	}
+	spectrum[n/2] = complex(real(spectrum[n/2]), 0)
+
+	plan, err := algofft.NewPlan64(n)
+	if err != nil {
+		panic(err)
+	}
+	response := make([]complex128, n)
+	if err := plan.Inverse(response, spectrum); err != nil {
+		panic(err)
+	}
+
+	// Fade out the last quarter to suppress circular wrap-around.
+	ir := make([]float64, n)
+	fade := n / 4
+	for i := range ir {
+		ir[i] = real(response[i])
+		if i >= n-fade {
+			ir[i] *= 0.5 * (1.0 + math.Cos(math.Pi*float64(i-(n-fade))/float64(fade)))
+		}
+	}
+	return ir
+}
@@ -0,0 +1,72 @@
+package sqmath_test
+
+import (
+	"math"
+	"math/cmplx"
+	"testing"
+
+	"github.com/cwbudde/go-sq-tool/pkg/sqmath"
+)
+
+// responseAt returns the complex response of ir at freq Hz.
+func responseAt(ir []float64, freq, sampleRate float64) complex128 {
+	var sum complex128
+	for n, v := range ir {
+		sum += complex(v, 0) * cmplx.Exp(complex(0, -2*math.Pi*freq*float64(n)/sampleRate))
+	}
+	return sum
+}
+
+// peakIndex returns the index of the largest absolute sample.
+func peakIndex(ir []float64) int {
+	best := 0
+	for i, v := range ir {
+		if math.Abs(v) > math.Abs(ir[best]) {
+			best = i
+		}
+	}
+	return best
+}
+
+func TestSphericalHeadHRIR_InterauralCues(t *testing.T) {
+	t.Parallel()
+
+	const sampleRate = 96000.0
+	left, right := sphericalHeadPair(t, 90, sampleRate)
+
+	// DC gain is unity at both ears.
+	for name, ir := range map[string][]float64{"left": left, "right": right} {
+		if g := cmplx.Abs(responseAt(ir, 0, sampleRate)); math.Abs(g-1) > 0.01 {
+			t.Errorf("%s DC gain = %.4f, want 1", name, g)
+		}
+	}
+
+	// Woodworth ITD of a source at the side: (a/c)(1 + π/2) ≈ 0.66 ms.
+	itd := float64(peakIndex(right)-peakIndex(left)) / sampleRate
+	if math.Abs(itd-0.000655) > 0.00005 {
+		t.Errorf("ITD = %.6f s, want about 0.000655 s", itd)
+	}
+
+	// Head shadow: the far ear loses high frequencies.
+	ild := 20 * math.Log10(cmplx.Abs(responseAt(left, 8000, sampleRate))/cmplx.Abs(responseAt(right, 8000, sampleRate)))
+	if ild < 10 {
+		t.Errorf("8 kHz ILD = %.2f dB, want > 10 dB", ild)
+	}
+
+	// A frontal source reaches both ears identically.
+	frontLeft, frontRight := sphericalHeadPair(t, 0, sampleRate)
+	for i := range frontLeft {
+		if math.Abs(frontLeft[i]-frontRight[i]) > 1e-12 {
+			t.Fatalf("front source: ears differ at tap %d", i)
+		}
+	}
+}
+
+func sphericalHeadPair(t *testing.T, azimuth, sampleRate float64) ([]float64, []float64) {
+	t.Helper()
+	left, right := sqmath.SphericalHeadHRIR(azimuth, sampleRate)
+	if len(left) != len(right) || len(left) == 0 {
+		t.Fatalf("HRIR lengths %d/%d", len(left), len(right))
+	}
+	return left, right
+}
@@ -0,0 +1,223 @@
+package sqmath
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+
+	algofft "github.com/MeKo-Christian/algo-fft"
+)
+
+// STFTFrameFunc processes the spectra of one STFT frame. in holds the
+// spectra of the input channels. The function must set bins 0 to fftSize/2
+// of every output spectrum in out; the STFT mirrors the negative
+// frequencies so that the output stays real.
+type STFTFrameFunc func(in, out [][]complex128)
+
+// STFT is a streaming short-time Fourier transform engine. It cuts the
+// input channels into overlapping frames with a square-root Hann window,
+// hands their spectra to a per-frame function and overlap-adds the output
+// spectra with the same window, so a function that copies its input
+// reconstructs the input exactly.
+//
+// The output is time aligned with the input: internally frames start
+// Latency samples before the stream, and that delay is discarded again.
+type STFT struct {
+	fftSize int
+	hop     int
+	process STFTFrameFunc
+	fftPlan *algofft.Plan[complex128]
+	window  []float64
+
+	inputBuffers  [][]float64
+	bufferPos     int
+	outputBuffers [][]float64
+	discard       int
+	consumed      int
+	emitted       int
+
+	frame    []complex128
+	spectrum [][]complex128
+	outSpec  [][]complex128
+}
+
+// NewSTFT creates an STFT with inputs input and outputs output channels, an
+// FFT size (power of 2) and a hop size in (0, fftSize/2].
+func NewSTFT(fftSize, hop, inputs, outputs int, process STFTFrameFunc) (*STFT, error) {
+	if fftSize <= 0 || fftSize&(fftSize-1) != 0 {
+		return nil, fmt.Errorf("FFT size must be a power of 2, got %d", fftSize)
+	}
+	if hop <= 0 || hop > fftSize/2 {
+		return nil, fmt.Errorf("hop must be in (0, %d], got %d", fftSize/2, hop)
+	}
+	plan, err := algofft.NewPlan64(fftSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create FFT plan: %w", err)
+	}
+
+	s := &STFT{
+		fftSize:       fftSize,
+		hop:           hop,
+		process:       process,
+		fftPlan:       plan,
+		window:        make([]float64, fftSize),
+		inputBuffers:  make([][]float64, inputs),
+		outputBuffers: make([][]float64, outputs),
+		frame:         make([]complex128, fftSize),
+		spectrum:      make([][]complex128, inputs),
+		outSpec:       make([][]complex128, outputs),
+	}
+
+	// Square-root periodic Hann analysis and synthesis windows, scaled so
+	// that the overlap-added product sums to one. An output sample is
+	// covered by the window samples n, n+hop, n+2*hop, ... of the frames
+	// overlapping it, so every sample is normalized by the Hann sum of its
+	// phase n mod hop. The sums are equal when hop divides fftSize; for
+	// other hops they differ and a single scale would leave a gain ripple.
+	hann := make([]float64, fftSize)
+	sums := make([]float64, hop)
+	for n := range hann {
+		hann[n] = 0.5 * (1.0 - math.Cos(2.0*math.Pi*float64(n)/float64(fftSize)))
+		sums[n%hop] += hann[n]
+	}
+	for n := range s.window {
+		s.window[n] = math.Sqrt(hann[n] / sums[n%hop])
+	}
+
+	for ch := 0; ch < inputs; ch++ {
+		s.inputBuffers[ch] = make([]float64, fftSize)
+		s.spectrum[ch] = make([]complex128, fftSize)
+	}
+	for ch := 0; ch < outputs; ch++ {
+		s.outputBuffers[ch] = make([]float64, fftSize)
+		s.outSpec[ch] = make([]complex128, fftSize)
+	}
+
+	s.Reset()
+	return s, nil
+}
+
+// FFTSize returns the frame length in samples.
+func (s *STFT) FFTSize() int {
+	return s.fftSize
+}
+
+// Hop returns the frame advance in samples.
+func (s *STFT) Hop() int {
+	return s.hop
+}
+
+// Latency returns the streaming latency in samples.
+func (s *STFT) Latency() int {
+	return s.fftSize - s.hop
+}
+
+// ProcessChunk feeds an arbitrary-sized chunk of a continuous stream and
+// appends the output of every hop that became complete to output. Only the
+// first len(output) output channels are synthesized. input must have the
+// STFT's input channel count with equal lengths.
+func (s *STFT) ProcessChunk(output, input [][]float64) {
+	numSamples := len(input[0])
+	srcIdx := 0
+	for srcIdx < numSamples {
+		n := copy(s.inputBuffers[0][s.bufferPos:], input[0][srcIdx:])
+		for ch := 1; ch < len(s.inputBuffers); ch++ {
+			copy(s.inputBuffers[ch][s.bufferPos:], input[ch][srcIdx:srcIdx+n])
+		}
+		s.bufferPos += n
+		s.consumed += n
+		srcIdx += n
+
+		if s.bufferPos < s.fftSize {
+			break
+		}
+		s.processFrame(output)
+	}
+}
+
+// Flush zero-pads the samples still buffered by ProcessChunk and appends
+// their output to output. The STFT is ready for a new stream afterwards.
+func (s *STFT) Flush(output [][]float64) {
+	for s.emitted < s.consumed {
+		for ch := range s.inputBuffers {
+			for i := s.bufferPos; i < s.fftSize; i++ {
+				s.inputBuffers[ch][i] = 0
+			}
+		}
+		s.bufferPos = s.fftSize
+		s.processFrame(output)
+	}
+
+	s.Reset()
+}
+
+// Reset discards buffered input and output.
+func (s *STFT) Reset() {
+	for ch := range s.inputBuffers {
+		for i := range s.inputBuffers[ch] {
+			s.inputBuffers[ch][i] = 0
+		}
+	}
+	for ch := range s.outputBuffers {
+		for i := range s.outputBuffers[ch] {
+			s.outputBuffers[ch][i] = 0
+		}
+	}
+
+	// Frames start fftSize-hop samples before the stream so that the first
+	// output samples are covered by every overlapping frame.
+	s.bufferPos = s.Latency()
+	s.discard = s.Latency()
+	s.consumed = 0
+	s.emitted = 0
+}
+
+// processFrame transforms the full input buffer, appends one hop of output
+// (minus latency and padding) and advances the buffers by one hop.
+func (s *STFT) processFrame(output [][]float64) {
+	for ch := range s.inputBuffers {
+		for i := 0; i < s.fftSize; i++ {
+			s.frame[i] = complex(s.inputBuffers[ch][i]*s.window[i], 0)
+		}
+		if err := s.fftPlan.Forward(s.spectrum[ch], s.frame); err != nil {
+			panic(err)
+		}
+	}
+
+	s.process(s.spectrum, s.outSpec)
+
+	for ch := range output {
+		spectrum := s.outSpec[ch]
+		for k := 1; k < s.fftSize/2; k++ {
+			spectrum[s.fftSize-k] = cmplx.Conj(spectrum[k])
+		}
+		if err := s.fftPlan.Inverse(s.frame, spectrum); err != nil {
+			panic(err)
+		}
+		for i := 0; i < s.fftSize; i++ {
+			s.outputBuffers[ch][i] += real(s.frame[i]) * s.window[i]
+		}
+	}
+
+	// The first hop of the output buffers is complete.
+	start := min(s.discard, s.hop)
+	end := min(s.hop, start+s.consumed-s.emitted)
+	s.discard -= start
+	if end > start {
+		for ch := range output {
+			output[ch] = append(output[ch], s.outputBuffers[ch][start:end]...)
+		}
+		s.emitted += end - start
+	}
+
+	for ch := range s.outputBuffers {
+		copy(s.outputBuffers[ch], s.outputBuffers[ch][s.hop:])
+		for i := s.fftSize - s.hop; i < s.fftSize; i++ {
+			s.outputBuffers[ch][i] = 0
+		}
+	}
+	for ch := range s.inputBuffers {
+		copy(s.inputBuffers[ch], s.inputBuffers[ch][s.hop:])
+	}
+	s.bufferPos -= s.hop
+}
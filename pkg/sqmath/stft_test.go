@@ -0,0 +1,72 @@
+package sqmath_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/cwbudde/go-sq-tool/pkg/sqmath"
+)
+
+func TestSTFT_IdentityReconstructsInput(t *testing.T) {
+	t.Parallel()
+
+	const n = 5000
+
+	input := [][]float64{make([]float64, n), make([]float64, n)}
+	for i := 0; i < n; i++ {
+		input[0][i] = math.Sin(float64(i) / 7.0)
+		input[1][i] = math.Cos(float64(i)/13.0) * 0.5
+	}
+
+	// 96 and 200 do not divide the FFT size.
+	for _, hop := range []int{64, 96, 128, 200, 256} {
+		// Copy input 0 to output 1 and input 1 to output 0.
+		stft, err := sqmath.NewSTFT(512, hop, 2, 2, func(in, out [][]complex128) {
+			for k := 0; k <= 256; k++ {
+				out[0][k] = in[1][k]
+				out[1][k] = in[0][k]
+			}
+		})
+		if err != nil {
+			t.Fatalf("NewSTFT() error = %v", err)
+		}
+
+		// Feed uneven chunks; ProcessChunk and Flush together give exactly
+		// n time-aligned samples.
+		output := make([][]float64, 2)
+		for pos := 0; pos < n; pos += 777 {
+			end := min(pos+777, n)
+			stft.ProcessChunk(output, [][]float64{input[0][pos:end], input[1][pos:end]})
+		}
+		stft.Flush(output)
+
+		for ch := range output {
+			if len(output[ch]) != n {
+				t.Fatalf("hop %d: channel %d has %d samples, want %d", hop, ch, len(output[ch]), n)
+			}
+			for i := range output[ch] {
+				if math.Abs(output[ch][i]-input[1-ch][i]) > 1e-12 {
+					t.Fatalf("hop %d: output[%d][%d] = %g, want %g", hop, ch, i, output[ch][i], input[1-ch][i])
+				}
+			}
+		}
+	}
+}
+
+func TestNewSTFT_Errors(t *testing.T) {
+	t.Parallel()
+
+	noop := func(in, out [][]complex128) {}
+	for _, tc := range []struct {
+		fftSize, hop int
+	}{
+		{1000, 250},
+		{0, 1},
+		{1024, 0},
+		{1024, 768},
+	} {
+		if _, err := sqmath.NewSTFT(tc.fftSize, tc.hop, 1, 1, noop); err == nil {
+			t.Errorf("NewSTFT(%d, %d) error = nil", tc.fftSize, tc.hop)
+		}
+	}
+}
@@ -9,6 +9,7 @@ const fileName = document.getElementById("file-name");
 const fileSize = document.getElementById("file-size");
 const downloadLink = document.getElementById("download-link");
 const logicToggle = document.getElementById("logic-toggle");
+const waveToggle = document.getElementById("wave-toggle");
 const floatToggle = document.getElementById("float-toggle");
 
 const MAX_FILE_BYTES = 100 * 1024 * 1024;
@@ -158,6 +159,7 @@ const decodeFile = async () => {
     const inputBytes = new Uint8Array(arrayBuffer);
     const result = window.sqDecodeWav(inputBytes, {
       logic: logicToggle.checked,
+      waveMatching: waveToggle.checked,
       float32: floatToggle.checked,
     });
     if (result && result.error) {